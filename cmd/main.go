@@ -2,8 +2,7 @@ package main
 
 import (
 	"bufio"
-	"crypto/rand"
-	"io"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"os/signal"
@@ -11,6 +10,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	goMetrics "github.com/rcrowley/go-metrics"
@@ -21,6 +21,7 @@ import (
 	bolt "go.etcd.io/bbolt"
 
 	"github.com/bookingcom/bpfink/pkg"
+	"github.com/bookingcom/bpfink/pkg/lang/generic"
 )
 
 // nolint:gochecknoglobals
@@ -42,7 +43,16 @@ type (
 		Database      string
 		Keyfile       string
 		key           []byte
+		Digest        string
+		Backend       string
 		BCC           string `mapstructure:"bcc"`
+		BCCDir        string `mapstructure:"bccDir"`
+		AncestryDepth int
+		// Rules describe expected writers, i.e. configuration management, and how their changes are reported
+		Rules pkg.Rules
+		// Sinks receive the changes reported by consumers, which are logged when there is none
+		Sinks         []SinkConfig
+		alerts        *pkg.Alerts
 		MetricsConfig struct {
 			GraphiteHost       string
 			GraphiteMode       int
@@ -51,18 +61,72 @@ type (
 			HostRolePath       string
 			HostRoleKey        string
 			HostRoleToken      string
+			// PrometheusAddress serves /metrics, /healthz and /readyz when set, i.e. ":9115"
+			PrometheusAddress string
 		}
 		Consumers struct {
 			Root        string
 			Access      string
+			Sudoers     string
+			PAM         string // pam.d, its service files are parsed as ordered stacks
 			GenericDiff []string
 			Users       struct {
 				Shadow, Passwd string
+				// SSHDConfig is read for AuthorizedKeysFile, Dotfiles are home relative templates
+				SSHDConfig string
+				Dotfiles   []string
+			}
+			Groups struct {
+				Group, GShadow string
+				// Privileged groups are flagged when their members change
+				Privileged []string
+			}
+			SSHD struct {
+				Config string
+				// Critical settings raise the level of their changes to error, pkg.DefaultSSHDCritical when empty
+				Critical []string
+			}
+			Cron struct {
+				Crontab string
+				// Dirs of system crontabs, Scripts of run-parts directories, Spools of per user crontabs
+				Dirs, Scripts, Spools []string
+			}
+			Systemd struct {
+				// Dirs are the unit directories by decreasing priority, Keys the settings compared
+				Dirs, Keys []string
+			}
+			Loader struct {
+				// Preload is ld.so.preload, Config ld.so.conf, Dirs the trusted library directories
+				Preload, Config string
+				Dirs            []string
 			}
 			Generic  []string
 			Excludes []string
+			// NotifyOnEmptyDB reports changes found at start up even for files without a persisted state
+			NotifyOnEmptyDB bool
 		}
 	}
+	// SinkConfig describes one output of alerts, Level is the minimum level it receives
+	SinkConfig struct {
+		Type, Level string
+		Format      string // json, cef or ecs, json by default and the only one of journald
+		// Path, MaxSize in megabytes, MaxAge and Backups of the file sink
+		Path     string
+		MaxSize  int64
+		MaxAge   time.Duration
+		Backups  int
+		Socket   string // syslog and journald socket, their default one when empty
+		Facility string // syslog facility, authpriv by default
+		Tag      string // syslog app name and journald identifier, bpfink by default
+		// URL, Headers, TLS files, batching and retries of the webhook sink, its defaults when zero
+		URL                    string
+		Headers                map[string]string
+		CA, Cert, Key          string
+		BatchSize              int
+		FlushInterval, Timeout time.Duration
+		MaxBackoff             time.Duration
+		MaxAttempts, MaxQueue  int
+	}
 	// filesToMonitor is the struct for watching files, used for generic and generic diff consumers
 	FileInfo struct {
 		File  string
@@ -76,10 +140,16 @@ type (
 const (
 	// DefaultConfigFile default config file location
 	DefaultConfigFile = "/etc/bpfink.toml"
+	// DefaultBCCDir default location of the vfs-X.Y.o BPF objects
+	DefaultBCCDir = "/usr/lib/bpfink"
 	// DefaultDatabase default database file location
 	DefaultDatabase       = "/var/lib/bpfink.db"
 	puppetFileColumnCount = 2
 	keySize               = 16
+	// ebpfBackend event source relying on the vfs kprobes
+	ebpfBackend = "ebpf"
+	// inotifyBackend event source relying on inotify, for hosts where kprobes can not be loaded
+	inotifyBackend = "inotify"
 )
 
 // LogHook to send a graphite metric for each log entry
@@ -121,13 +191,10 @@ func (c Configuration) logger() (logger zerolog.Logger) {
 Initialises all the consumers along with pre-populating genericDiffPaths used by watcher
 */
 func (c Configuration) consumers(db *pkg.AgentDB, genericDiffPaths *[]string) (consumers pkg.BaseConsumers) {
-	fs := afero.NewOsFs()
+	fs := c.fs()
 	var existingConsumersFiles = make(map[string]bool)
 	listOfRegexpsExcludes := c.compileRegex(c.Consumers.Excludes)
 
-	if c.Consumers.Root != "" {
-		fs = afero.NewBasePathFs(fs, c.Consumers.Root)
-	}
 	if c.Consumers.Access != "" {
 		if !c.isFileToBeExcluded(c.Consumers.Access, existingConsumersFiles, listOfRegexpsExcludes) {
 			state := &pkg.AccessState{
@@ -135,10 +202,25 @@ func (c Configuration) consumers(db *pkg.AgentDB, genericDiffPaths *[]string) (c
 					pkg.AccessFileOpt(fs, c.Consumers.Access, c.logger()),
 				),
 			}
-			consumers = append(consumers, &pkg.BaseConsumer{AgentDB: db, ParserLoader: state})
+			consumers = append(consumers, c.baseConsumer(db, state))
 			existingConsumersFiles[c.Consumers.Access] = true
 		}
 	}
+	if c.Consumers.SSHD.Config != "" {
+		if !c.isFileToBeExcluded(c.Consumers.SSHD.Config, existingConsumersFiles, listOfRegexpsExcludes) {
+			state := &pkg.SSHDState{
+				SSHDListener: pkg.NewSSHDListener(
+					pkg.SSHDFileOpt(fs, c.Consumers.SSHD.Config, c.logger()),
+					func(l *pkg.SSHDListener) { l.Critical = c.Consumers.SSHD.Critical },
+				),
+			}
+			consumers = append(consumers, c.baseConsumer(db, state))
+			// included files and directories are parsed as part of sshd_config, not by generic consumers
+			for _, file := range state.Files() {
+				existingConsumersFiles[file] = true
+			}
+		}
+	}
 	if c.Consumers.Users.Shadow != "" && c.Consumers.Users.Passwd != "" {
 		if !c.isFileToBeExcluded(c.Consumers.Users.Shadow, existingConsumersFiles, listOfRegexpsExcludes) ||
 			!c.isFileToBeExcluded(c.Consumers.Users.Passwd, existingConsumersFiles, listOfRegexpsExcludes) {
@@ -146,14 +228,114 @@ func (c Configuration) consumers(db *pkg.AgentDB, genericDiffPaths *[]string) (c
 				UsersListener: pkg.NewUsersListener(func(l *pkg.UsersListener) {
 					l.Passwd = c.Consumers.Users.Passwd
 					l.Shadow = c.Consumers.Users.Shadow
+					l.SSHDConfig = c.Consumers.Users.SSHDConfig
+					l.SSHDConfigWatched = existingConsumersFiles[c.Consumers.Users.SSHDConfig]
+					l.Dotfiles = c.Consumers.Users.Dotfiles
 					l.Fs, l.Logger = fs, c.logger()
 				}),
 			}
-			consumers = append(consumers, &pkg.BaseConsumer{AgentDB: db, ParserLoader: state})
+			consumers = append(consumers, c.baseConsumer(db, state))
 			existingConsumersFiles[c.Consumers.Users.Shadow] = true
 			existingConsumersFiles[c.Consumers.Users.Passwd] = true
 		}
 	}
+	if c.Consumers.Groups.Group != "" {
+		if !c.isFileToBeExcluded(c.Consumers.Groups.Group, existingConsumersFiles, listOfRegexpsExcludes) {
+			state := &pkg.GroupsState{
+				GroupsListener: pkg.NewGroupsListener(func(l *pkg.GroupsListener) {
+					l.Group = c.Consumers.Groups.Group
+					l.GShadow = c.Consumers.Groups.GShadow
+					l.Privileged = c.Consumers.Groups.Privileged
+					l.Fs, l.Logger = fs, c.logger()
+				}),
+			}
+			consumers = append(consumers, c.baseConsumer(db, state))
+			existingConsumersFiles[c.Consumers.Groups.Group] = true
+			if c.Consumers.Groups.GShadow != "" {
+				existingConsumersFiles[c.Consumers.Groups.GShadow] = true
+			}
+		}
+	}
+	if c.Consumers.Sudoers != "" {
+		if !c.isFileToBeExcluded(c.Consumers.Sudoers, existingConsumersFiles, listOfRegexpsExcludes) {
+			state := &pkg.SudoersState{
+				SudoersListener: pkg.NewSudoersListener(
+					pkg.SudoersFileOpt(fs, c.Consumers.Sudoers, c.logger()),
+				),
+			}
+			consumers = append(consumers, c.baseConsumer(db, state))
+			// included files and directories are parsed as part of sudoers, not by generic consumers
+			for _, file := range state.Files() {
+				existingConsumersFiles[file] = true
+			}
+		}
+	}
+	if c.Consumers.PAM != "" {
+		if !c.isFileToBeExcluded(c.Consumers.PAM, existingConsumersFiles, listOfRegexpsExcludes) {
+			state := &pkg.PAMState{
+				PAMListener: pkg.NewPAMListener(
+					pkg.PAMFileOpt(fs, c.Consumers.PAM, c.logger()),
+				),
+			}
+			consumers = append(consumers, c.baseConsumer(db, state))
+			// service files are parsed as part of pam, not by generic consumers
+			for _, file := range state.Files() {
+				existingConsumersFiles[file] = true
+			}
+		}
+	}
+	if c.Consumers.Loader.Config != "" {
+		if !c.isFileToBeExcluded(c.Consumers.Loader.Config, existingConsumersFiles, listOfRegexpsExcludes) {
+			state := &pkg.LoaderState{
+				LoaderListener: pkg.NewLoaderListener(func(l *pkg.LoaderListener) {
+					l.Preload = c.Consumers.Loader.Preload
+					l.Config = c.Consumers.Loader.Config
+					l.Dirs = c.Consumers.Loader.Dirs
+					l.Fs, l.Logger = fs, c.logger()
+				}),
+			}
+			consumers = append(consumers, c.baseConsumer(db, state))
+			// included files and library directories are parsed as part of the loader, not by generic consumers
+			for _, file := range state.Files() {
+				existingConsumersFiles[file] = true
+			}
+		}
+	}
+	// any of the system crontab, crontab directories, run-parts directories and spools enables cron
+	crontab := c.Consumers.Cron.Crontab
+	if crontab != "" && c.isFileToBeExcluded(crontab, existingConsumersFiles, listOfRegexpsExcludes) {
+		crontab = ""
+	}
+	cronDirs := c.filesNotExcluded(c.Consumers.Cron.Dirs, existingConsumersFiles, listOfRegexpsExcludes)
+	cronScripts := c.filesNotExcluded(c.Consumers.Cron.Scripts, existingConsumersFiles, listOfRegexpsExcludes)
+	cronSpools := c.filesNotExcluded(c.Consumers.Cron.Spools, existingConsumersFiles, listOfRegexpsExcludes)
+	if crontab != "" || len(cronDirs)+len(cronScripts)+len(cronSpools) > 0 {
+		state := &pkg.CronState{
+			CronListener: pkg.NewCronListener(func(l *pkg.CronListener) {
+				l.Crontab, l.Dirs, l.Scripts, l.Spools = crontab, cronDirs, cronScripts, cronSpools
+				l.Fs, l.Logger = fs, c.logger()
+			}),
+		}
+		consumers = append(consumers, c.baseConsumer(db, state))
+		// crontabs, scripts and their directories are parsed as part of cron, not by generic consumers
+		for _, file := range state.Files() {
+			existingConsumersFiles[file] = true
+		}
+	}
+	if systemdDirs := c.filesNotExcluded(c.Consumers.Systemd.Dirs, existingConsumersFiles, listOfRegexpsExcludes); len(systemdDirs) > 0 {
+		state := &pkg.SystemdState{
+			SystemdListener: pkg.NewSystemdListener(func(l *pkg.SystemdListener) {
+				l.Dirs = systemdDirs
+				l.Keys = c.Consumers.Systemd.Keys
+				l.Fs, l.Logger = fs, c.logger()
+			}),
+		}
+		consumers = append(consumers, c.baseConsumer(db, state))
+		// everything under the unit directories, enablement links included, is parsed as part of the units
+		for _, file := range c.getListOfFiles(fs, systemdDirs) {
+			existingConsumersFiles[file.File] = true
+		}
+	}
 	if len(c.Consumers.GenericDiff) > 0 {
 		//get list of files to watch
 		genericDiffFiles := c.getListOfFiles(fs, c.Consumers.GenericDiff)
@@ -164,7 +346,7 @@ func (c Configuration) consumers(db *pkg.AgentDB, genericDiffPaths *[]string) (c
 						pkg.GenericDiffFileOpt(fs, genericDiffFile.File, c.logger()),
 					),
 				}
-				consumers = append(consumers, &pkg.BaseConsumer{AgentDB: db, ParserLoader: state})
+				consumers = append(consumers, c.baseConsumer(db, state))
 				existingConsumersFiles[genericDiffFile.File] = true
 				//this variable is used by watcher to get the complete list of paths to monitor, instead of the list from the config
 				*genericDiffPaths = append(*genericDiffPaths, genericDiffFile.File)
@@ -181,17 +363,152 @@ func (c Configuration) consumers(db *pkg.AgentDB, genericDiffPaths *[]string) (c
 						l.File = genericFile.File
 						l.IsDir = genericFile.IsDir
 						l.Key = c.key
+						l.Digest = c.Digest
 						l.Fs = fs
 						l.Logger = c.logger()
 					}),
 				}
-				consumers = append(consumers, &pkg.BaseConsumer{AgentDB: db, ParserLoader: state})
+				consumers = append(consumers, c.baseConsumer(db, state))
 			}
 		}
 	}
 	return consumers
 }
 
+// Returns the filesystem the consumers read, rooted at Consumers.Root when set
+func (c Configuration) fs() afero.Fs {
+	if c.Consumers.Root != "" {
+		return afero.NewBasePathFs(afero.NewOsFs(), c.Consumers.Root)
+	}
+	return afero.NewOsFs()
+}
+
+// Lists the files watched for the given paths, directories left out
+func (c Configuration) files(paths []string) (files []string) {
+	for _, file := range c.getListOfFiles(c.fs(), paths) {
+		if !file.IsDir {
+			files = append(files, file.File)
+		}
+	}
+	return files
+}
+
+// Wraps a consumer state into a base consumer
+func (c Configuration) baseConsumer(db *pkg.AgentDB, state pkg.ParserLoader) *pkg.BaseConsumer {
+	consumer := &pkg.BaseConsumer{AgentDB: db, ParserLoader: state, NotifyOnEmptyDB: c.Consumers.NotifyOnEmptyDB}
+	// consumers are not measured when metrics failed to initialize, like the log metrics
+	consumer.Metrics, _ = c.metrics()
+	// the dispatcher is shared so consumers kept on reload follow the sinks, it logs changes itself without sinks
+	consumer.Alerts = c.alerts
+	return consumer
+}
+
+// Builds the configured sinks, an error is returned for unknown types, levels or facilities.
+// The database keeps the alerts of webhooks until they are delivered.
+func (c Configuration) newAlerts(database *pkg.AgentDB) (*pkg.Alerts, error) {
+	logger := c.logger()
+	// webhook metrics are left out when metrics failed to initialize, like the log metrics
+	metrics, _ := c.metrics()
+	alerts := pkg.NewAlerts(func(a *pkg.Alerts) { a.Logger = logger })
+	for _, sinkConfig := range c.Sinks {
+		level := zerolog.DebugLevel
+		if sinkConfig.Level != "" {
+			var err error
+			if level, err = zerolog.ParseLevel(sinkConfig.Level); err != nil {
+				_ = alerts.Close()
+				return nil, fmt.Errorf("sink %s: %v", sinkConfig.Type, err)
+			}
+		}
+		sink, err := sinkConfig.sink(database, metrics, logger)
+		if err != nil {
+			_ = alerts.Close()
+			return nil, fmt.Errorf("sink %s: %v", sinkConfig.Type, err)
+		}
+		alerts.Add(sink, level)
+	}
+	return alerts, nil
+}
+
+func (sc SinkConfig) sink(database *pkg.AgentDB, metrics *pkg.Metrics, logger zerolog.Logger) (pkg.Sink, error) {
+	encoder, err := pkg.NewEncoder(sc.Format)
+	if err != nil {
+		return nil, err
+	}
+	switch sc.Type {
+	case pkg.SinkStdout, pkg.SinkStderr:
+		sink := pkg.NewWriterSink(os.Stdout)
+		if sc.Type == pkg.SinkStderr {
+			sink = pkg.NewWriterSink(os.Stderr)
+		}
+		sink.Encoder = encoder
+		return sink, nil
+	case pkg.SinkFile:
+		return pkg.NewFileSink(func(s *pkg.FileSink) {
+			s.Path, s.MaxSize, s.MaxAge, s.Encoder = sc.Path, sc.MaxSize*1024*1024, sc.MaxAge, encoder
+			if sc.Backups != 0 {
+				s.Backups = sc.Backups
+			}
+		})
+	case pkg.SinkSyslog:
+		facility := -1
+		if sc.Facility != "" {
+			if facility, err = pkg.SyslogFacility(sc.Facility); err != nil {
+				return nil, err
+			}
+		}
+		return pkg.NewSyslogSink(func(s *pkg.SyslogSink) {
+			s.Encoder = encoder
+			if sc.Socket != "" {
+				s.Socket = sc.Socket
+			}
+			if facility != -1 {
+				s.Facility = facility
+			}
+			if sc.Tag != "" {
+				s.Tag = sc.Tag
+			}
+		}), nil
+	case pkg.SinkWebhook:
+		return pkg.NewWebhookSink(func(s *pkg.WebhookSink) {
+			s.Logger, s.Database, s.Metrics, s.Encoder = logger, database, metrics, encoder
+			s.URL, s.Headers, s.CA, s.Cert, s.Key = sc.URL, sc.Headers, sc.CA, sc.Cert, sc.Key
+			if sc.BatchSize != 0 {
+				s.BatchSize = sc.BatchSize
+			}
+			if sc.FlushInterval != 0 {
+				s.FlushInterval = sc.FlushInterval
+			}
+			if sc.Timeout != 0 {
+				s.Timeout = sc.Timeout
+			}
+			if sc.MaxBackoff != 0 {
+				s.MaxBackoff = sc.MaxBackoff
+			}
+			if sc.MaxAttempts != 0 {
+				s.MaxAttempts = sc.MaxAttempts
+			}
+			if sc.MaxQueue != 0 {
+				s.MaxQueue = sc.MaxQueue
+			}
+		})
+	case pkg.SinkJournald:
+		if sc.Format != "" && sc.Format != pkg.FormatJSON {
+			return nil, fmt.Errorf("journald keeps alert keys as fields, %q format is not supported", sc.Format)
+		}
+		return pkg.NewJournaldSink(func(s *pkg.JournaldSink) {
+			if sc.Socket != "" {
+				s.Socket = sc.Socket
+			}
+			if sc.Tag != "" {
+				s.Tag = sc.Tag
+			}
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown type, choices are %q, %q, %q, %q, %q, %q",
+			pkg.SinkStdout, pkg.SinkStderr, pkg.SinkFile, pkg.SinkSyslog, pkg.SinkJournald, pkg.SinkWebhook)
+	}
+}
+
 // Gets list of regexp objects from regexp paths
 func (c Configuration) compileRegex(listofPaths []string) []*regexp.Regexp {
 	logger := c.logger()
@@ -225,6 +542,16 @@ func (c Configuration) isFileToBeExcluded(file string, existingConsumersFiles ma
 	return isFileExcluded || existingConsumersFiles[file]
 }
 
+// filesNotExcluded returns the files of a consumer watching several paths which are not excluded
+func (c Configuration) filesNotExcluded(files []string, existingConsumersFiles map[string]bool, listOfRegexpsExcludes []*regexp.Regexp) (out []string) {
+	for _, file := range files {
+		if !c.isFileToBeExcluded(file, existingConsumersFiles, listOfRegexpsExcludes) {
+			out = append(out, file)
+		}
+	}
+	return out
+}
+
 // Gets the full list of paths to monitor
 func (c Configuration) getCompleteListOfPaths(pathList []string) []string {
 	logger := c.logger()
@@ -387,6 +714,9 @@ func (c Configuration) metrics() (*pkg.Metrics, error) {
 		}
 		metrics.EveryHourRegister = goMetrics.NewPrefixedRegistry(metrics.Namespace)
 		metrics.EveryMinuteRegister = goMetrics.NewPrefixedRegistry(metrics.Namespace)
+		if c.MetricsConfig.PrometheusAddress != "" {
+			metrics.Prometheus = pkg.NewPrometheus()
+		}
 
 		MetricsInitialised.metrics, MetricsInitialised.err = metrics, nil
 	})
@@ -394,21 +724,108 @@ func (c Configuration) metrics() (*pkg.Metrics, error) {
 	return MetricsInitialised.metrics, MetricsInitialised.err
 }
 
+// Gets the BPF object to load, either set explicitly with bcc or the best fit for the running kernel in bccDir
+func (c Configuration) bpfObject() (string, error) {
+	logger := c.logger()
+	object := c.BCC
+	if object == "" {
+		release, err := pkg.KernelRelease()
+		if err != nil {
+			return "", err
+		}
+		dir := c.BCCDir
+		if dir == "" {
+			dir = DefaultBCCDir
+		}
+		if object, err = pkg.SelectBPFObject(dir, release); err != nil {
+			return "", err
+		}
+		logger.Info().Str("kernel", release).Str("object", object).Msg("selected BPF object for running kernel")
+	} else {
+		logger.Info().Str("object", object).Msg("using configured BPF object")
+	}
+	switch layout, err := pkg.BPFObjectLayout(object); {
+	case err != nil:
+		return "", err
+	case layout == pkg.LegacyBPFLayout:
+		logger.Warn().Str("object", object).Msg("BPF object built with the legacy event layout, process chains and attribute changes are not reported, rebuild it")
+	case layout != pkg.BPFLayout:
+		return "", fmt.Errorf("BPF object %s has event layout %d, expected %d", object, layout, pkg.BPFLayout)
+	}
+	if metrics, err := c.metrics(); err == nil {
+		metrics.RecordBPFObject(filepath.Base(object))
+	}
+	return object, nil
+}
+
+// Starts the event source selected by the backend config key
+func (c Configuration) eventSource() (pkg.EventSource, error) {
+	logger := c.logger()
+	switch c.Backend {
+	case "", ebpfBackend:
+		object, err := c.bpfObject()
+		if err != nil {
+			return nil, err
+		}
+		logger.Debug().Msg("starting ebpf")
+		fim, err := pkg.InitFIM(object, logger, func(f *pkg.FIM) {
+			f.AncestryDepth = c.AncestryDepth
+		})
+		if err != nil {
+			return nil, err
+		}
+		return fim, nil
+	case inotifyBackend:
+		logger.Debug().Msg("starting inotify")
+		inotify, err := pkg.InitInotify(logger)
+		if err != nil {
+			return nil, err
+		}
+		return inotify, nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q, choices are %q, %q", c.Backend, ebpfBackend, inotifyBackend)
+	}
+}
+
 func (c Configuration) watcher() (*pkg.Watcher, error) {
 	logger := c.logger()
 	var genericDiffPaths []string
+	if err := c.Rules.Compile(); err != nil {
+		return nil, err
+	}
 	logger.Debug().Str("db", c.Database).Msg("opening bolt database")
 	db, err := bolt.Open(c.Database, 0600, nil)
 	if err != nil {
 		return nil, err
 	}
-	logger.Debug().Msg("starting ebpf")
-	fim, err := pkg.InitFIM(c.BCC, c.logger())
+	source, err := c.eventSource()
 	if err != nil {
 		return nil, err
 	}
 
 	database := &pkg.AgentDB{Logger: logger, DB: db}
+	err = database.Migrate(c.Consumers.Users.Passwd, c.Consumers.Users.Shadow, c.Consumers.Access,
+		c.files(c.Consumers.Generic), c.files(c.Consumers.GenericDiff))
+	if err != nil {
+		return nil, err
+	}
+	if c.key == nil {
+		if c.key, err = database.Key(keySize); err != nil {
+			return nil, err
+		}
+		if c.Digest != generic.SHA256 {
+			logger.Warn().Str("digest", c.Digest).Str("database", c.Database).
+				Msg("no keyfile, the digest key is kept in the database and does not protect the digests against tampering with it")
+		}
+	}
+	if _, err := generic.NewHash(c.Digest, c.key); err != nil {
+		return nil, err
+	}
+	sinks, err := c.newAlerts(database)
+	if err != nil {
+		return nil, err
+	}
+	c.alerts.Replace(sinks)
 	consumers := c.consumers(database, &genericDiffPaths)
 
 	for _, consumer := range consumers {
@@ -417,7 +834,8 @@ func (c Configuration) watcher() (*pkg.Watcher, error) {
 		}
 	}
 	return pkg.NewWatcher(func(w *pkg.Watcher) {
-		w.Logger, w.Consumers, w.FIM, w.Database, w.Key, w.Excludes, w.GenericDiff = logger, consumers.Consumers(), fim, database, c.key, c.compileRegex(c.Consumers.Excludes), genericDiffPaths
+		w.Logger, w.Consumers, w.EventSource, w.Database, w.Key, w.Digest, w.Excludes, w.GenericDiff = logger, consumers.Consumers(), source, database, c.key, c.Digest, c.compileRegex(c.Consumers.Excludes), genericDiffPaths
+		w.Rules, w.Alerts = c.Rules, c.alerts
 	}), nil
 }
 
@@ -472,53 +890,97 @@ func run() error {
 		metrics.GraphiteMode = viper.GetInt("graphite-mode")
 	}
 
+	// the endpoint is up before the watcher, /readyz fails until consumers are initialized and the loop runs
+	health := &pkg.Health{Backend: config.Backend}
+	if health.Backend == "" {
+		health.Backend = ebpfBackend
+	}
+	if address := config.MetricsConfig.PrometheusAddress; address != "" {
+		if err := pkg.ServeMetrics(address, metrics.Prometheus, health, logger); err != nil {
+			return err
+		}
+		logger.Info().Str("address", address).Msg("serving prometheus metrics")
+	}
+
 	// increment the host count by 1
 	metrics.RecordByInstalledHost()
 	// send version metric
 	metrics.RecordVersion(Version)
 	metrics.RecordBPFMetrics()
-	key := make([]byte, keySize)
-	if config.Keyfile == "" {
-		if _, err := io.ReadFull(rand.Reader, key); err != nil {
-			logger.Fatal().Msg("failed to create a new key")
-		}
-		config.key = key
-	} else {
+	config.alerts = pkg.NewAlerts(func(a *pkg.Alerts) { a.Logger = logger })
+	// without a keyfile the key is generated once and persisted in the database
+	if config.Keyfile != "" {
 		// readin keyfile
 		dat, err := ioutil.ReadFile(config.Keyfile)
 		if err != nil {
 			logger.Fatal().Err(err).Msg("Failed to read key file")
 		}
-		config.key = dat[:16]
+		config.key = dat[:keySize]
 	}
 	watcher, err := config.watcher()
 	if err != nil {
 		return err
 	}
-	watcher.Metrics = metrics
+	watcher.Metrics, watcher.Health = metrics, health
+	health.SetLoaded(true)
 	if err = metrics.Init(); err != nil {
 		return err
 	}
 
 	logger.Info().Msgf("bpfink initialized: version %s, consumers count: %d", BuildDate, len(watcher.Consumers))
-	go handleExit(watcher)
+	go handleExit(watcher, config.alerts)
 	return watcher.Start()
 }
 
-func handleExit(watcher *pkg.Watcher) {
+func handleExit(watcher *pkg.Watcher, alerts *pkg.Alerts) {
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt)
-	for range sig {
+	signal.Notify(sig, os.Interrupt, syscall.SIGHUP)
+	for received := range sig {
+		if received == syscall.SIGHUP {
+			reload(watcher, alerts)
+			continue
+		}
 		watcher.Logger.Info().Msg("received a sigint")
 		err := watcher.Stop()
 		if err != nil {
-			watcher.Logger.Error().Err(err).Msgf("error cleaning up BPF Map: %v", err)
+			watcher.Logger.Error().Err(err).Msgf("error cleaning up event source: %v", err)
+		}
+		if err := alerts.Close(); err != nil {
+			watcher.Logger.Error().Err(err).Msg("error closing sinks")
 		}
 		watcher.Logger.Debug().Msg("graceful shutdown complete")
 		os.Exit(0)
 	}
 }
 
+// Re-reads the config and applies its consumers, excludes, rules and sinks to the running watcher.
+// The event source, database, digest and key are kept, changing them requires a restart.
+func reload(watcher *pkg.Watcher, alerts *pkg.Alerts) {
+	logger := watcher.Logger
+	logger.Info().Msg("received a sighup, reloading configuration")
+	c, err := config()
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to read configuration, keeping the running one")
+		return
+	}
+	if err := c.Rules.Compile(); err != nil {
+		logger.Error().Err(err).Msg("invalid rules, keeping the running configuration")
+		return
+	}
+	sinks, err := c.newAlerts(watcher.Database)
+	if err != nil {
+		logger.Error().Err(err).Msg("invalid sinks, keeping the running configuration")
+		return
+	}
+	alerts.Replace(sinks)
+	c.key, c.Digest, c.alerts = watcher.Key, watcher.Digest, alerts
+	var genericDiffPaths []string
+	consumers := c.consumers(watcher.Database, &genericDiffPaths)
+	watcher.Reload(consumers.Consumers(), func(w *pkg.Watcher) {
+		w.Excludes, w.GenericDiff, w.Rules, w.Alerts = c.compileRegex(c.Consumers.Excludes), genericDiffPaths, c.Rules, alerts
+	})
+}
+
 func main() {
 	cmd := &cobra.Command{
 		Use:   "bpfink",
@@ -0,0 +1,226 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// SinkStdout writes alerts as JSON lines to stdout
+	SinkStdout = "stdout"
+	// SinkStderr writes alerts as JSON lines to stderr
+	SinkStderr = "stderr"
+	// SinkFile writes alerts as JSON lines to a rotated file
+	SinkFile = "file"
+	// SinkSyslog sends alerts as RFC 5424 messages to the local syslog socket
+	SinkSyslog = "syslog"
+	// SinkJournald sends alerts to the systemd journal
+	SinkJournald = "journald"
+)
+
+type (
+	// Alert struct describing a change reported by a consumer, Fields holds every JSON key of the report
+	Alert struct {
+		Time    time.Time
+		Level   zerolog.Level
+		Message string
+		Fields  map[string]interface{}
+	}
+	// Sink describes an output alerts are delivered to
+	Sink interface {
+		Send(Alert) error
+		Close() error
+	}
+	// starter is implemented by sinks delivering in the background, which is started once they are dispatched to
+	starter interface {
+		Start() error
+	}
+	// Alerts struct dispatching the alerts of consumers to sinks, each with its minimum level, the agent logger when there are none
+	Alerts struct {
+		zerolog.Logger
+		mux   sync.RWMutex
+		sinks []levelSink
+	}
+	levelSink struct {
+		Sink
+		level zerolog.Level
+	}
+	// WriterSink struct writing alerts as lines, i.e. to stdout, JSON ones unless an Encoder is set
+	WriterSink struct {
+		Encoder Encoder
+		mux     sync.Mutex
+		io.Writer
+	}
+)
+
+// NewAlert decodes an alert from a JSON log line, as written by zerolog
+func NewAlert(line []byte) (Alert, error) {
+	alert := Alert{Time: time.Now(), Fields: map[string]interface{}{}}
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&alert.Fields); err != nil {
+		return alert, err
+	}
+	level, _ := alert.Fields[zerolog.LevelFieldName].(string)
+	alert.Level, _ = zerolog.ParseLevel(level)
+	alert.Message, _ = alert.Fields[zerolog.MessageFieldName].(string)
+	if value, ok := alert.Fields[zerolog.TimestampFieldName].(string); ok {
+		if timestamp, err := time.Parse(zerolog.TimeFieldFormat, value); err == nil {
+			alert.Time = timestamp
+		}
+	}
+	return alert, nil
+}
+
+// MarshalJSON renders an alert as the JSON object of its fields, timestamped
+func (a Alert) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]interface{}, len(a.Fields)+1)
+	for key, value := range a.Fields {
+		fields[key] = value
+	}
+	if _, ok := fields[zerolog.TimestampFieldName]; !ok {
+		fields[zerolog.TimestampFieldName] = a.Time.Format(time.RFC3339)
+	}
+	return json.Marshal(fields)
+}
+
+// Str returns a string field of an alert, empty if missing
+func (a Alert) Str(key string) string {
+	value, _ := a.Fields[key].(string)
+	return value
+}
+
+// NewAlerts function to create an alert dispatcher
+func NewAlerts(options ...func(*Alerts)) *Alerts {
+	alerts := &Alerts{Logger: zerolog.Nop()}
+	for _, option := range options {
+		option(alerts)
+	}
+	return alerts
+}
+
+// Add method to deliver alerts of at least level to a sink
+func (a *Alerts) Add(sink Sink, level zerolog.Level) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.sinks = append(a.sinks, levelSink{sink, level})
+}
+
+// Replace method takes over the sinks of next, i.e. on reload. The previous sinks are closed before the new ones
+// are started, so that a webhook sink hands its queue over to the one replacing it.
+func (a *Alerts) Replace(next *Alerts) {
+	next.mux.Lock()
+	sinks := next.sinks
+	next.sinks = nil
+	next.mux.Unlock()
+	a.mux.Lock()
+	previous := a.sinks
+	a.sinks = sinks
+	a.mux.Unlock()
+	for _, sink := range previous {
+		if err := sink.Close(); err != nil {
+			a.Error().Err(err).Msg("failed to close sink")
+		}
+	}
+	for _, sink := range sinks {
+		if sink, ok := sink.Sink.(starter); ok {
+			if err := sink.Start(); err != nil {
+				a.Error().Err(err).Msgf("failed to start %T", sink)
+			}
+		}
+	}
+}
+
+// Emit method delivers an alert to every sink whose level it reaches
+func (a *Alerts) Emit(alert Alert) {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+	for _, sink := range a.sinks {
+		if alert.Level < sink.level {
+			continue
+		}
+		if err := sink.Send(alert); err != nil {
+			a.Error().Err(err).Str("alert", alert.Message).Msgf("failed to send alert to %T", sink.Sink)
+		}
+	}
+}
+
+// Write method lets zerolog events be emitted as alerts, one JSON line each.
+// Without sinks, i.e. none configured or all removed on reload, alerts are logged by the agent logger instead.
+func (a *Alerts) Write(line []byte) (int, error) {
+	alert, err := NewAlert(line)
+	if err != nil {
+		return 0, err
+	}
+	a.mux.RLock()
+	empty := len(a.sinks) == 0
+	a.mux.RUnlock()
+	if empty {
+		a.log(alert)
+		return len(line), nil
+	}
+	a.Emit(alert)
+	return len(line), nil
+}
+
+// log writes an alert with the agent logger, which adds its own level, message and timestamp
+func (a *Alerts) log(alert Alert) {
+	fields := make(map[string]interface{}, len(alert.Fields))
+	for key, value := range alert.Fields {
+		switch key {
+		case zerolog.LevelFieldName, zerolog.MessageFieldName, zerolog.TimestampFieldName:
+		default:
+			fields[key] = value
+		}
+	}
+	a.WithLevel(alert.Level).Fields(fields).Msg(alert.Message)
+}
+
+// Close method closes every sink
+func (a *Alerts) Close() error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	var errs []error
+	for _, sink := range a.sinks {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	a.sinks = nil
+	if len(errs) != 0 {
+		return fmt.Errorf("failed to close sinks: %v", errs)
+	}
+	return nil
+}
+
+// NewWriterSink function to create a sink writing JSON lines to w, i.e. os.Stdout
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{Writer: w}
+}
+
+// Send method writes an alert as one line
+func (ws *WriterSink) Send(alert Alert) error {
+	line, err := encode(ws.Encoder, alert)
+	if err != nil {
+		return err
+	}
+	ws.mux.Lock()
+	defer ws.mux.Unlock()
+	_, err = ws.Write(append(line, '\n'))
+	return err
+}
+
+// Close method, standard outputs are left open
+func (ws *WriterSink) Close() error {
+	if closer, ok := ws.Writer.(io.Closer); ok && ws.Writer != os.Stdout && ws.Writer != os.Stderr {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestAlerts(t *testing.T) {
+	var warnings, all bytes.Buffer
+	alerts := NewAlerts()
+	alerts.Add(NewWriterSink(&warnings), zerolog.WarnLevel)
+	alerts.Add(NewWriterSink(&all), zerolog.DebugLevel)
+
+	logger := zerolog.New(ioutil.Discard).Level(zerolog.WarnLevel)
+	origin := Origin{Process: "vi", User: "root", alerts: alerts}
+	origin.Log(origin.Event(logger).Strs("changes", []string{"alice: shell enabled"}), "Users Modified")
+	origin.Rule = &Rule{Name: "puppet", Action: RuleLower}
+	origin.Log(origin.Event(logger), "Users Modified")
+
+	if lines := strings.Count(warnings.String(), "\n"); lines != 1 {
+		t.Errorf("warn sink want 1 alert, got: %q", warnings.String())
+	}
+	if lines := strings.Count(all.String(), "\n"); lines != 2 {
+		t.Errorf("debug sink want 2 alerts, info ones included, got: %q", all.String())
+	}
+	alert, err := NewAlert(warnings.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alert.Level != zerolog.WarnLevel || alert.Message != "Users Modified" || alert.Str("processName") != "vi" ||
+		alert.Str("time") == "" {
+		t.Errorf("unexpected alert: %+v", alert)
+	}
+}
+
+func TestAlertsWithoutSinks(t *testing.T) {
+	var agent, sink bytes.Buffer
+	alerts := NewAlerts(func(a *Alerts) { a.Logger = zerolog.New(&agent).Level(zerolog.InfoLevel) })
+	logger := zerolog.New(ioutil.Discard)
+	origin := Origin{Process: "vi", User: "root", alerts: alerts}
+	report := func() {
+		origin.Log(origin.Event(logger).Str("file", "/etc/hosts"), "file modified")
+	}
+
+	report()
+	alert, err := NewAlert(agent.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alert.Level != zerolog.WarnLevel || alert.Message != "file modified" || alert.Str("file") != "/etc/hosts" {
+		t.Errorf("want the alert logged by the agent logger without sinks, got: %q", agent.String())
+	}
+
+	// a reload adding sinks takes over the consumers sharing the dispatcher, one removing them falls back again
+	next := NewAlerts()
+	next.Add(NewWriterSink(&sink), zerolog.DebugLevel)
+	alerts.Replace(next)
+	agent.Reset()
+	report()
+	if agent.Len() != 0 || strings.Count(sink.String(), "\n") != 1 {
+		t.Errorf("want the alert sent to the sink only, got: %q and %q", agent.String(), sink.String())
+	}
+	alerts.Replace(NewAlerts())
+	sink.Reset()
+	report()
+	if sink.Len() != 0 || strings.Count(agent.String(), "\n") != 1 {
+		t.Errorf("want the alert logged by the agent logger once sinks are removed, got: %q and %q", agent.String(), sink.String())
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_sinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "alerts.json")
+	sink, err := NewFileSink(func(s *FileSink) { s.Path, s.MaxSize, s.Backups = path, 100, 2 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	alert := Alert{Level: zerolog.WarnLevel, Fields: map[string]interface{}{"message": strings.Repeat("x", 40)}}
+	for i := 0; i < 4; i++ {
+		if err := sink.Send(alert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Count(string(content), "\n"); lines != 1 {
+			t.Errorf("%s want 1 alert, got %d", name, lines)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("want 2 backups at most, got %s", path+".3")
+	}
+}
+
+func TestSyslogSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_syslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "log")
+	conn, err := net.ListenPacket("unixgram", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink := NewSyslogSink(func(s *SyslogSink) { s.Socket, s.Facility = socket, 4 })
+	defer sink.Close()
+	alert := Alert{Level: zerolog.WarnLevel, Message: "sudoers modified", Fields: map[string]interface{}{"message": "sudoers modified"}}
+	if err := sink.Send(alert); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 4096)
+	n, _, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// auth facility and warning severity give a priority of 4*8+4
+	want := regexp.MustCompile(`^<36>1 \S+T\S+ \S+ bpfink \d+ - - \{.*"message":"sudoers modified".*\}$`)
+	if !want.Match(buffer[:n]) {
+		t.Errorf("want an RFC 5424 message, got: %q", buffer[:n])
+	}
+}
+
+func TestJournaldFormat(t *testing.T) {
+	alert := Alert{Level: zerolog.InfoLevel, Message: "file modified", Fields: map[string]interface{}{
+		"message": "file modified", "processName": "vi", "diff": "-a\n+b",
+	}}
+	message, err := NewJournaldSink().Format(alert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"MESSAGE=file modified\n", "PRIORITY=6\n", "SYSLOG_IDENTIFIER=bpfink\n", "BPFINK_PROCESS_NAME=vi\n"} {
+		if !bytes.Contains(message, []byte(want)) {
+			t.Errorf("want %q in %q", want, message)
+		}
+	}
+	length := make([]byte, 8)
+	binary.LittleEndian.PutUint64(length, uint64(len("-a\n+b")))
+	if !bytes.Contains(message, append(append([]byte("BPFINK_DIFF\n"), length...), "-a\n+b\n"...)) {
+		t.Errorf("want a length prefixed multi line value, got %q", message)
+	}
+}
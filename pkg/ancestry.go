@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	procDir = "/proc"
+	// DefaultAncestryDepth number of processes, the writer included, reported in the process chain
+	DefaultAncestryDepth = 5
+	// statPPIDField index of the ppid in /proc/<pid>/stat, counted after the command name
+	statPPIDField = 1
+)
+
+type (
+	// Process struct describing one process of the chain that led to an event
+	Process struct {
+		PID     uint32
+		Comm    string
+		Exe     string
+		Cmdline string
+	}
+	// Ancestry is the process chain of an event, from the writer to its oldest known ancestor
+	Ancestry []Process
+)
+
+// String renders the chain the way it was spawned, i.e. "sshd -> bash -> vi"
+func (a Ancestry) String() string {
+	comms := make([]string, 0, len(a))
+	for i := len(a) - 1; i >= 0; i-- {
+		comms = append(comms, a[i].Comm)
+	}
+	return strings.Join(comms, " -> ")
+}
+
+// readCmdline function to read the command line of a running process
+func readCmdline(pid uint32) string {
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("%s/%d/cmdline", procDir, pid))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\u0000", " "))
+}
+
+// readStat function to read the command name and parent of a running process
+func readStat(pid uint32) (comm string, ppid uint32, err error) {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("%s/%d/stat", procDir, pid))
+	if err != nil {
+		return "", 0, err
+	}
+	// the command name is in parentheses and may contain spaces or parentheses itself
+	start, end := strings.IndexByte(string(stat), '('), strings.LastIndexByte(string(stat), ')')
+	if start == -1 || end < start {
+		return "", 0, fmt.Errorf("unexpected stat format for pid %d", pid)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) <= statPPIDField {
+		return "", 0, fmt.Errorf("unexpected stat format for pid %d", pid)
+	}
+	parent, err := strconv.ParseUint(fields[statPPIDField], 10, 32)
+	if err != nil {
+		return "", 0, err
+	}
+	return string(stat[start+1 : end]), uint32(parent), nil
+}
+
+// NewAncestry builds the process chain of an event, starting with the writer, up to depth processes.
+// What BPF captured about the writer is used when it is already gone.
+func NewAncestry(writer Process, ppid uint32, depth int) Ancestry {
+	if depth <= 0 {
+		depth = DefaultAncestryDepth
+	}
+	if exe, err := os.Readlink(fmt.Sprintf("%s/%d/exe", procDir, writer.PID)); err == nil {
+		writer.Exe = exe
+	}
+	ancestry := Ancestry{writer}
+	for pid := ppid; pid != 0 && len(ancestry) < depth; {
+		comm, parent, err := readStat(pid)
+		if err != nil {
+			ancestry = append(ancestry, Process{PID: pid, Comm: Unknown})
+			break
+		}
+		process := Process{PID: pid, Comm: comm, Cmdline: readCmdline(pid)}
+		if exe, err := os.Readlink(fmt.Sprintf("%s/%d/exe", procDir, pid)); err == nil {
+			process.Exe = exe
+		}
+		ancestry = append(ancestry, process)
+		pid = parent
+	}
+	return ancestry
+}
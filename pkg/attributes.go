@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"syscall"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	xattrListSize  = 4096
+	xattrValueSize = 4096
+	permissionBits = 07777
+)
+
+type (
+	// Attributes struct used to store the ownership, mode and extended attributes of a file
+	Attributes struct {
+		Mode     uint32
+		UID, GID uint32
+		XAttrs   map[string]string
+	}
+)
+
+// IsEmpty method to check if attributes were ever read
+func (a Attributes) IsEmpty() bool { return a.Mode == 0 }
+
+// Equal method to compare two sets of attributes
+func (a Attributes) Equal(b Attributes) bool {
+	return a.Mode == b.Mode && a.UID == b.UID && a.GID == b.GID && Map(a.XAttrs).Equal(b.XAttrs)
+}
+
+// Permissions returns the permission bits, setuid, setgid and sticky included, in octal
+func (a Attributes) Permissions() string { return fmt.Sprintf("%#o", a.Mode&permissionBits) }
+
+// ReadAttributes function to read the attributes of a file, symlinks are not followed
+func ReadAttributes(file string) (Attributes, error) {
+	fstat := &syscall.Stat_t{}
+	if err := syscall.Lstat(file, fstat); err != nil {
+		return Attributes{}, err
+	}
+	attributes := Attributes{Mode: fstat.Mode, UID: fstat.Uid, GID: fstat.Gid, XAttrs: map[string]string{}}
+	if fstat.Mode&syscall.S_IFMT == syscall.S_IFLNK {
+		return attributes, nil
+	}
+
+	list := make([]byte, xattrListSize)
+	size, err := unix.Listxattr(file, list)
+	if err != nil {
+		if err == unix.ENOTSUP {
+			return attributes, nil
+		}
+		return attributes, err
+	}
+	for _, name := range strings.Split(string(list[:size]), "\x00") {
+		if name == "" {
+			continue
+		}
+		value := make([]byte, xattrValueSize)
+		size, err := unix.Getxattr(file, name, value)
+		if err != nil {
+			continue // removed in the meantime
+		}
+		attributes.XAttrs[name] = xattrValue(value[:size])
+	}
+	return attributes, nil
+}
+
+// xattrValue keeps printable values as is and hex encodes binary ones such as security.capability
+func xattrValue(value []byte) string {
+	printable := utf8.Valid(value) && strings.IndexFunc(string(value), func(r rune) bool {
+		return !unicode.IsPrint(r)
+	}) == -1
+	if printable {
+		return string(value)
+	}
+	return "0x" + hex.EncodeToString(value)
+}
@@ -0,0 +1,145 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/sys/unix"
+)
+
+func TestReadAttributes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_attributes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "sshd")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(file, 0755|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Setxattr(file, "user.bpfink", []byte("text"), 0); err != nil {
+		t.Skipf("extended attributes not supported: %v", err)
+	}
+	if err := unix.Setxattr(file, "user.binary", []byte{1, 0, 2}, 0); err != nil {
+		t.Fatal(err)
+	}
+	attributes, err := ReadAttributes(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attributes.Permissions() != "04755" || attributes.UID != uint32(os.Getuid()) || attributes.GID != uint32(os.Getgid()) {
+		t.Errorf("ReadAttributes want mode 04755 and the test ownership, got: %v", attributes)
+	}
+	if want := map[string]string{"user.bpfink": "text", "user.binary": "0x010002"}; !reflect.DeepEqual(attributes.XAttrs, want) {
+		t.Errorf("ReadAttributes want xattrs: %v, got: %v", want, attributes.XAttrs)
+	}
+}
+
+func TestAttributesChanges(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	dir, err := ioutil.TempDir("", "bpfink_attributes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(file, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Setxattr(file, "user.bpfink", []byte("a"), 0); err != nil {
+		t.Skipf("extended attributes not supported: %v", err)
+	}
+
+	var output bytes.Buffer
+	db.Logger = zerolog.New(&output).Level(zerolog.WarnLevel)
+	consumer := &BaseConsumer{AgentDB: db, ParserLoader: &GenericState{
+		GenericListener: NewGenericListener(func(l *GenericListener) { l.File, l.Digest = file, "sha256" }),
+	}}
+	if err := consumer.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if output.Len() != 0 {
+		t.Errorf("Init want no change without a baseline, got: %s", output.String())
+	}
+
+	type change struct {
+		Old, New      map[string]interface{}
+		Add           []string `json:"addXattrs"`
+		Del           []string `json:"delXattrs"`
+		Message, File string
+	}
+	consume := func(kind int32) (changes []change) {
+		output.Reset()
+		if err := consumer.Consume(Event{Path: file, Mode: kind, UID: UnknownUID, LoginUID: UnknownUID}); err != nil {
+			t.Fatal(err)
+		}
+		decoder := json.NewDecoder(&output)
+		for decoder.More() {
+			var entry change
+			if err := decoder.Decode(&entry); err != nil {
+				t.Fatal(err)
+			}
+			changes = append(changes, entry)
+		}
+		return changes
+	}
+	attributes := func(mode string, uid, gid float64, xattrs map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"mode": mode, "uid": uid, "gid": gid, "xattrs": xattrs}
+	}
+	mode, uid, gid := "04755", float64(os.Getuid()), float64(os.Getgid())
+	xattrs := map[string]interface{}{"user.bpfink": "a"}
+
+	if err := os.Chmod(file, 0755|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+	want := []change{{
+		Old: attributes("0644", uid, gid, xattrs), New: attributes("04755", uid, gid, xattrs),
+		Add: []string{}, Del: []string{}, Message: "file attributes modified", File: file,
+	}}
+	if changes := consume(attrChange); !reflect.DeepEqual(changes, want) {
+		t.Errorf("chmod want: %v, got: %v", want, changes)
+	}
+
+	if os.Getuid() == 0 {
+		if err := os.Chown(file, 1, 2); err != nil {
+			t.Fatal(err)
+		}
+		// the kernel drops setuid on chown
+		want = []change{{
+			Old: attributes("04755", uid, gid, xattrs), New: attributes("0755", 1, 2, xattrs),
+			Add: []string{}, Del: []string{}, Message: "file attributes modified", File: file,
+		}}
+		if changes := consume(attrChange); !reflect.DeepEqual(changes, want) {
+			t.Errorf("chown want: %v, got: %v", want, changes)
+		}
+		mode, uid, gid = "0755", 1, 2
+	}
+
+	if err := unix.Setxattr(file, "user.capability", []byte{1, 0, 0, 2}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Removexattr(file, "user.bpfink"); err != nil {
+		t.Fatal(err)
+	}
+	want = []change{{
+		Old: attributes(mode, uid, gid, xattrs), New: attributes(mode, uid, gid, map[string]interface{}{"user.capability": "0x01000002"}),
+		Add: []string{"user.capability"}, Del: []string{"user.bpfink"}, Message: "file attributes modified", File: file,
+	}}
+	if changes := consume(xattrChange); !reflect.DeepEqual(changes, want) {
+		t.Errorf("xattr change want: %v, got: %v", want, changes)
+	}
+
+	if changes := consume(attrChange); len(changes) != 0 {
+		t.Errorf("unchanged attributes want no change, got: %v", changes)
+	}
+}
@@ -5,18 +5,42 @@ import (
 	"fmt"
 	"os"
 	"os/user"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/rs/zerolog"
 )
 
+// Consumers and actions reported under the consumer and action keys of a change
+const (
+	ConsumerUsers       = "users"
+	ConsumerGroups      = "groups"
+	ConsumerAccess      = "access"
+	ConsumerSudoers     = "sudoers"
+	ConsumerSSHD        = "sshd"
+	ConsumerCron        = "cron"
+	ConsumerSystemd     = "systemd"
+	ConsumerPAM         = "pam"
+	ConsumerLoader      = "loader"
+	ConsumerGeneric     = "generic"
+	ConsumerGenericDiff = "genericDiff"
+	ConsumerAttributes  = "attributes"
+
+	ActionCreated  = "created"
+	ActionDeleted  = "deleted"
+	ActionModified = "modified"
+)
+
 type (
 	// State describes the interface for maintaining state of instances for a consumer
 	State interface {
 		Changed() bool
 		Created() bool
-		Notify(string, string)
+		Notify(Origin)
 		Teardown() error
 	}
 	// ParserLoader describes the interface for maintaining the data in a consumer
@@ -34,9 +58,153 @@ type (
 		*AgentDB
 		ParserLoader
 		sync.RWMutex
+		// NotifyOnEmptyDB reports offline changes even when no previous state was persisted
+		NotifyOnEmptyDB bool
+		// Alerts receives the changes when set, instead of the consumer logger. It is shared and kept across reloads,
+		// changes go to the agent logger while it has no sinks.
+		Alerts *Alerts
+		// Metrics records how events are consumed when set
+		Metrics *Metrics
+	}
+
+	// Origin describes what led a consumer to detect a change
+	Origin struct {
+		Process string
+		User    string
+		// LoginUser is the user who originally logged in, it survives sudo and su
+		LoginUser string
+		ExeInode  uint64
+		Ancestry  Ancestry
+		// Offline is set when the change happened while bpfink was not running,
+		// ModTime and ChangeTime are then the latest times found on the watched files.
+		Offline             bool
+		ModTime, ChangeTime time.Time
+		// Resync is set when the change was found by re-parsing the files after events were lost
+		Resync bool
+		// Rule is the configured rule the writer matched, it decides how the change is reported
+		Rule   *Rule
+		alerts *Alerts
 	}
 )
 
+// Kind returns the consumer, i.e. ConsumerUsers, as reported in changes and metrics
+func (bc *BaseConsumer) Kind() string {
+	switch bc.ParserLoader.(type) {
+	case *UsersState:
+		return ConsumerUsers
+	case *GroupsState:
+		return ConsumerGroups
+	case *AccessState:
+		return ConsumerAccess
+	case *SudoersState:
+		return ConsumerSudoers
+	case *SSHDState:
+		return ConsumerSSHD
+	case *CronState:
+		return ConsumerCron
+	case *SystemdState:
+		return ConsumerSystemd
+	case *PAMState:
+		return ConsumerPAM
+	case *LoaderState:
+		return ConsumerLoader
+	case *GenericState:
+		return ConsumerGeneric
+	case *GenericDiffState:
+		return ConsumerGenericDiff
+	default:
+		return Unknown
+	}
+}
+
+// ConsumerKind returns the kind of a consumer watched by the watcher, the one waiting for a missing file included
+func ConsumerKind(consumer Consumer) string {
+	switch consumer := consumer.(type) {
+	case *BaseConsumer:
+		return consumer.Kind()
+	case *FileMissing:
+		return ConsumerKind(consumer.Consumer)
+	default:
+		return Unknown
+	}
+}
+
+// PathClass groups paths by their top directory for metrics, home directories being one class, i.e. etc for /etc/passwd
+func PathClass(path string) string {
+	switch top := strings.SplitN(strings.TrimPrefix(filepath.Clean(path), "/"), "/", 2)[0]; top {
+	case "":
+		return "root"
+	case "root", "home":
+		return "home"
+	default:
+		return top
+	}
+}
+
+// Event starts the log event reporting a change, at a level depending on the matched rule.
+// Changes ignored by a rule get a disabled event, sending it is a no-op.
+// With alerts, the event is emitted to the sinks which filter levels on their own.
+func (o Origin) Event(logger zerolog.Logger) *zerolog.Event {
+	return o.event(logger, zerolog.WarnLevel)
+}
+
+// CriticalEvent starts the log event reporting a critical change, at error level unless a rule lowers or ignores it
+func (o Origin) CriticalEvent(logger zerolog.Logger) *zerolog.Event {
+	return o.event(logger, zerolog.ErrorLevel)
+}
+
+func (o Origin) event(logger zerolog.Logger, level zerolog.Level) *zerolog.Event {
+	if o.alerts != nil {
+		logger = logger.Output(o.alerts).Level(zerolog.DebugLevel)
+	}
+	if o.Rule == nil {
+		return logger.WithLevel(level)
+	}
+	switch o.Rule.Action {
+	case RuleIgnore:
+		return nil
+	case RuleLower:
+		return logger.Info()
+	default:
+		return logger.WithLevel(level)
+	}
+}
+
+// Log adds the origin of a change to a log event and sends it
+func (o Origin) Log(e *zerolog.Event, msg string) {
+	e = e.Str("processName", o.Process).Str("user", o.User)
+	if o.LoginUser != "" {
+		e = e.Str("loginUser", o.LoginUser)
+	}
+	if o.ExeInode != 0 {
+		e = e.Uint64("exeInode", o.ExeInode)
+	}
+	if len(o.Ancestry) != 0 {
+		e = e.Str("processChain", o.Ancestry.String()).Array("ancestry", LogAncestry(o.Ancestry))
+	}
+	if o.Rule != nil {
+		e = e.Str("rule", o.Rule.Name)
+		if o.Rule.Action == RuleTag {
+			e = e.Str("tag", o.Rule.Tag)
+		}
+	}
+	if o.Offline {
+		e = e.Bool("offline", true)
+		if !o.ModTime.IsZero() {
+			e = e.Time("mtime", o.ModTime)
+		}
+		if !o.ChangeTime.IsZero() {
+			e = e.Time("ctime", o.ChangeTime)
+		}
+		msg += " while agent offline"
+	}
+	if o.Resync {
+		e = e.Bool("resync", true)
+		msg += " detected by resync"
+	}
+	e.Msg(msg)
+}
+
 // Init function for populating a base consumer
 func (bc *BaseConsumer) Init() error {
 	if err := bc.Load(bc.AgentDB); err != nil {
@@ -46,41 +214,152 @@ func (bc *BaseConsumer) Init() error {
 	if err != nil {
 		return err
 	}
+	if state.Changed() && (bc.NotifyOnEmptyDB || !state.Created()) {
+		state.Notify(bc.offlineOrigin())
+	}
 	if err := bc.Save(bc.AgentDB); err != nil {
 		return err
 	}
+	offline := bc.offlineOrigin()
+	for _, file := range bc.ParserLoader.Register() {
+		bc.attributes(file, offline)
+	}
 	if err := state.Teardown(); err == nil || err == ErrReload {
 		return nil
 	}
 	return err
 }
 
+// attributes reports and persists ownership, mode and extended attribute changes of a file
+func (bc *BaseConsumer) attributes(file string, origin Origin) {
+	current, err := bc.LoadAttributes(file)
+	if err != nil {
+		bc.Error().Err(err).Str("file", file).Msg("failed to load attributes")
+		return
+	}
+	next, err := ReadAttributes(file)
+	if err != nil {
+		if !IsNotExist(err) {
+			bc.Error().Err(err).Str("file", file).Msg("failed to read attributes")
+		}
+		return
+	}
+	if current.Equal(next) {
+		return
+	}
+	// without a baseline only live events are reported, offline or on resync there is nothing to compare with
+	if !current.IsEmpty() || !(origin.Offline || origin.Resync) {
+		add, del := ArrayDiff(Map(current.XAttrs).Keys(), Map(next.XAttrs).Keys())
+		origin.Log(origin.Event(bc.Logger).
+			Str("consumer", ConsumerAttributes).
+			Str("action", ActionModified).
+			Str("file", file).
+			Object("old", LogAttributes(current)).
+			Object("new", LogAttributes(next)).
+			Strs("addXattrs", add).
+			Strs("delXattrs", del),
+			"file attributes modified")
+	}
+	if err := bc.SaveAttributes(file, next); err != nil {
+		bc.Error().Err(err).Str("file", file).Msg("failed to save attributes")
+	}
+}
+
+// offlineOrigin collects the latest modification and change times of the registered files
+func (bc *BaseConsumer) offlineOrigin() Origin {
+	origin := Origin{Process: Unknown, User: Unknown, Offline: true, alerts: bc.Alerts}
+	for _, file := range bc.ParserLoader.Register() {
+		fstat := &syscall.Stat_t{}
+		if err := syscall.Stat(file, fstat); err != nil {
+			continue
+		}
+		if mtime := time.Unix(fstat.Mtim.Unix()); mtime.After(origin.ModTime) {
+			origin.ModTime = mtime
+		}
+		if ctime := time.Unix(fstat.Ctim.Unix()); ctime.After(origin.ChangeTime) {
+			origin.ChangeTime = ctime
+		}
+	}
+	return origin
+}
+
+// username resolves the name of the user behind an event
+func (bc *BaseConsumer) username(uid uint32) string {
+	if uid == UnknownUID {
+		return Unknown
+	}
+	userID := fmt.Sprintf("%d", uid)
+	user, err := user.LookupId(userID)
+	if err != nil {
+		bc.Err(err).Msgf("can't find user by UID %d", uid)
+		return userID
+	}
+	return user.Username
+}
+
 // Consume consumes an event
 func (bc *BaseConsumer) Consume(e Event) error {
 	bc.Lock()
 	defer bc.Unlock()
+	origin := Origin{
+		Process: e.Com, User: bc.username(e.UID), ExeInode: e.ExeInode, Ancestry: e.Ancestry, Rule: e.Rule,
+		alerts: bc.Alerts,
+	}
+	if e.LoginUID != UnknownUID {
+		origin.LoginUser = bc.username(e.LoginUID)
+	}
+	kind, class := bc.Kind(), PathClass(e.Path)
+	defer func(start time.Time) { bc.Metrics.RecordConsumeLatency(kind, class, time.Since(start)) }(time.Now())
+	if e.Mode == attrChange || e.Mode == xattrChange {
+		bc.attributes(e.Path, origin)
+		return nil
+	}
+	return bc.check(origin, class)
+}
+
+// Resync re-parses the registered files after events may have been lost, changes found are reported as such
+func (bc *BaseConsumer) Resync() error {
+	bc.Lock()
+	defer bc.Unlock()
+	origin := Origin{Process: Unknown, User: Unknown, Resync: true, alerts: bc.Alerts}
+	class := Unknown
+	for _, file := range bc.ParserLoader.Register() {
+		if class == Unknown {
+			class = PathClass(file)
+		}
+		bc.attributes(file, origin)
+	}
+	return bc.check(origin, class)
+}
+
+// check parses the registered files, reports and persists the new state if it changed.
+// The class of the changed path labels the metrics.
+func (bc *BaseConsumer) check(origin Origin, class string) error {
 	state, err := bc.Parse()
 	if err != nil {
+		bc.Metrics.RecordConsumer(MetricParseErrors, bc.Kind(), class)
 		return err
 	}
 	if !state.Changed() {
 		return state.Teardown()
 	}
 
-	userID := fmt.Sprintf("%d", e.UID)
-	if user, err := user.LookupId(userID); err != nil {
-		bc.Err(err).Msgf("can't find user by UID %d", e.UID)
-		state.Notify(e.Com, userID)
-	} else {
-		state.Notify(e.Com, user.Username)
-	}
+	bc.Metrics.RecordConsumer(MetricChanges, bc.Kind(), class)
+	state.Notify(origin)
 
 	if err := bc.Save(bc.AgentDB); err != nil {
+		bc.Metrics.RecordConsumer(MetricSaveFailures, bc.Kind(), class)
 		return err
 	}
 	return state.Teardown()
 }
 
+// OwnsDir reports if the state parses the files of dir itself
+func (bc *BaseConsumer) OwnsDir(dir string) bool {
+	owner, ok := bc.ParserLoader.(DirOwner)
+	return ok && owner.OwnsDir(dir)
+}
+
 // Register method maps files to consumers.
 func (bc *BaseConsumer) Register() *sync.Map {
 	consumers := &sync.Map{}
@@ -90,6 +369,37 @@ func (bc *BaseConsumer) Register() *sync.Map {
 	return consumers
 }
 
+// SameConfig reports if a consumer parses files with the same options, i.e. sshd critical settings or a digest key
+func (bc *BaseConsumer) SameConfig(consumer Consumer) bool {
+	other, ok := consumer.(*BaseConsumer)
+	if !ok || bc.NotifyOnEmptyDB != other.NotifyOnEmptyDB {
+		return false
+	}
+	return reflect.DeepEqual(listenerConfig(bc.ParserLoader), listenerConfig(other.ParserLoader))
+}
+
+// listenerConfig returns a copy of the listener embedded by a state, without its logger nor the state itself
+func listenerConfig(parserLoader ParserLoader) interface{} {
+	state := reflect.Indirect(reflect.ValueOf(parserLoader))
+	if state.Kind() != reflect.Struct {
+		return parserLoader
+	}
+	for i := 0; i < state.NumField(); i++ {
+		field := state.Field(i)
+		if !state.Type().Field(i).Anonymous || field.Kind() != reflect.Ptr || field.IsNil() ||
+			field.Elem().Kind() != reflect.Struct {
+			continue
+		}
+		listener := reflect.New(field.Elem().Type()).Elem()
+		listener.Set(field.Elem())
+		if logger := listener.FieldByName("Logger"); logger.IsValid() && logger.CanSet() {
+			logger.Set(reflect.Zero(logger.Type()))
+		}
+		return listener.Interface()
+	}
+	return reflect.TypeOf(parserLoader)
+}
+
 // Consumers returns a slice of consumers.
 func (bc BaseConsumers) Consumers() (consumers []Consumer) {
 	for _, consumer := range bc {
@@ -98,6 +408,16 @@ func (bc BaseConsumers) Consumers() (consumers []Consumer) {
 	return consumers
 }
 
+// includesReload is the outcome of the Teardown of states following includes. It returns ErrReload when the files
+// included changed, the watcher then registers the files of the consumer again.
+func includesReload(logger zerolog.Logger, current, next []string) error {
+	if ArrayEqual(current, next) {
+		return nil
+	}
+	logger.Debug().Strs("old", current).Strs("new", next).Msg("includes changed")
+	return ErrReload
+}
+
 /* --------------------------------- USERS --------------------------------- */
 
 type (
@@ -132,32 +452,25 @@ func (us *UsersState) Changed() bool {
 func (us *UsersState) Created() bool { return len(us.current.users) == 0 }
 
 // Notify is the method to notify of a change in state
-func (us *UsersState) Notify(cmd string, user string) {
+func (us *UsersState) Notify(origin Origin) {
 	add, del := userDiff(us.current.users, us.next.users)
-	us.Warn().
+	origin.Log(origin.Event(us.Logger).
+		Str("consumer", ConsumerUsers).
+		Str("action", ActionModified).
+		Str("file", us.Passwd).
+		Strs("changes", userChanges(add, del)).
+		Array("findings", LogUserFindings(userFindings(add, del))).
 		Array("users", LogUsers(us.next.users)).
 		Array("add", LogUsers(add)).
-		Array("del", LogUsers(del)).
-		Str("processName", cmd).
-		Str("user", user).
-		Msg("Users Modified")
-}
-
-func (us *UsersState) reload() error {
-	if ArrayEqual(us.current.includes, us.next.includes) {
-		return nil
-	}
-	us.Debug().
-		Strs("old", us.current.includes).
-		Strs("new", us.next.includes).
-		Msg("includes changed")
-	return ErrReload
+		Array("del", LogUsers(del)),
+		"Users Modified")
 }
 
-// Teardown is the reset method when a change has been detected. Set new state to old state, and reload.
+// Teardown makes the new state current, see includesReload
 func (us *UsersState) Teardown() error {
+	err := includesReload(us.Logger, us.current.includes, us.next.includes)
 	us.current = us.next
-	return us.reload()
+	return err
 }
 
 // Register returns a list of files to watch for changes
@@ -168,12 +481,12 @@ func (us *UsersState) Register() []string {
 // Save commits a state to the local DB instance.
 func (us *UsersState) Save(db *AgentDB) error {
 	us.Debug().Array("users", LogUsers(us.next.users)).Msg("save users")
-	return db.SaveUsers(us.next.users)
+	return db.SaveUsers(us.Passwd, us.next.users)
 }
 
 // Load reads in current state from local db instance
 func (us *UsersState) Load(db *AgentDB) error {
-	users, err := db.LoadUsers()
+	users, err := db.LoadUsers(us.Passwd)
 	if err != nil {
 		return err
 	}
@@ -181,6 +494,69 @@ func (us *UsersState) Load(db *AgentDB) error {
 	return err
 }
 
+/* --------------------------------- GROUPS --------------------------------- */
+
+type (
+	// GroupsState struct keeps track of state changes based on GroupsListener struct and methods
+	GroupsState struct {
+		*GroupsListener
+		current, next Groups
+	}
+)
+
+// Parse calls parse(), and update new GroupsState
+func (gs *GroupsState) Parse() (State, error) {
+	groups, err := gs.parse()
+	if err != nil {
+		return nil, err
+	}
+	gs.next = groups
+	return gs, nil
+}
+
+// Changed checks if the new GroupsState instance is different from old GroupsState instance
+func (gs *GroupsState) Changed() bool {
+	add, del := groupDiff(gs.current, gs.next)
+	return len(add) != 0 || len(del) != 0
+}
+
+// Created checks if the current GroupsState has been created
+func (gs *GroupsState) Created() bool { return len(gs.current) == 0 }
+
+// Notify is the method to notify of a change in state
+func (gs *GroupsState) Notify(origin Origin) {
+	add, del := groupDiff(gs.current, gs.next)
+	changes, privileged := gs.groupChanges(add, del)
+	origin.Log(origin.Event(gs.Logger).
+		Str("consumer", ConsumerGroups).
+		Str("action", ActionModified).
+		Str("file", gs.Group).
+		Strs("changes", changes).
+		Strs("privileged", privileged).
+		Array("groups", LogGroups(gs.next)).
+		Array("add", LogGroups(add)).
+		Array("del", LogGroups(del)),
+		"Groups Modified")
+}
+
+// Teardown is the reset method when a change has been detected. Set new state to old state, and reload.
+func (gs *GroupsState) Teardown() error {
+	gs.current = gs.next
+	return nil
+}
+
+// Save commits a state to the local DB instance.
+func (gs *GroupsState) Save(db *AgentDB) error {
+	gs.Debug().Array("groups", LogGroups(gs.next)).Msg("save groups")
+	return db.SaveGroups(gs.Group, gs.next)
+}
+
+// Load reads in current state from local db instance
+func (gs *GroupsState) Load(db *AgentDB) (err error) {
+	gs.current, err = db.LoadGroups(gs.Group)
+	return
+}
+
 /* --------------------------------- ACCESS --------------------------------- */
 
 type (
@@ -211,15 +587,16 @@ func (as *AccessState) Changed() bool {
 func (as *AccessState) Created() bool { return as.current.IsEmpty() }
 
 // Notify is the method to notify of a change in state
-func (as *AccessState) Notify(cmd string, user string) {
+func (as *AccessState) Notify(origin Origin) {
 	add, del := accessDiff(as.current, as.next)
-	as.Warn().
+	origin.Log(origin.Event(as.Logger).
+		Str("consumer", ConsumerAccess).
+		Str("action", ActionModified).
+		Str("file", as.access).
 		Object("access", LogAccess(as.next)).
 		Object("add", LogAccess(add)).
-		Object("del", LogAccess(del)).
-		Str("processName", cmd).
-		Str("user", user).
-		Msg("access entries")
+		Object("del", LogAccess(del)),
+		"access entries")
 }
 
 // Teardown is the reset method when a change has been detected. Set new state to old state, and reload.
@@ -231,15 +608,529 @@ func (as *AccessState) Teardown() error {
 // Save commits a state to the local DB instance.
 func (as *AccessState) Save(db *AgentDB) error {
 	as.Debug().Object("access", LogAccess(as.next)).Msg("save access")
-	return db.SaveAccess(as.next)
+	return db.SaveAccess(as.access, as.next)
 }
 
 // Load reads in current state from local db instance
 func (as *AccessState) Load(db *AgentDB) (err error) {
-	as.current, err = db.LoadAccess()
+	as.current, err = db.LoadAccess(as.access)
 	return
 }
 
+/* --------------------------------- SUDOERS --------------------------------- */
+
+type (
+	sudoersState struct {
+		sudoers  Sudoers
+		includes []string
+		dirs     []string
+	}
+	// SudoersState struct keeps track of state changes based on SudoersListener struct and methods
+	SudoersState struct {
+		*SudoersListener
+		current, next *sudoersState
+	}
+)
+
+// Parse calls parse(), and update new SudoersState
+func (ss *SudoersState) Parse() (State, error) {
+	sudoers, includes, dirs, err := ss.parse()
+	if err != nil {
+		return nil, err
+	}
+	ss.next = &sudoersState{sudoers: sudoers, includes: append(includes, dirs...), dirs: dirs}
+	return ss, nil
+}
+
+// Changed checks if the new SudoersState instance is different from old SudoersState instance
+func (ss *SudoersState) Changed() bool {
+	add, del := sudoersDiff(ss.current.sudoers, ss.next.sudoers)
+	return !add.IsEmpty() || !del.IsEmpty()
+}
+
+// Created checks if the current SudoersState has been created
+func (ss *SudoersState) Created() bool { return ss.current.sudoers.IsEmpty() }
+
+// Notify is the method to notify of a change in state
+func (ss *SudoersState) Notify(origin Origin) {
+	add, del := sudoersDiff(ss.current.sudoers, ss.next.sudoers)
+	origin.Log(origin.Event(ss.Logger).
+		Str("consumer", ConsumerSudoers).
+		Str("action", ActionModified).
+		Str("file", ss.sudoers).
+		Strs("changes", sudoersChanges(add, del)).
+		Object("add", LogSudoers(add)).
+		Object("del", LogSudoers(del)),
+		"sudoers modified")
+}
+
+// Teardown makes the new state current, see includesReload
+func (ss *SudoersState) Teardown() error {
+	err := includesReload(ss.Logger, ss.current.includes, ss.next.includes)
+	ss.current = ss.next
+	return err
+}
+
+// Register returns a list of files to watch for changes
+func (ss *SudoersState) Register() []string {
+	return ss.SudoersListener.Register(ss.current.includes)
+}
+
+// OwnsDir reports if dir is an @includedir, new files there are parsed as part of sudoers
+func (ss *SudoersState) OwnsDir(dir string) bool {
+	for _, includeDir := range ss.current.dirs {
+		if includeDir == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// Save commits a state to the local DB instance.
+func (ss *SudoersState) Save(db *AgentDB) error {
+	ss.Debug().Object("sudoers", LogSudoers(ss.next.sudoers)).Msg("save sudoers")
+	return db.SaveSudoers(ss.sudoers, ss.next.sudoers)
+}
+
+// Load reads in current state from local db instance
+func (ss *SudoersState) Load(db *AgentDB) error {
+	sudoers, err := db.LoadSudoers(ss.sudoers)
+	if err != nil {
+		return err
+	}
+	ss.current = &sudoersState{sudoers: sudoers}
+	return nil
+}
+
+/* ---------------------------------- SSHD ----------------------------------- */
+
+type (
+	sshdState struct {
+		config   SSHDConfig
+		includes []string
+		dirs     []string
+	}
+	// SSHDState struct keeps track of state changes based on SSHDListener struct and methods
+	SSHDState struct {
+		*SSHDListener
+		current, next *sshdState
+	}
+)
+
+// Parse calls parse(), and update new SSHDState
+func (ss *SSHDState) Parse() (State, error) {
+	config, includes, dirs, err := ss.parse()
+	if err != nil {
+		return nil, err
+	}
+	ss.next = &sshdState{config: config, includes: append(includes, dirs...), dirs: dirs}
+	return ss, nil
+}
+
+// Changed checks if the effective settings of the new SSHDState differ from the old ones
+func (ss *SSHDState) Changed() bool {
+	changes, _ := sshdChanges(ss.current.config, ss.next.config, ss.Critical)
+	return len(changes) != 0
+}
+
+// Created checks if the current SSHDState has been created
+func (ss *SSHDState) Created() bool { return ss.current.config.IsEmpty() }
+
+// Notify is the method to notify of a change in state, changes of critical settings are reported at error level
+func (ss *SSHDState) Notify(origin Origin) {
+	changes, critical := sshdChanges(ss.current.config, ss.next.config, ss.Critical)
+	event := origin.Event(ss.Logger)
+	if len(critical) != 0 {
+		event = origin.CriticalEvent(ss.Logger)
+	}
+	origin.Log(event.
+		Str("consumer", ConsumerSSHD).
+		Str("action", ActionModified).
+		Str("file", ss.sshdConfig).
+		Strs("changes", changes).
+		Strs("critical", critical),
+		"sshd_config modified")
+}
+
+// Teardown makes the new state current, see includesReload
+func (ss *SSHDState) Teardown() error {
+	err := includesReload(ss.Logger, ss.current.includes, ss.next.includes)
+	ss.current = ss.next
+	return err
+}
+
+// Register returns a list of files to watch for changes
+func (ss *SSHDState) Register() []string {
+	return ss.SSHDListener.Register(ss.current.includes)
+}
+
+// OwnsDir reports if dir holds Include globs, new files there may be included in sshd_config
+func (ss *SSHDState) OwnsDir(dir string) bool {
+	for _, includeDir := range ss.current.dirs {
+		if includeDir == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// Save commits a state to the local DB instance.
+func (ss *SSHDState) Save(db *AgentDB) error {
+	ss.Debug().Object("sshd", LogSSHDConfig(ss.next.config)).Msg("save sshd_config")
+	return db.SaveSSHDConfig(ss.sshdConfig, ss.next.config)
+}
+
+// Load reads in current state from local db instance
+func (ss *SSHDState) Load(db *AgentDB) error {
+	config, err := db.LoadSSHDConfig(ss.sshdConfig)
+	if err != nil {
+		return err
+	}
+	ss.current = &sshdState{config: config}
+	return nil
+}
+
+/* ---------------------------------- CRON ----------------------------------- */
+
+type (
+	cronState struct {
+		cron     Cron
+		includes []string
+	}
+	// CronState struct keeps track of state changes based on CronListener struct and methods
+	CronState struct {
+		*CronListener
+		current, next *cronState
+	}
+)
+
+// Parse calls parse(), and update new CronState
+func (cs *CronState) Parse() (State, error) {
+	cron, includes, err := cs.parse()
+	if err != nil {
+		return nil, err
+	}
+	cs.next = &cronState{cron: cron, includes: includes}
+	return cs, nil
+}
+
+// Changed checks if the new CronState instance is different from old CronState instance
+func (cs *CronState) Changed() bool {
+	add, del := cronDiff(cs.current.cron, cs.next.cron)
+	return !add.IsEmpty() || !del.IsEmpty()
+}
+
+// Created checks if the current CronState has been created
+func (cs *CronState) Created() bool { return cs.current.cron.IsEmpty() }
+
+// Notify is the method to notify of a change in state
+func (cs *CronState) Notify(origin Origin) {
+	add, del := cronDiff(cs.current.cron, cs.next.cron)
+	origin.Log(origin.Event(cs.Logger).
+		Str("consumer", ConsumerCron).
+		Str("action", ActionModified).
+		Str("file", cs.key()).
+		Strs("changes", cronChanges(add, del)).
+		Object("add", LogCron(add)).
+		Object("del", LogCron(del)),
+		"cron modified")
+}
+
+// Teardown makes the new state current, see includesReload
+func (cs *CronState) Teardown() error {
+	err := includesReload(cs.Logger, cs.current.includes, cs.next.includes)
+	cs.current = cs.next
+	return err
+}
+
+// Register returns a list of files to watch for changes
+func (cs *CronState) Register() []string {
+	return cs.CronListener.Register(cs.current.includes)
+}
+
+// OwnsDir reports if dir holds crontabs or run-parts scripts, new files there are parsed as part of cron
+func (cs *CronState) OwnsDir(dir string) bool {
+	for _, cronDir := range cs.dirs() {
+		if cronDir == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// key is the system crontab, or the first directory without one, the state is saved under its path
+func (cs *CronState) key() string {
+	if cs.Crontab != "" {
+		return cs.Crontab
+	}
+	if dirs := cs.dirs(); len(dirs) > 0 {
+		return dirs[0]
+	}
+	return ""
+}
+
+// Save commits a state to the local DB instance.
+func (cs *CronState) Save(db *AgentDB) error {
+	cs.Debug().Object("cron", LogCron(cs.next.cron)).Msg("save cron")
+	return db.SaveCron(cs.key(), cs.next.cron)
+}
+
+// Load reads in current state from local db instance
+func (cs *CronState) Load(db *AgentDB) error {
+	cron, err := db.LoadCron(cs.key())
+	if err != nil {
+		return err
+	}
+	cs.current = &cronState{cron: cron}
+	return nil
+}
+
+/* --------------------------------- SYSTEMD --------------------------------- */
+
+type (
+	systemdState struct {
+		systemd  Systemd
+		includes []string
+	}
+	// SystemdState struct keeps track of state changes based on SystemdListener struct and methods
+	SystemdState struct {
+		*SystemdListener
+		current, next *systemdState
+	}
+)
+
+// Parse calls parse(), and update new SystemdState
+func (ss *SystemdState) Parse() (State, error) {
+	systemd, includes, err := ss.parse()
+	if err != nil {
+		return nil, err
+	}
+	ss.next = &systemdState{systemd: systemd, includes: includes}
+	return ss, nil
+}
+
+// Changed checks if the new SystemdState instance is different from old SystemdState instance
+func (ss *SystemdState) Changed() bool {
+	return len(systemdChanges(ss.current.systemd, ss.next.systemd)) != 0
+}
+
+// Created checks if the current SystemdState has been created
+func (ss *SystemdState) Created() bool { return ss.current.systemd.IsEmpty() }
+
+// Notify is the method to notify of a change in state
+func (ss *SystemdState) Notify(origin Origin) {
+	origin.Log(origin.Event(ss.Logger).
+		Str("consumer", ConsumerSystemd).
+		Str("action", ActionModified).
+		Str("file", ss.key()).
+		Strs("changes", systemdChanges(ss.current.systemd, ss.next.systemd)),
+		"systemd units modified")
+}
+
+// Teardown makes the new state current, see includesReload
+func (ss *SystemdState) Teardown() error {
+	err := includesReload(ss.Logger, ss.current.includes, ss.next.includes)
+	ss.current = ss.next
+	return err
+}
+
+// Register returns a list of files to watch for changes
+func (ss *SystemdState) Register() []string {
+	return ss.SystemdListener.Register(ss.current.includes)
+}
+
+// OwnsDir reports if dir is a unit directory or one of its drop-in or enablement directories,
+// new files there are parsed as part of the units
+func (ss *SystemdState) OwnsDir(dir string) bool {
+	for _, unitDir := range ss.Dirs {
+		if unitDir == dir || unitDir == filepath.Dir(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// key is the unit directory of highest priority, the state is saved under its path
+func (ss *SystemdState) key() string {
+	if len(ss.Dirs) == 0 {
+		return ""
+	}
+	return ss.Dirs[0]
+}
+
+// Save commits a state to the local DB instance.
+func (ss *SystemdState) Save(db *AgentDB) error {
+	ss.Debug().Int("units", len(ss.next.systemd.Units)).Strs("links", ss.next.systemd.Links).Msg("save systemd units")
+	return db.SaveSystemd(ss.key(), ss.next.systemd)
+}
+
+// Load reads in current state from local db instance
+func (ss *SystemdState) Load(db *AgentDB) error {
+	systemd, err := db.LoadSystemd(ss.key())
+	if err != nil {
+		return err
+	}
+	ss.current = &systemdState{systemd: systemd}
+	return nil
+}
+
+/* ----------------------------------- PAM ----------------------------------- */
+
+type (
+	pamState struct {
+		pam      PAM
+		includes []string
+	}
+	// PAMState struct keeps track of state changes based on PAMListener struct and methods
+	PAMState struct {
+		*PAMListener
+		current, next *pamState
+	}
+)
+
+// Parse calls parse(), and update new PAMState
+func (ps *PAMState) Parse() (State, error) {
+	pam, includes, err := ps.parse()
+	if err != nil {
+		return nil, err
+	}
+	ps.next = &pamState{pam: pam, includes: includes}
+	return ps, nil
+}
+
+// Changed checks if the new PAMState instance is different from old PAMState instance
+func (ps *PAMState) Changed() bool {
+	return len(pamChanges(ps.current.pam, ps.next.pam)) != 0
+}
+
+// Created checks if the current PAMState has been created
+func (ps *PAMState) Created() bool { return ps.current.pam.IsEmpty() }
+
+// Notify is the method to notify of a change in state
+func (ps *PAMState) Notify(origin Origin) {
+	origin.Log(origin.Event(ps.Logger).
+		Str("consumer", ConsumerPAM).
+		Str("action", ActionModified).
+		Str("file", ps.pamDir).
+		Strs("changes", pamChanges(ps.current.pam, ps.next.pam)),
+		"pam modified")
+}
+
+// Teardown makes the new state current, see includesReload
+func (ps *PAMState) Teardown() error {
+	err := includesReload(ps.Logger, ps.current.includes, ps.next.includes)
+	ps.current = ps.next
+	return err
+}
+
+// Register returns a list of files to watch for changes
+func (ps *PAMState) Register() []string {
+	return ps.PAMListener.Register(ps.current.includes)
+}
+
+// OwnsDir reports if dir is pam.d, new service files there are parsed as part of pam
+func (ps *PAMState) OwnsDir(dir string) bool { return dir == ps.pamDir }
+
+// Save commits a state to the local DB instance.
+func (ps *PAMState) Save(db *AgentDB) error {
+	ps.Debug().Int("services", len(ps.next.pam.Stacks)).Msg("save pam")
+	return db.SavePAM(ps.pamDir, ps.next.pam)
+}
+
+// Load reads in current state from local db instance
+func (ps *PAMState) Load(db *AgentDB) error {
+	pam, err := db.LoadPAM(ps.pamDir)
+	if err != nil {
+		return err
+	}
+	ps.current = &pamState{pam: pam}
+	return nil
+}
+
+/* --------------------------------- LOADER ---------------------------------- */
+
+type (
+	loaderState struct {
+		loader   Loader
+		includes []string
+		dirs     []string
+	}
+	// LoaderState struct keeps track of state changes based on LoaderListener struct and methods
+	LoaderState struct {
+		*LoaderListener
+		current, next *loaderState
+	}
+)
+
+// Parse calls parse(), and update new LoaderState
+func (ls *LoaderState) Parse() (State, error) {
+	loader, includes, dirs, err := ls.parse(ls.current.loader)
+	if err != nil {
+		return nil, err
+	}
+	ls.next = &loaderState{loader: loader, includes: append(includes, dirs...), dirs: dirs}
+	return ls, nil
+}
+
+// Changed checks if the new LoaderState instance is different from old LoaderState instance
+func (ls *LoaderState) Changed() bool {
+	return len(loaderChanges(ls.current.loader, ls.next.loader)) != 0
+}
+
+// Created checks if the current LoaderState has been created
+func (ls *LoaderState) Created() bool { return ls.current.loader.IsEmpty() }
+
+// Notify is the method to notify of a change in state
+func (ls *LoaderState) Notify(origin Origin) {
+	origin.Log(origin.Event(ls.Logger).
+		Str("consumer", ConsumerLoader).
+		Str("action", ActionModified).
+		Str("file", ls.Config).
+		Strs("changes", loaderChanges(ls.current.loader, ls.next.loader)).
+		Strs("preload", ls.next.loader.Preload).
+		Strs("searchPath", ls.next.loader.SearchPath),
+		"loader modified")
+}
+
+// Teardown makes the new state current, see includesReload
+func (ls *LoaderState) Teardown() error {
+	err := includesReload(ls.Logger, ls.current.includes, ls.next.includes)
+	ls.current = ls.next
+	return err
+}
+
+// Register returns a list of files to watch for changes
+func (ls *LoaderState) Register() []string {
+	return ls.LoaderListener.Register(ls.current.includes)
+}
+
+// OwnsDir reports if dir is an include directory of ld.so.conf or in the search path,
+// new files there are parsed as part of the loader configuration or listed as libraries
+func (ls *LoaderState) OwnsDir(dir string) bool {
+	for _, loaderDir := range append(ls.current.dirs, ls.current.loader.SearchPath...) {
+		if loaderDir == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// Save commits a state to the local DB instance.
+func (ls *LoaderState) Save(db *AgentDB) error {
+	ls.Debug().Strs("preload", ls.next.loader.Preload).Strs("searchPath", ls.next.loader.SearchPath).Msg("save loader")
+	return db.SaveLoader(ls.Config, ls.next.loader)
+}
+
+// Load reads in current state from local db instance
+func (ls *LoaderState) Load(db *AgentDB) error {
+	loader, err := db.LoadLoader(ls.Config)
+	if err != nil {
+		return err
+	}
+	ls.current = &loaderState{loader: loader}
+	return nil
+}
+
 /* --------------------------------- Generic --------------------------------- */
 
 type (
@@ -270,7 +1161,10 @@ func (gs *GenericState) Changed() bool {
 	if gs.next.IsEmpty() && !gs.current.IsEmpty() {
 		return true
 	}
-	gs.Debug().Msgf("A: %v VS B: %v", gs.current.Contents, gs.next.Contents)
+	if gs.rotated() {
+		return true
+	}
+	gs.Debug().Msgf("A: %x VS B: %x", gs.current.Contents, gs.next.Contents)
 	res := bytes.Compare(gs.current.Contents, gs.next.Contents)
 	return res != 0
 }
@@ -279,31 +1173,50 @@ func (gs *GenericState) Changed() bool {
 func (gs *GenericState) Created() bool { return len(gs.current.Contents) == 0 }
 
 // Notify is the method to notify of a change in state
-func (gs *GenericState) Notify(cmd string, user string) {
+func (gs *GenericState) Notify(origin Origin) {
 	if gs.current.IsEmpty() {
-		gs.Warn().
+		origin.Log(origin.Event(gs.Logger).
+			Str("consumer", ConsumerGeneric).
+			Str("action", ActionCreated).
 			Object("generic", LogGeneric(*gs)).
-			Str("file", gs.File).
-			Str("processName", cmd).
-			Str("user", user).
-			Msg("generic file created")
+			Str("file", gs.File),
+			"generic file created")
 		return
 	}
 	if gs.next.IsEmpty() {
-		gs.Warn().
+		origin.Log(origin.Event(gs.Logger).
+			Str("consumer", ConsumerGeneric).
+			Str("action", ActionDeleted).
 			Object("generic", LogGeneric(*gs)).
-			Str("file", gs.File).
-			Str("processName", cmd).
-			Str("user", user).
-			Msg("generic file deleted")
+			Str("file", gs.File),
+			"generic file deleted")
 		return
 	}
-	gs.Warn().
+	if gs.rotated() {
+		// only digests are stored, the contents hashed with the previous digest or key can not be hashed again
+		origin.Log(origin.Event(gs.Logger).
+			Str("consumer", ConsumerGeneric).
+			Str("action", ActionModified).
+			Bool("unverifiable", true).
+			Str("old", gs.current.Digest+"/"+gs.current.KeyID).
+			Str("new", gs.next.Digest+"/"+gs.next.KeyID).
+			Object("generic", LogGeneric(*gs)).
+			Str("file", gs.File),
+			"generic file unverifiable, digest or key changed")
+		return
+	}
+	origin.Log(origin.Event(gs.Logger).
+		Str("consumer", ConsumerGeneric).
+		Str("action", ActionModified).
 		Object("generic", LogGeneric(*gs)).
-		Str("file", gs.File).
-		Str("processName", cmd).
-		Str("user", user).
-		Msg("generic file Modified")
+		Str("file", gs.File),
+		"generic file Modified")
+}
+
+// rotated checks if the digest or its key changed since the current state was hashed
+func (gs *GenericState) rotated() bool {
+	return !gs.current.IsEmpty() && !gs.next.IsEmpty() &&
+		(gs.current.Digest != gs.next.Digest || gs.current.KeyID != gs.next.KeyID)
 }
 
 // Teardown is the reset method when a change has been detected. Set new state to old state, and reload.
@@ -321,12 +1234,12 @@ func (gs *GenericState) Register() []string {
 // Save commits a state to the local DB instance.
 func (gs *GenericState) Save(db *AgentDB) error {
 	gs.Debug().Object("generic", LogGeneric(*gs)).Msg("save generic file")
-	return db.SaveGeneric(gs.next)
+	return db.SaveGeneric(gs.File, gs.next)
 }
 
 // Load reads in current state from local db instance
 func (gs *GenericState) Load(db *AgentDB) error {
-	generic, err := db.LoadGeneric()
+	generic, err := db.LoadGeneric(gs.File)
 	if err != nil {
 		return err
 	}
@@ -369,35 +1282,35 @@ func (gds *GenericDiffState) Changed() bool {
 func (gds *GenericDiffState) Created() bool { return gds.current.IsEmpty() }
 
 //Notify is the method to notify of a change in state
-func (gds *GenericDiffState) Notify(cmd string, user string) {
+func (gds *GenericDiffState) Notify(origin Origin) {
 	add, del := findGenericDiff(gds.current, gds.next)
 	if gds.current.IsEmpty() {
-		gds.Warn().
+		origin.Log(origin.Event(gds.Logger).
+			Str("consumer", ConsumerGenericDiff).
+			Str("action", ActionCreated).
 			Object("add", LogGenericDiff(add)).
 			Object("del", LogGenericDiff(del)).
-			Str("file", gds.genericDiff).
-			Str("processName", cmd).
-			Str("user", user).
-			Msg("Critical Generic file created")
+			Str("file", gds.genericDiff),
+			"Critical Generic file created")
 		return
 	}
 	if gds.next.IsEmpty() {
-		gds.Warn().
+		origin.Log(origin.Event(gds.Logger).
+			Str("consumer", ConsumerGenericDiff).
+			Str("action", ActionDeleted).
 			Object("add", LogGenericDiff(add)).
 			Object("del", LogGenericDiff(del)).
-			Str("file", gds.genericDiff).
-			Str("processName", cmd).
-			Str("user", user).
-			Msg("Critical Generic file deleted")
+			Str("file", gds.genericDiff),
+			"Critical Generic file deleted")
 		return
 	}
-	gds.Warn().
+	origin.Log(origin.Event(gds.Logger).
+		Str("consumer", ConsumerGenericDiff).
+		Str("action", ActionModified).
 		Object("add", LogGenericDiff(add)).
 		Object("del", LogGenericDiff(del)).
-		Str("file", gds.genericDiff).
-		Str("processName", cmd).
-		Str("user", user).
-		Msg("Critical Generic file modified")
+		Str("file", gds.genericDiff),
+		"Critical Generic file modified")
 }
 
 //Teardown is the reset method when a change has been detected. Set new state to old state, and reload.
@@ -415,12 +1328,12 @@ func (gds *GenericDiffState) Register() []string {
 //Save commits a state to the local DB instance.
 func (gds *GenericDiffState) Save(db *AgentDB) error {
 	gds.Debug().Object("generic diff", LogGenericDiff(gds.next)).Msg("Save critical generic file")
-	return db.SaveGenericDiff(gds.next)
+	return db.SaveGenericDiff(gds.genericDiff, gds.next)
 }
 
 //Load reads in current state from local db instance
 func (gds *GenericDiffState) Load(db *AgentDB) (err error) {
-	genericDiff, err := db.LoadGenericDiff()
+	genericDiff, err := db.LoadGenericDiff(gds.genericDiff)
 	if err != nil {
 		return err
 	}
@@ -441,6 +1354,7 @@ type FileMissing struct {
 	File string
 	Consumer
 	zerolog.Logger
+	Metrics *Metrics
 }
 
 const pollingDuration = 10 * time.Second
@@ -462,9 +1376,11 @@ func NewFileMissing(events chan Event, options ...func(*FileMissing)) *FileMissi
 
 func (fm *FileMissing) start(events chan Event) {
 	for range time.Tick(pollingDuration) { //nolint
+		fm.Metrics.RecordConsumer(MetricFileMissingPolls, ConsumerKind(fm.Consumer), PathClass(fm.File))
 		if _, err := os.Stat(fm.File); err == nil {
 			fm.Debug().Msg("file found")
-			events <- Event{Path: fm.File, Mode: 1}
+			// nobody is known to have created the file, the event must not be attributed to root
+			events <- Event{Path: fm.File, Mode: writeEvent, UID: UnknownUID, LoginUID: UnknownUID}
 			fm.Debug().Msg("pushed to event")
 			return
 		}
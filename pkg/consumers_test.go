@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/afero"
+)
+
+type initChange struct {
+	Level, Message string
+	Offline        bool
+	Mtime          string
+}
+
+// initUsers initializes a users consumer the way the agent does at start up and returns the changes it reported
+func initUsers(t *testing.T, db *AgentDB, dir string, notifyOnEmptyDB bool) (changes []initChange) {
+	var output bytes.Buffer
+	consumer := &BaseConsumer{AgentDB: db, NotifyOnEmptyDB: notifyOnEmptyDB, ParserLoader: &UsersState{
+		UsersListener: NewUsersListener(func(l *UsersListener) {
+			l.Fs, l.Passwd, l.Shadow = afero.NewOsFs(), filepath.Join(dir, "passwd"), filepath.Join(dir, "shadow")
+			l.Logger = zerolog.New(&output).Level(zerolog.WarnLevel)
+		}),
+	}}
+	if err := consumer.Init(); err != nil {
+		t.Fatal(err)
+	}
+	decoder := json.NewDecoder(&output)
+	for decoder.More() {
+		var change initChange
+		if err := decoder.Decode(&change); err != nil {
+			t.Fatal(err)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// writeUsers writes the passwd and shadow files of an account in a temporary directory
+func writeUsers(t *testing.T, dir, password string) {
+	passwd := "alice:x:1000:1000::" + filepath.Join(dir, "alice") + ":/bin/sh\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "passwd"), []byte(passwd), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "shadow"), []byte("alice:"+password+":18000:0:99999:7:::\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func usersDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "bpfink_init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeUsers(t, dir, "$6$salt$first")
+	return dir, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestInitFirstRun(t *testing.T) {
+	dir, remove := usersDir(t)
+	defer remove()
+	db, closeDB := openTestDB(t)
+	defer closeDB()
+
+	if changes := initUsers(t, db, dir, false); len(changes) != 0 {
+		t.Errorf("Init want no change without a previous state, got: %+v", changes)
+	}
+}
+
+func TestInitFirstRunNotifyOnEmptyDB(t *testing.T) {
+	dir, remove := usersDir(t)
+	defer remove()
+	db, closeDB := openTestDB(t)
+	defer closeDB()
+
+	changes := initUsers(t, db, dir, true)
+	if len(changes) != 1 || !changes[0].Offline || changes[0].Mtime == "" ||
+		changes[0].Message != "Users Modified while agent offline" {
+		t.Fatalf("Init want the initial state reported as an offline change, got: %+v", changes)
+	}
+	if changes := initUsers(t, db, dir, true); len(changes) != 0 {
+		t.Errorf("Init want no change once a state is persisted, got: %+v", changes)
+	}
+}
+
+func TestInitOfflineChange(t *testing.T) {
+	dir, remove := usersDir(t)
+	defer remove()
+	db, closeDB := openTestDB(t)
+	defer closeDB()
+
+	if changes := initUsers(t, db, dir, false); len(changes) != 0 {
+		t.Fatalf("Init want no change without a previous state, got: %+v", changes)
+	}
+	if changes := initUsers(t, db, dir, false); len(changes) != 0 {
+		t.Errorf("Init want no change when nothing changed between runs, got: %+v", changes)
+	}
+	writeUsers(t, dir, "$6$salt$second")
+	changes := initUsers(t, db, dir, false)
+	if len(changes) != 1 || changes[0].Level != "warn" || !changes[0].Offline ||
+		changes[0].Message != "Users Modified while agent offline" {
+		t.Errorf("Init want the change made while the agent was stopped reported as offline, got: %+v", changes)
+	}
+}
+
+func TestIncludesReload(t *testing.T) {
+	logger := zerolog.Nop()
+	if err := includesReload(logger, []string{"/etc/sudoers.d"}, []string{"/etc/sudoers.d"}); err != nil {
+		t.Errorf("includesReload want no reload with the same includes, got: %v", err)
+	}
+	if err := includesReload(logger, nil, []string{"/etc/sudoers.d/admins"}); err != ErrReload {
+		t.Errorf("includesReload want a reload once includes changed, got: %v", err)
+	}
+
+	// the includes are compared before the new state becomes current
+	state := &SudoersState{
+		SudoersListener: NewSudoersListener(),
+		current:         &sudoersState{includes: []string{"/etc/sudoers.d"}},
+		next:            &sudoersState{includes: []string{"/etc/sudoers.d", "/etc/sudoers.d/admins"}},
+	}
+	if err := state.Teardown(); err != ErrReload || state.current != state.next {
+		t.Errorf("Teardown want a reload and the new state current, got: %v", err)
+	}
+	if err := state.Teardown(); err != nil {
+		t.Errorf("Teardown want no reload without include change, got: %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/afero"
+
+	"github.com/bookingcom/bpfink/pkg/lang/cron"
+)
+
+// cronScriptsUser runs the scripts of the run-parts directories, i.e. /etc/cron.daily
+const cronScriptsUser = "root"
+
+type (
+	// CronJob struct representing one scheduled command and the crontab or run-parts directory scheduling it.
+	// Scripts of run-parts directories have a Digest of their content.
+	CronJob struct {
+		File, User, Schedule, Command string
+		Digest                        string
+	}
+	// Cron struct used to store the jobs of every crontab and the variables they set, i.e. "/etc/crontab: PATH=/bin"
+	Cron struct {
+		Jobs []CronJob
+		Env  []string
+	}
+	// CronListener struct used for filestream events.
+	CronListener struct {
+		zerolog.Logger
+		afero.Fs
+		Crontab string
+		// Dirs of system crontabs, Scripts of run-parts directories, Spools of crontabs named after their user
+		Dirs, Scripts, Spools []string
+	}
+)
+
+// String renders a job the way it reads in a user crontab
+func (j CronJob) String() string { return j.Schedule + " " + j.Command }
+
+// id identifies a job across changes of its schedule or content
+func (j CronJob) id() string { return strings.Join([]string{j.File, j.User, j.Command}, "\x00") }
+
+// IsEmpty method to check if diff is empty
+func (c Cron) IsEmpty() bool { return len(c.Jobs) == 0 && len(c.Env) == 0 }
+
+func (c Cron) jobs() map[string][]CronJob {
+	jobs := make(map[string][]CronJob, len(c.Jobs))
+	for _, job := range c.Jobs {
+		jobs[job.id()] = append(jobs[job.id()], job)
+	}
+	return jobs
+}
+
+func cronDiff(old, new Cron) (add, del Cron) {
+	add.Env, del.Env = ArrayDiff(old.Env, new.Env)
+	oldJobs, newJobs := old.jobs(), new.jobs()
+	contains := func(jobs []CronJob, job CronJob) bool {
+		for _, other := range jobs {
+			if other == job {
+				return true
+			}
+		}
+		return false
+	}
+	for id, jobs := range newJobs {
+		for _, job := range jobs {
+			if !contains(oldJobs[id], job) {
+				add.Jobs = append(add.Jobs, job)
+			}
+		}
+	}
+	for id, jobs := range oldJobs {
+		for _, job := range jobs {
+			if !contains(newJobs[id], job) {
+				del.Jobs = append(del.Jobs, job)
+			}
+		}
+	}
+	return
+}
+
+// cronChanges describes a diff in plain words, i.e. "alice job added in /var/spool/cron/crontabs/alice: @reboot /tmp/x".
+// A job found once on both sides with another schedule or content is reported as changed.
+func cronChanges(add, del Cron) (changes []string) {
+	added, removed := add.jobs(), del.jobs()
+	for id, jobs := range added {
+		if len(jobs) == 1 && len(removed[id]) == 1 {
+			job, previous := jobs[0], removed[id][0]
+			if previous.Schedule != job.Schedule {
+				changes = append(changes, fmt.Sprintf("%s job changed in %s: %s, schedule changed from %s to %s",
+					job.User, job.File, job.Command, previous.Schedule, job.Schedule))
+			} else {
+				changes = append(changes, fmt.Sprintf("%s job changed in %s: %s, content modified", job.User, job.File, job))
+			}
+			delete(removed, id)
+			continue
+		}
+		for _, job := range jobs {
+			changes = append(changes, fmt.Sprintf("%s job added in %s: %s", job.User, job.File, job))
+		}
+	}
+	for _, jobs := range removed {
+		for _, job := range jobs {
+			changes = append(changes, fmt.Sprintf("%s job removed in %s: %s", job.User, job.File, job))
+		}
+	}
+	for _, variable := range add.Env {
+		changes = append(changes, fmt.Sprintf("%s set", variable))
+	}
+	for _, variable := range del.Env {
+		changes = append(changes, fmt.Sprintf("%s unset", variable))
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// NewCronListener function to create a new file event listener
+func NewCronListener(options ...func(*CronListener)) *CronListener {
+	cl := &CronListener{Logger: zerolog.Nop()}
+	for _, option := range options {
+		option(cl)
+	}
+	return cl
+}
+
+// dirs returns the configured directories, new files there are parsed as part of cron
+func (cl *CronListener) dirs() []string {
+	return append(append(append([]string(nil), cl.Dirs...), cl.Scripts...), cl.Spools...)
+}
+
+// parse reads every crontab and run-parts script, the parsed files and directories are returned to be watched
+func (cl *CronListener) parse() (Cron, []string, error) {
+	cl.Debug().Msgf("parsing crontabs: %v %v", cl.Crontab, cl.dirs())
+	content := Cron{}
+	var files []string
+	crontab := func(file, user string, system bool) error {
+		parser := cron.Parser{FileName: file, System: system, Logger: cl.Logger}
+		if err := parser.Parse(); err != nil {
+			return err
+		}
+		files = append(files, file)
+		for _, job := range parser.Jobs {
+			if system {
+				user = job.User
+			}
+			content.Jobs = append(content.Jobs, CronJob{File: file, User: user, Schedule: job.Schedule, Command: job.Command})
+		}
+		for _, variable := range parser.Env {
+			content.Env = append(content.Env, fmt.Sprintf("%s: %s", file, variable))
+		}
+		return nil
+	}
+	if cl.Crontab != "" {
+		if err := crontab(cl.Crontab, "", true); err != nil {
+			return Cron{}, nil, err
+		}
+	}
+	for _, dir := range cl.Dirs {
+		for _, file := range cl.readDir(dir) {
+			if err := crontab(file, "", true); err != nil {
+				cl.Warn().Err(err).Str("file", file).Msg("failed to parse crontab")
+			}
+		}
+	}
+	for _, dir := range cl.Spools {
+		for _, file := range cl.readDir(dir) {
+			if err := crontab(file, filepath.Base(file), false); err != nil {
+				cl.Warn().Err(err).Str("file", file).Msg("failed to parse crontab")
+			}
+		}
+	}
+	for _, dir := range cl.Scripts {
+		// run-parts directories are scheduled after their name, i.e. /etc/cron.daily runs @daily
+		schedule := "@" + strings.TrimPrefix(filepath.Base(dir), "cron.")
+		for _, file := range cl.readDir(dir) {
+			script, err := ioutil.ReadFile(file)
+			if err != nil {
+				cl.Warn().Err(err).Str("file", file).Msg("failed to read cron script")
+				continue
+			}
+			sum := sha256.Sum256(script)
+			files = append(files, file)
+			content.Jobs = append(content.Jobs, CronJob{
+				File: file, User: cronScriptsUser, Schedule: schedule, Command: file, Digest: hex.EncodeToString(sum[:]),
+			})
+		}
+	}
+	return content, files, nil
+}
+
+// readDir returns the regular files of a directory, a missing directory has none
+func (cl *CronListener) readDir(dir string) (files []string) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !IsNotExist(err) {
+			cl.Warn().Err(err).Str("dir", dir).Msg("failed to read cron directory")
+		}
+		return nil
+	}
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			files = append(files, filepath.Join(dir, info.Name()))
+		}
+	}
+	return files
+}
+
+// Files returns the crontabs, scripts and directories parsed
+func (cl *CronListener) Files() []string {
+	_, files, err := cl.parse()
+	if err != nil {
+		files = nil
+		if cl.Crontab != "" {
+			files = append(files, cl.Crontab)
+		}
+	}
+	return append(files, cl.dirs()...)
+}
+
+// Register method returns list of paths to files to be watched
+func (cl *CronListener) Register(includes []string) (out []string) {
+	files := append(cl.dirs(), includes...)
+	if cl.Crontab != "" {
+		files = append(files, cl.Crontab)
+	}
+	if base, ok := cl.Fs.(*afero.BasePathFs); ok {
+		for _, file := range files {
+			rpath, _ := base.RealPath(file)
+			out = append(out, rpath)
+		}
+		return ArrayClean(out)
+	}
+	return ArrayClean(files)
+}
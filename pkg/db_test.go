@@ -0,0 +1,208 @@
+package pkg
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/rs/zerolog"
+	bolt "go.etcd.io/bbolt"
+)
+
+func openTestDB(t *testing.T) (*AgentDB, func()) {
+	dir, err := ioutil.TempDir("", "bpfink_db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := bolt.Open(path.Join(dir, "bpfink.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &AgentDB{Logger: zerolog.Nop(), DB: db}, func() {
+		_ = db.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestGenericStatePerFile(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+
+	if err := db.SaveGeneric("/etc/a", Generic{Contents: []byte("a")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveGeneric("/etc/b", Generic{Contents: []byte("b")}); err != nil {
+		t.Fatal(err)
+	}
+	for file, want := range map[string]string{"/etc/a": "a", "/etc/b": "b", "/etc/c": ""} {
+		generic, err := db.LoadGeneric(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(generic.Contents, []byte(want)) {
+			t.Errorf("LoadGeneric(%s) want: %q, got: %q", file, want, generic.Contents)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(bpfinkDB))
+		if err != nil {
+			return err
+		}
+		for key, value := range map[string]interface{}{
+			accessKey:  Access{Grant: []string{"root"}},
+			genericKey: Generic{Contents: []byte("legacy")},
+		} {
+			bytes, err := GobMarshal(value)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(key), bytes); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Migrate("/passwd", "/shadow", "/access.conf", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	access, err := db.LoadAccess("/access.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ArrayEqual(access.Grant, []string{"root"}) {
+		t.Errorf("migrated access want: [root], got: %v", access.Grant)
+	}
+
+	// a second migration must not touch states saved with the new layout
+	if err := db.SaveAccess("/access.conf", Access{Grant: []string{"john"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate("/passwd", "/shadow", "/access.conf", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if access, _ = db.LoadAccess("/access.conf"); !ArrayEqual(access.Grant, []string{"john"}) {
+		t.Errorf("access after second migration want: [john], got: %v", access.Grant)
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(bpfinkDB)).Get([]byte(genericKey)) != nil {
+			t.Error("legacy generic state was not dropped")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateGeneric(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	var output bytes.Buffer
+	db.Logger = zerolog.New(&output).Level(zerolog.WarnLevel)
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(bpfinkDB))
+		if err != nil {
+			return err
+		}
+		for key, value := range map[string]interface{}{
+			genericKey:     Generic{Contents: []byte("legacy")},
+			genericDiffKey: GenericDiff{Rule: []string{"PermitRootLogin no"}},
+		} {
+			bytes, err := GobMarshal(value)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(key), bytes); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a single generic file owns the legacy state, two genericDiff files can not be told apart
+	if err := db.Migrate("/passwd", "/shadow", "/access.conf", []string{"/etc/hosts"}, []string{"/etc/a", "/etc/b"}); err != nil {
+		t.Fatal(err)
+	}
+	generic, err := db.LoadGeneric("/etc/hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(generic.Contents, []byte("legacy")) {
+		t.Errorf("migrated generic want: %q, got: %q", "legacy", generic.Contents)
+	}
+	for _, file := range []string{"/etc/a", "/etc/b"} {
+		if genericDiff, _ := db.LoadGenericDiff(file); len(genericDiff.Rule) != 0 {
+			t.Errorf("genericDiff of %s want no state, got: %v", file, genericDiff.Rule)
+		}
+	}
+	for _, want := range []string{`"key":"genericDiff"`, `"files":["/etc/a","/etc/b"]`, `"rules":["PermitRootLogin no"]`} {
+		if !bytes.Contains(output.Bytes(), []byte(want)) {
+			t.Errorf("want the dropped state logged with %s, got: %s", want, output.String())
+		}
+	}
+	if bytes.Contains(output.Bytes(), []byte(`"key":"generic"`)) {
+		t.Errorf("want the migrated generic state not reported as dropped, got: %s", output.String())
+	}
+}
+
+func TestMigrateKeys(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+
+	type legacyUser struct {
+		Name, Password string
+		Keys           []string
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		for bucket, entries := range map[string]map[string]interface{}{
+			bpfinkDB: {versionKey: 1},
+			usersKey: {"/passwd": map[string]*legacyUser{"bob": {Name: "bob", Keys: []string{rsaBody + " bob"}}}},
+		} {
+			b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+			if err != nil {
+				return err
+			}
+			for key, value := range entries {
+				bytes, err := GobMarshal(value)
+				if err != nil {
+					return err
+				}
+				if err := b.Put([]byte(key), bytes); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Migrate("/passwd", "/shadow", "/access.conf", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	users, err := db.LoadUsers("/passwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AuthorizedKey{{Type: "ssh-rsa", Fingerprint: rsaFP, Comment: "bob"}}
+	if bob, ok := users["bob"]; !ok || len(bob.Keys) != 1 || bob.Keys[0].String() != want[0].String() {
+		t.Errorf("migrated keys want: %+v, got: %+v", want, users["bob"])
+	}
+}
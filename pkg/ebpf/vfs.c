@@ -8,12 +8,13 @@
 #include <linux/stat.h>
 #include <linux/types.h>
 #include <linux/kdev_t.h>
+#include <linux/version.h>
 
 #include "include/bpf_helpers.h"
 
 struct data_t {
     int mode;
-    u32 pid;
+    u32 pid; // tgid, the process id as seen from user space
     u32 uid;
     u32 sz;
     u64 inode;
@@ -22,6 +23,9 @@ struct data_t {
     u64 new_device; // destination file inode while renaming
     char comm[TASK_COMM_LEN];
     char name[32];
+    u32 ppid; // tgid of the real parent
+    u32 loginuid; // audit login uid, (u32)-1 when unset
+    u64 exe_inode; // inode of the executable
 };
 
 
@@ -59,6 +63,15 @@ struct dentry_sm {
 };
 #define PIN_GLOBAL_NS 2
 
+// notify_change and the xattr functions take the user namespace, the idmap of the mount since 6.3, as first argument since 5.12
+#if LINUX_VERSION_CODE >= KERNEL_VERSION(5, 12, 0)
+#define PT_REGS_ATTR_DENTRY(ctx) PT_REGS_PARM2(ctx)
+#define PT_REGS_ATTR_ARG(ctx) PT_REGS_PARM3(ctx)
+#else
+#define PT_REGS_ATTR_DENTRY(ctx) PT_REGS_PARM1(ctx)
+#define PT_REGS_ATTR_ARG(ctx) PT_REGS_PARM2(ctx)
+#endif
+
 struct bpf_map_def SEC("maps/events") events = {
 	.type = BPF_MAP_TYPE_PERF_EVENT_ARRAY,
 	.key_size = sizeof(int),
@@ -80,6 +93,44 @@ struct bpf_map_def SEC("maps/rules") rules = {
 };
 
 
+// process ancestry is captured in kernel space, short-lived writers are usually gone
+// by the time user space could read it from /proc
+static __always_inline void fill_process(struct data_t *data) {
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+
+    struct task_struct *parent;
+    bpf_probe_read(&parent, sizeof(parent), (u64)&task->real_parent);
+    bpf_probe_read(&data->ppid, sizeof(data->ppid), (u64)&parent->tgid);
+
+    u64 exe_inode = ({
+        typeof(unsigned long) _val;
+        __builtin_memset(&_val, 0, sizeof(_val));
+        bpf_probe_read(&_val, sizeof(_val), (u64)&({
+            typeof(struct inode *) _val;
+            __builtin_memset(&_val, 0, sizeof(_val));
+            bpf_probe_read(&_val, sizeof(_val), (u64)&({
+                typeof(struct file *) _val;
+                __builtin_memset(&_val, 0, sizeof(_val));
+                bpf_probe_read(&_val, sizeof(_val), (u64)&({
+                    typeof(struct mm_struct *) _val;
+                    __builtin_memset(&_val, 0, sizeof(_val));
+                    bpf_probe_read(&_val, sizeof(_val), (u64)&task->mm);
+                    _val;
+                })->exe_file);
+                _val;
+            })->f_inode);
+            _val;
+        })->i_ino);
+        _val;
+    });
+    data->exe_inode = exe_inode;
+
+    data->loginuid = (u32)-1;
+#ifdef CONFIG_AUDITSYSCALL
+    bpf_probe_read(&data->loginuid, sizeof(data->loginuid), (u64)&task->loginuid);
+#endif
+}
+
 static __always_inline bool inode_matches_device(u64 expected_device, struct inode_sm *inode, bool need_to_read_superblock) {
     // file is uniquely identified by the (inode, dev) pair
     // so far we catch a event for the file with matched inode
@@ -127,6 +178,7 @@ int trace_write_entry(struct pt_regs *ctx){
         u64 id = bpf_get_current_pid_tgid();
         data.mode = 1; //constant defining write, will clean up later
         data.pid = id >> 32;
+        fill_process(&data);
         data.uid = bpf_get_current_uid_gid() & 0xFFFFFFFF;
         data.inode = inode.i_ino;
 
@@ -183,6 +235,7 @@ int trace_vfs_rename(struct pt_regs *ctx) {
         u64 id = bpf_get_current_pid_tgid();
         data.mode = 0; //constant defining rename, will clean up later
         data.pid = id >> 32;
+        fill_process(&data);
         data.uid = bpf_get_current_uid_gid() & 0xFFFFFFFF;
         data.inode = old_dir.i_ino;
         data.device = oldInode;
@@ -224,6 +277,7 @@ int trace_vfs_unlink(struct pt_regs *ctx) {
         u64 id = bpf_get_current_pid_tgid();
         data.mode = -1; //constant defining unlink, will clean up later
         data.pid = id >> 32;
+        fill_process(&data);
         data.uid = bpf_get_current_uid_gid() & 0xFFFFFFFF;
         data.inode = oldInode;
 
@@ -263,6 +317,7 @@ int trace_vfs_rmdir(struct pt_regs *ctx) {
         u64 id = bpf_get_current_pid_tgid();
         data.mode = -2; //constant defining rmdir,
         data.pid = id >> 32;
+        fill_process(&data);
         data.uid = bpf_get_current_uid_gid() & 0xFFFFFFFF;
         data.inode = inode_number;
 
@@ -327,6 +382,7 @@ int trace_done_path_create(struct pt_regs *ctx) {
         u64 id = bpf_get_current_pid_tgid();
         data.mode = 3; //constant defining mkdir,
         data.pid = id >> 32;
+        fill_process(&data);
         data.uid = bpf_get_current_uid_gid() & 0xFFFFFFFF;
         data.inode = parent_inode_number;
         data.device = child_inode_number;
@@ -387,6 +443,7 @@ int trace_do_dentry_open(struct pt_regs *ctx) {
         u64 id = bpf_get_current_pid_tgid();
         data.mode = 4; //constant defining create new file,
         data.pid = id >> 32;
+        fill_process(&data);
         data.uid = bpf_get_current_uid_gid() & 0xFFFFFFFF;
         data.inode = parent_inode_number;
         data.device = inode.i_ino;
@@ -397,6 +454,102 @@ int trace_do_dentry_open(struct pt_regs *ctx) {
 }
 
 
+SEC("kprobe/notify_change") //chmod, chown
+int trace_notify_change(struct pt_regs *ctx) {
+    struct data_t data = {};
+    if (bpf_get_current_comm(&data.comm, sizeof(data.comm)) == 0) {
+        typeof(struct inode *) file_inode;
+        __builtin_memset(&file_inode, 0, sizeof(file_inode));
+        bpf_probe_read(&file_inode, sizeof(file_inode), (u64)&({
+            typeof(struct dentry *) _val;
+            __builtin_memset(&_val, 0, sizeof(_val));
+            bpf_probe_read(&_val, sizeof(_val), (u64)&PT_REGS_ATTR_DENTRY(ctx));
+            _val;
+        })->d_inode);
+
+        u64 inode_number = ({
+            typeof(dev_t) _val;
+            __builtin_memset(&_val, 0, sizeof(_val));
+            bpf_probe_read(&_val, sizeof(_val), (u64)&(file_inode)->i_ino);
+            _val;
+        });
+
+        u64 *rule_exists = bpf_map_lookup_elem(&rules, &inode_number);
+        if (rule_exists == 0 || !inode_matches_device(*rule_exists, file_inode, true)) {
+            return 0;
+        }
+
+        // truncates and timestamp updates go through notify_change as well, only keep ownership and mode changes
+        unsigned int ia_valid = 0;
+        bpf_probe_read(&ia_valid, sizeof(ia_valid), (u64)&((struct iattr *)PT_REGS_ATTR_ARG(ctx))->ia_valid);
+        if (!(ia_valid & (ATTR_MODE | ATTR_UID | ATTR_GID))) {
+            return 0;
+        }
+
+        u64 id = bpf_get_current_pid_tgid();
+        data.mode = 5; //constant defining attribute change,
+        data.pid = id >> 32;
+        fill_process(&data);
+        data.uid = bpf_get_current_uid_gid() & 0xFFFFFFFF;
+        data.inode = inode_number;
+
+        u32 cpu = bpf_get_smp_processor_id();
+        bpf_perf_event_output(ctx, &events, cpu, &data, sizeof(data));
+    }
+    return 0;
+}
+
+static __always_inline int trace_xattr(struct pt_regs *ctx) {
+    struct data_t data = {};
+    if (bpf_get_current_comm(&data.comm, sizeof(data.comm)) == 0) {
+        typeof(struct inode *) file_inode;
+        __builtin_memset(&file_inode, 0, sizeof(file_inode));
+        bpf_probe_read(&file_inode, sizeof(file_inode), (u64)&({
+            typeof(struct dentry *) _val;
+            __builtin_memset(&_val, 0, sizeof(_val));
+            bpf_probe_read(&_val, sizeof(_val), (u64)&PT_REGS_ATTR_DENTRY(ctx));
+            _val;
+        })->d_inode);
+
+        u64 inode_number = ({
+            typeof(dev_t) _val;
+            __builtin_memset(&_val, 0, sizeof(_val));
+            bpf_probe_read(&_val, sizeof(_val), (u64)&(file_inode)->i_ino);
+            _val;
+        });
+
+        u64 *rule_exists = bpf_map_lookup_elem(&rules, &inode_number);
+        if (rule_exists == 0 || !inode_matches_device(*rule_exists, file_inode, true)) {
+            return 0;
+        }
+
+        // name of the extended attribute, i.e. security.capability
+        bpf_probe_read(&data.name, sizeof(data.name), (void *)PT_REGS_ATTR_ARG(ctx));
+
+        u64 id = bpf_get_current_pid_tgid();
+        data.mode = 6; //constant defining extended attribute change,
+        data.pid = id >> 32;
+        fill_process(&data);
+        data.uid = bpf_get_current_uid_gid() & 0xFFFFFFFF;
+        data.inode = inode_number;
+
+        u32 cpu = bpf_get_smp_processor_id();
+        bpf_perf_event_output(ctx, &events, cpu, &data, sizeof(data));
+    }
+    return 0;
+}
+
+SEC("kprobe/vfs_setxattr")
+int trace_vfs_setxattr(struct pt_regs *ctx) {
+    return trace_xattr(ctx);
+}
+
+SEC("kprobe/vfs_removexattr")
+int trace_vfs_removexattr(struct pt_regs *ctx) {
+    return trace_xattr(ctx);
+}
 
 char _license[] SEC("license") = "GPL";
 __u32 _version SEC("version") = 0xFFFFFFFE;
+// version of the data_t layout, bump it along with BPFLayout in pkg/kernel.go whenever data_t changes
+__u32 _layout SEC("layout") = 2;
@@ -0,0 +1,315 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/bpfink/pkg/lang/generic"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// FormatJSON renders alerts as the JSON object of their keys, as logged
+	FormatJSON = "json"
+	// FormatCEF renders alerts as ArcSight Common Event Format lines
+	FormatCEF = "cef"
+	// FormatECS renders alerts as Elastic Common Schema JSON documents
+	FormatECS = "ecs"
+
+	cefVendor  = "Booking.com"
+	cefProduct = "bpfink"
+	ecsVersion = "1.12.0"
+)
+
+// nolint:gochecknoglobals
+var (
+	// originKeys are the keys added by Origin.Log and the alert itself, the other keys are the consumer payload
+	originKeys = map[string]bool{
+		zerolog.LevelFieldName: true, zerolog.MessageFieldName: true, zerolog.TimestampFieldName: true,
+		"version": true, "consumer": true, "action": true, "file": true, "processName": true, "user": true,
+		"loginUser": true, "exeInode": true, "processChain": true, "ancestry": true, "rule": true, "tag": true,
+	}
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+type (
+	// Encoder describes how a sink renders an alert
+	Encoder interface {
+		Encode(Alert) ([]byte, error)
+	}
+	// JSONEncoder struct rendering alerts as logged
+	JSONEncoder struct{}
+	// CEFEncoder struct rendering alerts as CEF lines, see docs/README.md for the mapping
+	CEFEncoder struct{}
+	// ECSEncoder struct rendering alerts as ECS documents, see docs/README.md for the mapping
+	ECSEncoder struct{}
+
+	// alertProcess is the writer or its parent as found in the ancestry of an alert
+	alertProcess struct {
+		PID     json.Number `json:"pid"`
+		Comm    string      `json:"comm"`
+		Exe     string      `json:"exe"`
+		Cmdline string      `json:"cmdline"`
+		ok      bool
+	}
+)
+
+// NewEncoder returns the encoder of a format, JSON when empty
+func NewEncoder(format string) (Encoder, error) {
+	switch format {
+	case "", FormatJSON:
+		return JSONEncoder{}, nil
+	case FormatCEF:
+		return CEFEncoder{}, nil
+	case FormatECS:
+		return ECSEncoder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, choices are %q, %q, %q", format, FormatJSON, FormatCEF, FormatECS)
+	}
+}
+
+// encode renders an alert with an encoder, JSON when none is set
+func encode(encoder Encoder, alert Alert) ([]byte, error) {
+	if encoder == nil {
+		encoder = JSONEncoder{}
+	}
+	return encoder.Encode(alert)
+}
+
+// Encode method renders an alert as its JSON object
+func (JSONEncoder) Encode(alert Alert) ([]byte, error) {
+	return json.Marshal(alert)
+}
+
+// processes returns the writer and its parent from the ancestry of an alert
+func (a Alert) processes() (writer, parent alertProcess) {
+	ancestry, _ := a.Fields["ancestry"].([]interface{})
+	for i, process := range ancestry {
+		if i > 1 {
+			break
+		}
+		current := alertProcess{}
+		if bytes, err := json.Marshal(process); err == nil && json.Unmarshal(bytes, &current) == nil {
+			current.ok = true
+		}
+		if i == 0 {
+			writer = current
+		} else {
+			parent = current
+		}
+	}
+	if writer.Cmdline == "" {
+		writer.Cmdline = a.Str("processName")
+	}
+	return writer, parent
+}
+
+// hash returns the digest algorithm and value of a generic file alert
+func (a Alert) hash() (algorithm, value string) {
+	generic, _ := a.Fields["generic"].(map[string]interface{})
+	algorithm, _ = generic["digest"].(string)
+	if value, _ = generic["next"].(string); value == "" {
+		value, _ = generic["current"].(string)
+	}
+	return algorithm, value
+}
+
+// changes returns the plain words changes of an alert
+func (a Alert) changes() (changes []string) {
+	values, _ := a.Fields["changes"].([]interface{})
+	for _, value := range values {
+		if change, ok := value.(string); ok {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// eventAction returns the consumer and action of an alert, i.e. users-modified
+func (a Alert) eventAction() string {
+	consumer, action := a.Str("consumer"), a.Str("action")
+	if consumer == "" {
+		consumer = "bpfink"
+	}
+	if action == "" {
+		action = ActionModified
+	}
+	return consumer + "-" + action
+}
+
+// cefSeverity maps a level to the 0 to 10 CEF severity
+func cefSeverity(level zerolog.Level) int {
+	switch level {
+	case zerolog.PanicLevel, zerolog.FatalLevel:
+		return 10
+	case zerolog.ErrorLevel:
+		return 8
+	case zerolog.WarnLevel:
+		return 6
+	case zerolog.InfoLevel:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// Encode method renders an alert as a CEF line, empty extensions are left out
+func (CEFEncoder) Encode(alert Alert) ([]byte, error) {
+	version := alert.Str("version")
+	if version == "" {
+		version = "unknown"
+	}
+	line := fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|",
+		cefHeaderEscaper.Replace(cefVendor), cefHeaderEscaper.Replace(cefProduct), cefHeaderEscaper.Replace(version),
+		cefHeaderEscaper.Replace(alert.eventAction()), cefHeaderEscaper.Replace(alert.Message), cefSeverity(alert.Level))
+
+	var extensions []string
+	add := func(key, value string) {
+		if value != "" {
+			extensions = append(extensions, key+"="+cefExtensionEscaper.Replace(value))
+		}
+	}
+	add("rt", fmt.Sprintf("%d", alert.Time.UnixNano()/int64(time.Millisecond)))
+	add("act", alert.Str("action"))
+	add("cat", alert.Str("consumer"))
+	if file := alert.Str("file"); file != "" {
+		add("filePath", file)
+		add("fname", path.Base(file))
+	}
+	// fileHash holds plain digests only, keyed ones can not be matched against known values and are labelled after their digest
+	algorithm, value := alert.hash()
+	keyed := value
+	if algorithm == generic.SHA256 {
+		add("fileHash", value)
+		keyed = ""
+	}
+	writer, _ := alert.processes()
+	add("sproc", writer.Comm)
+	if writer.ok {
+		add("spid", writer.PID.String())
+	}
+	add("suser", alert.Str("user"))
+	add("msg", strings.Join(alert.changes(), "; "))
+	for i, custom := range [][2]string{
+		{"loginUser", alert.Str("loginUser")},
+		{"processChain", alert.Str("processChain")},
+		{"commandLine", writer.Cmdline},
+		{"rule", alert.Str("rule")},
+		{"tag", alert.Str("tag")},
+		{algorithm, keyed},
+	} {
+		if custom[1] != "" {
+			add(fmt.Sprintf("cs%dLabel", i+1), custom[0])
+			add(fmt.Sprintf("cs%d", i+1), custom[1])
+		}
+	}
+	return []byte(line + strings.Join(extensions, " ")), nil
+}
+
+// set assigns a value at a dotted path of nested objects, i.e. file.hash.sha256
+func set(document map[string]interface{}, key string, value interface{}) {
+	if value == nil || value == "" {
+		return
+	}
+	keys := strings.Split(key, ".")
+	for _, parent := range keys[:len(keys)-1] {
+		child, ok := document[parent].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			document[parent] = child
+		}
+		document = child
+	}
+	document[keys[len(keys)-1]] = value
+}
+
+// ecsEventType maps an action to the ECS event.type
+func ecsEventType(action string) string {
+	switch action {
+	case ActionCreated:
+		return "creation"
+	case ActionDeleted:
+		return "deletion"
+	default:
+		return "change"
+	}
+}
+
+// Encode method renders an alert as an ECS document, the consumer payload is kept under bpfink
+func (ECSEncoder) Encode(alert Alert) ([]byte, error) {
+	document := map[string]interface{}{}
+	set(document, "@timestamp", alert.Time.UTC().Format(time.RFC3339Nano))
+	set(document, "message", alert.Message)
+	set(document, "log.level", alert.Level.String())
+	set(document, "ecs.version", ecsVersion)
+	set(document, "agent.type", cefProduct)
+	set(document, "agent.version", alert.Str("version"))
+	set(document, "event.kind", "alert")
+	set(document, "event.module", cefProduct)
+	set(document, "event.dataset", cefProduct+"."+alert.Str("consumer"))
+	set(document, "event.action", alert.eventAction())
+	set(document, "event.type", []string{ecsEventType(alert.Str("action"))})
+	category := []string{"file"}
+	switch alert.Str("consumer") {
+	case ConsumerUsers, ConsumerGroups, ConsumerSudoers, ConsumerAccess, ConsumerSSHD, ConsumerPAM:
+		category = append(category, "iam")
+	}
+	set(document, "event.category", category)
+
+	if file := alert.Str("file"); file != "" {
+		set(document, "file.path", file)
+		set(document, "file.name", path.Base(file))
+		set(document, "file.directory", path.Dir(file))
+	}
+	// ECS hashes are plain digests, keyed ones can not be matched against known values and are kept under bpfink
+	if algorithm, value := alert.hash(); algorithm == generic.SHA256 && value != "" {
+		set(document, "file.hash.sha256", value)
+	} else if algorithm != "" && value != "" {
+		set(document, "bpfink.hash."+strings.Replace(algorithm, "-", "_", -1), value)
+	}
+	if attributes, ok := alert.Fields["new"].(map[string]interface{}); ok {
+		set(document, "file.mode", attributes["mode"])
+		// ECS file.uid and file.gid are keywords
+		for _, id := range []string{"uid", "gid"} {
+			if value, ok := attributes[id].(json.Number); ok {
+				set(document, "file."+id, value.String())
+			}
+		}
+	}
+
+	writer, parent := alert.processes()
+	set(document, "process.command_line", writer.Cmdline)
+	set(document, "process.name", writer.Comm)
+	set(document, "process.executable", writer.Exe)
+	if writer.ok {
+		set(document, "process.pid", writer.PID)
+	}
+	if parent.ok {
+		set(document, "process.parent.name", parent.Comm)
+		set(document, "process.parent.executable", parent.Exe)
+		set(document, "process.parent.pid", parent.PID)
+	}
+	set(document, "user.name", alert.Str("user"))
+	set(document, "user.audit.name", alert.Str("loginUser"))
+	set(document, "rule.name", alert.Str("rule"))
+	if tag := alert.Str("tag"); tag != "" {
+		set(document, "tags", []string{tag})
+	}
+
+	payload := map[string]interface{}{}
+	for key, value := range alert.Fields {
+		if !originKeys[key] {
+			payload[key] = value
+		}
+	}
+	set(document, "bpfink.process_chain", alert.Str("processChain"))
+	set(document, "bpfink.exe_inode", alert.Fields["exeInode"])
+	for key, value := range payload {
+		set(document, "bpfink."+key, value)
+	}
+	return json.Marshal(document)
+}
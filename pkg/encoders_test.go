@@ -0,0 +1,152 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+// notifyGeneric logs the modification of a generic file to a sink rendering alerts with the encoder
+func notifyGeneric(encoder Encoder) []byte {
+	var output bytes.Buffer
+	sink := NewWriterSink(&output)
+	sink.Encoder = encoder
+	alerts := NewAlerts()
+	alerts.Add(sink, zerolog.DebugLevel)
+
+	logger := zerolog.New(ioutil.Discard).With().Str("version", "0.1|2").Logger()
+	state := &GenericState{
+		GenericListener: &GenericListener{Logger: logger, File: "/etc/hosts"},
+		current:         Generic{Contents: []byte{0x01}, Digest: "sha256"},
+		next:            Generic{Contents: []byte{0xab}, Digest: "sha256"},
+	}
+	state.Notify(Origin{
+		Process: "vi /etc/hosts", User: "root", LoginUser: "alice",
+		Ancestry: Ancestry{{PID: 42, Comm: "vi", Exe: "/usr/bin/vi", Cmdline: "vi /etc/hosts"}, {PID: 1, Comm: "bash"}},
+		Rule:     &Rule{Name: "edits", Action: RuleTag, Tag: "a=b"},
+		alerts:   alerts,
+	})
+	return bytes.TrimSuffix(output.Bytes(), []byte("\n"))
+}
+
+func TestNewEncoder(t *testing.T) {
+	for format, want := range map[string]Encoder{"": JSONEncoder{}, "json": JSONEncoder{}, "cef": CEFEncoder{}, "ecs": ECSEncoder{}} {
+		if encoder, err := NewEncoder(format); err != nil || encoder != want {
+			t.Errorf("format %q want %T, got %T (%v)", format, want, encoder, err)
+		}
+	}
+	if _, err := NewEncoder("leef"); err == nil {
+		t.Error("want an error for an unknown format")
+	}
+}
+
+func TestCEFEncoder(t *testing.T) {
+	line := string(notifyGeneric(CEFEncoder{}))
+	header := `CEF:0|Booking.com|bpfink|0.1\|2|generic-modified|generic file Modified|6|`
+	if !strings.HasPrefix(line, header) {
+		t.Fatalf("want header %q, got %q", header, line)
+	}
+	for _, want := range []string{
+		" act=modified ", " cat=generic ", " filePath=/etc/hosts ", " fname=hosts ", " fileHash=ab ", " sproc=vi ",
+		" spid=42 ", " suser=root ", " cs1Label=loginUser cs1=alice ", " cs2Label=processChain cs2=bash -> vi ",
+		" cs3Label=commandLine cs3=vi /etc/hosts ", " cs4Label=rule cs4=edits ", ` cs5Label=tag cs5=a\=b `,
+	} {
+		if !strings.Contains(line+" ", want) {
+			t.Errorf("want %q in %q", want, line)
+		}
+	}
+	if strings.Contains(line, "cs6") {
+		t.Errorf("want no keyed digest for sha256, got %q", line)
+	}
+}
+
+func TestCEFEncoderKeyedDigest(t *testing.T) {
+	alert, err := NewAlert([]byte(`{"level":"warn","message":"generic file Modified","consumer":"generic","file":"/etc/hosts",` +
+		`"generic":{"current":"01","next":"ab","digest":"hmac-sha256","keyId":"6d1f7c1f0ab8b3a2"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := CEFEncoder{}.Encode(alert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line := string(encoded); strings.Contains(line, "fileHash") || !strings.HasSuffix(line, " cs6Label=hmac-sha256 cs6=ab") {
+		t.Errorf("want a keyed digest in cs6 only, got %q", line)
+	}
+}
+
+func TestECSEncoder(t *testing.T) {
+	var document map[string]interface{}
+	if err := json.Unmarshal(notifyGeneric(ECSEncoder{}), &document); err != nil {
+		t.Fatal(err)
+	}
+	lookup := func(key string) interface{} {
+		var value interface{} = document
+		for _, name := range strings.Split(key, ".") {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			value = object[name]
+		}
+		return value
+	}
+	for key, want := range map[string]interface{}{
+		"message": "generic file Modified", "log.level": "warn", "event.kind": "alert", "event.module": "bpfink",
+		"event.dataset": "bpfink.generic", "event.action": "generic-modified", "file.path": "/etc/hosts",
+		"file.name": "hosts", "file.hash.sha256": "ab", "process.command_line": "vi /etc/hosts",
+		"process.name": "vi", "process.executable": "/usr/bin/vi", "process.pid": float64(42),
+		"process.parent.name": "bash", "process.parent.pid": float64(1), "user.name": "root",
+		"user.audit.name": "alice", "rule.name": "edits", "bpfink.generic.current": "01",
+		"bpfink.process_chain": "bash -> vi",
+	} {
+		if value := lookup(key); value != want {
+			t.Errorf("%s want %v, got %v", key, want, value)
+		}
+	}
+	if tags, ok := lookup("tags").([]interface{}); !ok || len(tags) != 1 || tags[0] != "a=b" {
+		t.Errorf("want the rule tag in tags, got %v", lookup("tags"))
+	}
+	if eventType, ok := lookup("event.type").([]interface{}); !ok || eventType[0] != "change" {
+		t.Errorf("want a change event type, got %v", lookup("event.type"))
+	}
+	if lookup("@timestamp") == nil || lookup("bpfink.file") != nil || lookup("bpfink.ancestry") != nil {
+		t.Errorf("want a timestamp and origin keys left out of bpfink, got %v", document)
+	}
+}
+
+func TestECSEncoderKeyedDigestAndAttributes(t *testing.T) {
+	alert, err := NewAlert([]byte(`{"level":"warn","message":"generic file Modified","consumer":"generic","file":"/etc/hosts",` +
+		`"generic":{"current":"01","next":"ab","digest":"hmac-sha256","keyId":"6d1f7c1f0ab8b3a2"},` +
+		`"new":{"mode":"0644","uid":4294967294,"gid":0}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := ECSEncoder{}.Encode(alert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document struct {
+		File struct {
+			Hash     map[string]interface{}
+			Mode     string
+			UID, GID interface{}
+		}
+		Bpfink struct {
+			Hash map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		t.Fatal(err)
+	}
+	if len(document.File.Hash) != 0 || document.Bpfink.Hash["hmac_sha256"] != "ab" {
+		t.Errorf("want a keyed digest under bpfink.hash only, got: %s", encoded)
+	}
+	if document.File.Mode != "0644" || document.File.UID != "4294967294" || document.File.GID != "0" {
+		t.Errorf("want file.uid and file.gid as strings, got: %s", encoded)
+	}
+}
@@ -1,14 +1,12 @@
 package pkg
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"os"
 	"path"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -41,6 +39,12 @@ type (
 		NewDevice uint64 // target file when renaming, 0 if doesn't exist
 		Com       string
 		Path      string
+		XAttr     string // name of the extended attribute on xattr changes
+		PPID      uint32
+		LoginUID  uint32
+		ExeInode  uint64
+		Ancestry  Ancestry
+		Rule      *Rule // first configured rule matching the writer, if any
 	}
 	rawEvent struct {
 		Mode      int32
@@ -53,17 +57,41 @@ type (
 		NewDevice uint64 // target file when renaming, 0 if doesn't exist
 		Com       [taskComLen]byte
 		Name      [dnameInlineLen]byte
+		PPID      uint32
+		LoginUID  uint32
+		ExeInode  uint64
+	}
+	// legacyRawEvent is the event of BPF objects built before the process fields were added to data_t,
+	// its samples are decoded rather than dropped until every shipped object is rebuilt
+	legacyRawEvent struct {
+		Mode      int32
+		PID       uint32
+		UID       uint32
+		Size      uint32
+		Inode     uint64
+		Device    uint64
+		NewInode  uint64
+		NewDevice uint64
+		Com       [taskComLen]byte
+		Name      [dnameInlineLen]byte
+	}
+	// fileMapping keeps track of the inode <-> file name relation of watched files
+	fileMapping struct {
+		mapping *sync.Map // map[uint64]string
+		reverse *sync.Map // map[string]uint64
 	}
 	// FIM struct that represents BPF event system
 	FIM struct {
-		mapping    *sync.Map
-		reverse    *sync.Map
+		fileMapping
 		Module     *elf.Module
 		RulesTable *elf.Map
 		resultsMap *elf.PerfMap
-		Events     chan Event
+		events     chan Event
+		lost       chan uint64
 		zerolog.Logger
 		closeChannelLoops chan struct{}
+		// AncestryDepth number of processes reported in the process chain of each event
+		AncestryDepth int
 	}
 )
 
@@ -76,7 +104,7 @@ func Encode(i interface{}) ([]byte, error) {
 }
 
 // InitFIM function to initialize and start BPF
-func InitFIM(bccFile string, logger zerolog.Logger) (*FIM, error) {
+func InitFIM(bccFile string, logger zerolog.Logger, options ...func(*FIM)) (*FIM, error) {
 	// 'rules' ebpf hashmap is stored as a special file at the /sys/fs/bpf/bpfink/globals/rules
 	// it turns out it is not cleaned up between different launches of a program, so it can lead
 	// to unexpected behaviour (some rules will be still present even if they are not relevant anymore)
@@ -126,29 +154,56 @@ func InitFIM(bccFile string, logger zerolog.Logger) (*FIM, error) {
 		return nil, err
 	}
 
-	err = mod.EnableKprobes(128)
-	if err != nil {
+	if err = enableKprobes(mod, bccFile, logger); err != nil {
 		logger.Error().Err(err).Msg("Error loading kprobes")
 		return nil, err
 	}
 
 	fim := &FIM{
-		mapping:           &sync.Map{},
-		reverse:           &sync.Map{},
+		fileMapping:       newFileMapping(),
 		Module:            mod,
 		RulesTable:        rulesTable,
-		Events:            make(chan Event, chanSize),
+		events:            make(chan Event, chanSize),
+		lost:              make(chan uint64, chanSize),
 		Logger:            logger,
 		closeChannelLoops: make(chan struct{}, 1),
 	}
+	for _, option := range options {
+		option(fim)
+	}
 
 	return fim, fim.start()
 }
 
+// enableKprobes attaches the probes of the module, the attribute probes are left out when they would read
+// the arguments of another kernel version
+func enableKprobes(mod *elf.Module, bccFile string, logger zerolog.Logger) error {
+	release, err := KernelRelease()
+	if err != nil || AttrProbesFit(bccFile, release) {
+		return mod.EnableKprobes(128)
+	}
+	logger.Warn().Str("object", bccFile).Str("kernel", release).Strs("probes", attrKprobes).
+		Msg("BPF object built for a kernel where attribute changes take other arguments, they are not reported, build it for the running kernel")
+	skip := Array2Set(attrKprobes)
+	for kprobe := range mod.IterKprobes() {
+		if _, ok := skip[kprobe.Name]; ok {
+			continue
+		}
+		if err := mod.EnableKprobe(kprobe.Name, 128); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newFileMapping() fileMapping {
+	return fileMapping{mapping: &sync.Map{}, reverse: &sync.Map{}}
+}
+
 // Stats method to print status of code
-func (f *FIM) Stats() string {
+func (fm fileMapping) Stats() string {
 	count := 0
-	f.mapping.Range(func(key, value interface{}) bool {
+	fm.mapping.Range(func(key, value interface{}) bool {
 		count++
 		return true
 	})
@@ -156,8 +211,52 @@ func (f *FIM) Stats() string {
 	return fmt.Sprintf("Currently watching %d files", count)
 }
 
-// StopBPF method to clean up bpf after running
-func (f *FIM) StopBPF() error {
+// MapInode method to record the file name of a given inode
+func (fm fileMapping) MapInode(key uint64, name string) {
+	fm.mapping.Store(key, name)
+	fm.reverse.Store(name, key)
+}
+
+// UnmapInode method to forget the file name of a given inode
+func (fm fileMapping) UnmapInode(key uint64) { fm.mapping.Delete(key) }
+
+// UnmapFile method to forget the inode of a given file name
+func (fm fileMapping) UnmapFile(name string) { fm.reverse.Delete(name) }
+
+// GetFileFromInode look up filename for given inode
+func (fm fileMapping) GetFileFromInode(key uint64) (string, error) {
+	rawName, ok := fm.mapping.Load(key)
+	if !ok {
+		return "", errors.New("error getting file name")
+	}
+	name, ok := rawName.(string)
+	if !ok {
+		return "", errors.New("error casting file name")
+	}
+	return name, nil
+}
+
+// getInodeFromFile look up inode for given filename
+func (fm fileMapping) getInodeFromFile(name string) (uint64, error) {
+	rawKey, ok := fm.reverse.Load(name)
+	if !ok {
+		return 0, errors.New("error getting key")
+	}
+	key, ok := rawKey.(uint64)
+	if !ok {
+		return 0, errors.New("error casting key")
+	}
+	return key, nil
+}
+
+// Events returns the channel on which BPF events are pushed
+func (f *FIM) Events() chan Event { return f.events }
+
+// Lost returns the channel on which the count of samples dropped by the perf buffer is pushed
+func (f *FIM) Lost() chan uint64 { return f.lost }
+
+// Stop method to clean up bpf after running
+func (f *FIM) Stop() error {
 	f.resultsMap.PollStop()
 	close(f.closeChannelLoops)
 	f.Debug().Msg("polling stopped")
@@ -203,8 +302,12 @@ func (f *FIM) start() error {
 				if !ok {
 					return
 				}
-				f.Debug().Msg("missed")
-				f.Error().Msgf("log message count: %v", missedCount)
+				f.Error().Uint64("count", missedCount).Msg("perf buffer lost events")
+				select {
+				case f.lost <- missedCount:
+				case <-f.closeChannelLoops:
+					return
+				}
 			case <-f.closeChannelLoops:
 				f.Debug().Msg("chan Closed")
 				return
@@ -216,30 +319,24 @@ func (f *FIM) start() error {
 			select {
 			case data := <-eventChannel:
 				f.Debug().Msg("event")
-				e := rawEvent{}
-				err := binary.Read(bytes.NewBuffer(data), binary.LittleEndian, &e)
+				e, legacy, err := decodeRawEvent(data)
 				if err != nil {
 					f.Error().Msgf("failed to decode received data %q: %v", data, err)
 					continue
 				}
+				if legacy {
+					// the object does not capture the parent, read it while the writer is still around
+					_, e.PPID, _ = readStat(e.PID)
+				}
 				spath := ""
 				f.Debug().Str("event", fmt.Sprint(e)).Msg("message from ebpf")
-				// Do not monitor events generated by puppet
-				if strings.HasPrefix(string(e.Com[:]), "puppet") {
-					f.Debug().Msgf("Skipping events generated by puppet")
-					continue
-				}
-				cmdline := f.getCMDLine(e)
-				comLen := 0
+				comm := nullTerminated(e.Com[:])
+				cmdline := readCmdline(e.PID)
+				f.Debug().Msgf("cmdline text: %v", cmdline)
 				if cmdline == "" {
-					for index, bit := range e.Com {
-						if bit == 0 {
-							comLen = index
-							break
-						}
-					}
-					cmdline = string(e.Com[:comLen])
+					cmdline = comm
 				}
+				ancestry := NewAncestry(Process{PID: e.PID, Comm: comm, Cmdline: cmdline}, e.PPID, f.AncestryDepth)
 				// When the user does something like mkdir -p multiple dir are create very quickly.
 				// The BPF program is added the new dir inode into the look up map. So that events are not missed.
 				// By introducing a very small sleep and retry logic, we allow for all bpf events to be received before
@@ -284,10 +381,26 @@ func (f *FIM) start() error {
 						f.Error().Msgf("could not assert path into string key: %v in map", e.Inode)
 					}
 				}
-				f.Events <- Event{
-					e.Mode, e.PID, e.UID, e.Size, e.Inode, e.Device, e.NewInode, e.NewDevice,
-					cmdline,
-					spath,
+				xattr := ""
+				if e.Mode == xattrChange {
+					xattr = nullTerminated(e.Name[:])
+				}
+				f.events <- Event{
+					Mode:      e.Mode,
+					PID:       e.PID,
+					UID:       e.UID,
+					Size:      e.Size,
+					Inode:     e.Inode,
+					Device:    e.Device,
+					NewInode:  e.NewInode,
+					NewDevice: e.NewDevice,
+					Com:       cmdline,
+					Path:      spath,
+					XAttr:     xattr,
+					PPID:      e.PPID,
+					LoginUID:  e.LoginUID,
+					ExeInode:  e.ExeInode,
+					Ancestry:  ancestry,
 				}
 			case <-f.closeChannelLoops:
 				f.Debug().Msg("chan Closed")
@@ -298,31 +411,29 @@ func (f *FIM) start() error {
 	return nil
 }
 
-func (f *FIM) getCMDLine(e rawEvent) string {
-	path := fmt.Sprintf("/proc/%v/cmdline", e.PID)
-	f.Debug().Msgf("cmdline path: %v", path)
-	file, err := os.Open(path)
-	if err != nil {
-		f.Debug().Msg("file does not exist")
-		return ""
+// decodeRawEvent decodes a BPF sample, samples too short for rawEvent come from an object built with the
+// legacy layout, they are decoded as such with the process fields left unknown
+func decodeRawEvent(data []byte) (e rawEvent, legacy bool, err error) {
+	if len(data) >= binary.Size(e) {
+		return e, false, binary.Read(bytes.NewReader(data), binary.LittleEndian, &e)
 	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			f.Error().Err(err)
-		}
-	}()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
+	old := legacyRawEvent{}
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &old); err != nil {
+		return e, true, err
+	}
+	return rawEvent{
+		Mode: old.Mode, PID: old.PID, UID: old.UID, Size: old.Size,
+		Inode: old.Inode, Device: old.Device, NewInode: old.NewInode, NewDevice: old.NewDevice,
+		Com: old.Com, Name: old.Name, LoginUID: UnknownUID,
+	}, true, nil
+}
 
-		if len(line) > 0 {
-			line = strings.ReplaceAll(line, "\u0000", " ")
-			f.Debug().Msgf("cmdline text: %v", line)
-			return line
-		}
+// nullTerminated converts a C string buffer from BPF into a string
+func nullTerminated(buf []byte) string {
+	if end := bytes.IndexByte(buf, 0); end != -1 {
+		buf = buf[:end]
 	}
-	return ""
+	return string(buf)
 }
 
 // AddFile method to add a new file to BPF monitor
@@ -339,22 +450,14 @@ func (f *FIM) AddFile(name string) error {
 	if err := f.Module.UpdateElement(f.RulesTable, pkey, pvalue, bpfAny); err != nil {
 		return err
 	}
-	f.mapping.Store(fstat.Ino, name)
-	f.reverse.Store(name, fstat.Ino)
+	f.MapInode(fstat.Ino, name)
 	return nil
 }
 
 // RemoveFile method to remove a file from BPF monitor
 func (f *FIM) RemoveFile(name string) error {
-	rawKey, ok := f.reverse.Load(name)
-	if !ok {
-		err := errors.New("error getting key")
-		f.Error().Err(err)
-		return err
-	}
-	uintKey, ok := rawKey.(uint64)
-	if !ok {
-		err := errors.New("error casting key")
+	uintKey, err := f.getInodeFromFile(name)
+	if err != nil {
 		f.Error().Err(err)
 		return err
 	}
@@ -366,27 +469,16 @@ func (f *FIM) RemoveFile(name string) error {
 		return err
 	}
 
-	id, ok := f.reverse.Load(name)
-	if !ok {
-		f.Error().Msgf("error loading ")
-	}
-	f.mapping.Delete(id)
+	f.mapping.Delete(uintKey)
 	f.reverse.Delete(name)
-	f.Debug().Msgf("map key: %v, with value: %v", id, name)
+	f.Debug().Msgf("map key: %v, with value: %v", uintKey, name)
 	return nil
 }
 
 // RemoveInode method to remove a file from BPF monitor
 func (f *FIM) RemoveInode(key uint64) (string, error) {
-	rawName, ok := f.mapping.Load(key)
-	if !ok {
-		err := errors.New("error getting file name")
-		f.Error().Err(err)
-		return "", err
-	}
-	name, ok := rawName.(string)
-	if !ok {
-		err := errors.New("error casting file name")
+	name, err := f.GetFileFromInode(key)
+	if err != nil {
 		f.Error().Err(err)
 		return "", err
 	}
@@ -404,20 +496,3 @@ func (f *FIM) RemoveInode(key uint64) (string, error) {
 	f.Debug().Msgf("map key: %v, with value: %v", key, name)
 	return name, nil
 }
-
-// GetFileFromInode look up filename for given inode
-func (f *FIM) GetFileFromInode(key uint64) (string, error) {
-	rawName, ok := f.mapping.Load(key)
-	if !ok {
-		err := errors.New("error getting file name")
-		f.Error().Err(err)
-		return "", err
-	}
-	name, ok := rawName.(string)
-	if !ok {
-		err := errors.New("error casting file name")
-		f.Error().Err(err)
-		return "", err
-	}
-	return name, nil
-}
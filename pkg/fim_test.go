@@ -0,0 +1,35 @@
+package pkg
+
+import (
+	"testing"
+)
+
+func TestDecodeRawEvent(t *testing.T) {
+	raw := rawEvent{Mode: writeEvent, PID: 42, UID: 1000, Inode: 7, Device: 8, PPID: 1, LoginUID: 1000, ExeInode: 9}
+	copy(raw.Com[:], "vi")
+	data, err := Encode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, legacy, err := decodeRawEvent(append(data, 0, 0, 0, 0))
+	if err != nil || legacy || e != raw {
+		t.Errorf("decodeRawEvent want: %v, got: %v (legacy %v, %v)", raw, e, legacy, err)
+	}
+
+	old := legacyRawEvent{Mode: writeEvent, PID: 42, UID: 1000, Inode: 7, Device: 8, Com: raw.Com}
+	data, err = Encode(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := rawEvent{Mode: writeEvent, PID: 42, UID: 1000, Inode: 7, Device: 8, Com: raw.Com, LoginUID: UnknownUID}
+	// samples are padded to 8 bytes by the perf buffer
+	for _, sample := range [][]byte{data, append(data, 0, 0, 0, 0)} {
+		e, legacy, err = decodeRawEvent(sample)
+		if err != nil || !legacy || e != want {
+			t.Errorf("decodeRawEvent of a legacy sample want: %v, got: %v (legacy %v, %v)", want, e, legacy, err)
+		}
+	}
+	if _, _, err := decodeRawEvent(data[:len(data)/2]); err == nil {
+		t.Error("decodeRawEvent of a truncated sample want an error")
+	}
+}
@@ -11,14 +11,17 @@ type (
 	// Generic struct used to store changes to generic files
 	Generic struct {
 		Contents []byte
+		Digest   string
+		KeyID    string
 	}
 	// GenericListener struct used for filestream events.
 	GenericListener struct {
 		zerolog.Logger
 		afero.Fs
-		File  string
-		IsDir bool
-		Key   []byte
+		File   string
+		IsDir  bool
+		Key    []byte
+		Digest string
 	}
 	genericListener struct {
 		Generic
@@ -56,20 +59,25 @@ func (gl *GenericListener) parse() (Generic, error) {
 		return Generic{}, nil
 	}
 	gl.Debug().Msgf("parsing generic: %v", gl.File)
-	err := listener.genericParse(gl.File, gl.Key)
+	err := listener.genericParse(gl.File, gl.Key, gl.Digest)
 	if err != nil {
 		return Generic{}, err
 	}
 	return listener.Generic, nil
 }
 
-func (gl *genericListener) genericParse(fileName string, key []byte) error {
-	genericData := generic.Parser{FileName: fileName, Logger: gl.Logger, Key: key}
+func (gl *genericListener) genericParse(fileName string, key []byte, digest string) error {
+	genericData := generic.Parser{FileName: fileName, Logger: gl.Logger, Key: key, Digest: digest}
 	err := genericData.Parse()
 	if err != nil {
 		return err
 	}
 	gl.Generic.Contents = genericData.Hash
+	gl.Generic.Digest = digest
+	if gl.Generic.Digest == "" {
+		gl.Generic.Digest = generic.Blake2b
+	}
+	gl.Generic.KeyID = generic.KeyID(gl.Generic.Digest, key)
 	return nil
 }
 
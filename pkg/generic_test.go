@@ -0,0 +1,159 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bookingcom/bpfink/pkg/lang/generic"
+	"github.com/rs/zerolog"
+)
+
+func TestGenericDigests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_generic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(file, []byte("127.0.0.1 localhost\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	db, closeDB := openTestDB(t)
+	defer closeDB()
+	key, err := db.Key(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the key is persisted, later runs hash with the same key
+	if again, err := db.Key(32); err != nil || !bytes.Equal(key, again) {
+		t.Fatalf("Key want the persisted key, got: %x, %v", again, err)
+	}
+	other := bytes.Repeat([]byte{1}, 32)
+	parse := func(digest string, key []byte) Generic {
+		g, err := NewGenericListener(func(l *GenericListener) { l.File, l.Digest, l.Key = file, digest, key }).parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+
+	for _, digest := range []string{"", generic.Blake2b, generic.HMACSHA256, generic.SHA256} {
+		first, second, rekeyed := parse(digest, key), parse(digest, key), parse(digest, other)
+		if !bytes.Equal(first.Contents, second.Contents) || first.Digest != second.Digest || first.KeyID != second.KeyID {
+			t.Errorf("%q want the same digest across runs, got: %+v and %+v", digest, first, second)
+		}
+		if digest == generic.SHA256 {
+			sum := sha256.Sum256([]byte("127.0.0.1 localhost\n"))
+			if !bytes.Equal(first.Contents, sum[:]) || first.KeyID != "" || !bytes.Equal(rekeyed.Contents, first.Contents) {
+				t.Errorf("%q want a plain digest without key, got: %+v and %+v", digest, first, rekeyed)
+			}
+			continue
+		}
+		if bytes.Equal(first.Contents, rekeyed.Contents) || first.KeyID == rekeyed.KeyID {
+			t.Errorf("%q want another digest and key id with another key, got: %+v and %+v", digest, first, rekeyed)
+		}
+	}
+	if parse("", key).Digest != generic.Blake2b {
+		t.Error("want blake2b as default digest")
+	}
+}
+
+func TestNewHash(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	for _, digest := range []string{"", generic.Blake2b, generic.HMACSHA256, generic.SHA256} {
+		hash, err := generic.NewHash(digest, key)
+		if err != nil {
+			t.Fatalf("NewHash(%q) want no error, got: %v", digest, err)
+		}
+		if hash.Size() != sha256.Size {
+			t.Errorf("NewHash(%q) want a %d bytes digest, got: %d", digest, sha256.Size, hash.Size())
+		}
+	}
+	if _, err := generic.NewHash("md5", key); err == nil {
+		t.Error("NewHash want an error for an unknown digest")
+	}
+	// blake2b keys are 64 bytes at most
+	if _, err := generic.NewHash(generic.Blake2b, make([]byte, 65)); err == nil {
+		t.Error("NewHash want an error for a blake2b key too long")
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	id := generic.KeyID(generic.Blake2b, key)
+	if len(id) != 16 || id != generic.KeyID(generic.HMACSHA256, key) {
+		t.Errorf("KeyID want 8 hex encoded bytes, the same for every keyed digest, got: %q", id)
+	}
+	if id == generic.KeyID(generic.Blake2b, key[1:]) {
+		t.Errorf("KeyID want another identifier for another key, got: %q", id)
+	}
+	if id := generic.KeyID(generic.SHA256, key); id != "" {
+		t.Errorf("KeyID want no identifier for an unkeyed digest, got: %q", id)
+	}
+}
+
+func TestGenericRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_generic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(file, []byte("a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	db, closeDB := openTestDB(t)
+	defer closeDB()
+
+	var output bytes.Buffer
+	run := func(digest string, key []byte) {
+		output.Reset()
+		consumer := &BaseConsumer{AgentDB: db, ParserLoader: &GenericState{
+			GenericListener: NewGenericListener(func(l *GenericListener) {
+				l.File, l.Digest, l.Key, l.Logger = file, digest, key, zerolog.New(&output).Level(zerolog.WarnLevel)
+			}),
+		}}
+		if err := consumer.Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	type change struct {
+		Level, Message, Old, New string
+		Unverifiable             bool
+	}
+	decode := func() (c change) {
+		if err := json.Unmarshal(output.Bytes(), &c); err != nil {
+			t.Fatalf("want one change, got: %q", output.String())
+		}
+		return
+	}
+
+	run(generic.Blake2b, bytes.Repeat([]byte{1}, 32))
+	run(generic.Blake2b, bytes.Repeat([]byte{2}, 32))
+	oldID, newID := generic.KeyID(generic.Blake2b, bytes.Repeat([]byte{1}, 32)), generic.KeyID(generic.Blake2b, bytes.Repeat([]byte{2}, 32))
+	if c := decode(); c.Level != "warn" || !c.Unverifiable || c.Old != "blake2b/"+oldID || c.New != "blake2b/"+newID {
+		t.Errorf("want a warning the file can not be verified after a key change, got: %+v", c)
+	}
+	run(generic.SHA256, nil)
+	if c := decode(); !c.Unverifiable || c.Old != "blake2b/"+newID || c.New != "sha256/" {
+		t.Errorf("want a warning the file can not be verified after a digest change, got: %+v", c)
+	}
+
+	run(generic.SHA256, nil)
+	if output.Len() != 0 {
+		t.Errorf("want no change once the new digest is stored, got: %q", output.String())
+	}
+	if err := ioutil.WriteFile(file, []byte("b"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	run(generic.SHA256, nil)
+	if c := decode(); c.Unverifiable || !strings.HasPrefix(c.Message, "generic file Modified") {
+		t.Errorf("want a verified change with the new digest, got: %+v", c)
+	}
+}
@@ -28,6 +28,7 @@ type Metrics struct {
 	Hostname            string
 	RoleName            string
 	Logger              zerolog.Logger
+	Prometheus          *Prometheus // same metrics as labelled series, when set
 	mux                 sync.Mutex
 	missedCount         map[string]int64
 	hitCount            map[string]int64
@@ -48,6 +49,20 @@ const (
 	provbeVfsRmDir  = "pvfs_rmdir"
 	pdonePathCreate = "pdone_path_create"
 	pdoDentryOpen   = "pdo_dentry_open"
+	pnotifyChange   = "pnotify_change"
+	pvfsSetXattr    = "pvfs_setxattr"
+	pvfsRemoveXattr = "pvfs_removexattr"
+
+	// MetricParseErrors counts consumers failing to parse their files
+	MetricParseErrors = "parse_errors"
+	// MetricChanges counts changes found by consumers
+	MetricChanges = "changes"
+	// MetricReloads counts consumers reloaded as their set of files changed
+	MetricReloads = "reloads"
+	// MetricSaveFailures counts consumer states failing to be saved
+	MetricSaveFailures = "save_failures"
+	// MetricFileMissingPolls counts polls for watched files which do not exist
+	MetricFileMissingPolls = "file_missing_polls"
 )
 
 var defaultRolename = "unknown_role" // nolint:gochecknoglobals
@@ -84,6 +99,7 @@ func (m *Metrics) RecordByLogTypes(logType string) {
 	}
 	metricName := fmt.Sprintf("log_level.%s.by_role.%s.%s.count.minutely", logType, quote(defaultRolename), quote(m.Hostname))
 	goMetrics.GetOrRegisterCounter(metricName, m.EveryMinuteRegister).Inc(1)
+	m.Prometheus.Add("bpfink_log_messages_total", 1, m.labels("level", logType)...)
 }
 
 // RecordByEventsCaught sends count of number of events caught by ebpf
@@ -94,6 +110,116 @@ func (m *Metrics) RecordByEventsCaught() {
 	}
 	metricName := fmt.Sprintf("bpf.events_caught.by_role.%s.%s.count.minutely", quote(defaultRolename), quote(m.Hostname))
 	goMetrics.GetOrRegisterCounter(metricName, m.EveryMinuteRegister).Inc(1)
+	m.Prometheus.Add("bpfink_events_caught_total", 1, m.labels()...)
+}
+
+// RecordByEventsLost sends count of events dropped before reaching bpfink
+func (m *Metrics) RecordByEventsLost(count uint64) {
+	// If rolename is not empty, override the defaultRolename
+	if m.RoleName != "" {
+		defaultRolename = m.RoleName
+	}
+	metricName := fmt.Sprintf("bpf.events_lost.by_role.%s.%s.count.minutely", quote(defaultRolename), quote(m.Hostname))
+	goMetrics.GetOrRegisterCounter(metricName, m.EveryMinuteRegister).Inc(int64(count))
+	m.Prometheus.Add("bpfink_events_lost_total", float64(count), m.labels()...)
+}
+
+// RecordBySuppressedEvents sends count of events matching a rule, by rule action
+func (m *Metrics) RecordBySuppressedEvents(action string) {
+	// If rolename is not empty, override the defaultRolename
+	if m.RoleName != "" {
+		defaultRolename = m.RoleName
+	}
+	metricName := fmt.Sprintf("bpf.events_suppressed.%s.by_role.%s.%s.count.minutely", quote(action), quote(defaultRolename), quote(m.Hostname))
+	goMetrics.GetOrRegisterCounter(metricName, m.EveryMinuteRegister).Inc(1)
+	m.Prometheus.Add("bpfink_events_suppressed_total", 1, m.labels("action", action)...)
+}
+
+// RecordWebhookQueueDepth sends the number of alerts waiting to be delivered to a webhook, by webhook
+func (m *Metrics) RecordWebhookQueueDepth(webhook string, depth int) {
+	// If rolename is not empty, override the defaultRolename
+	if m.RoleName != "" {
+		defaultRolename = m.RoleName
+	}
+	metricName := fmt.Sprintf("webhook.queue_depth.%s.by_role.%s.%s.minutely", quote(webhook), quote(defaultRolename), quote(m.Hostname))
+	goMetrics.GetOrRegisterGauge(metricName, m.EveryMinuteRegister).Update(int64(depth))
+	m.Prometheus.Set("bpfink_webhook_queue_depth", float64(depth), m.labels("webhook", webhook)...)
+}
+
+// RecordWebhookFailures sends count of failed webhook deliveries, by webhook
+func (m *Metrics) RecordWebhookFailures(webhook string) {
+	// If rolename is not empty, override the defaultRolename
+	if m.RoleName != "" {
+		defaultRolename = m.RoleName
+	}
+	metricName := fmt.Sprintf("webhook.delivery_failures.%s.by_role.%s.%s.count.minutely", quote(webhook), quote(defaultRolename), quote(m.Hostname))
+	goMetrics.GetOrRegisterCounter(metricName, m.EveryMinuteRegister).Inc(1)
+	m.Prometheus.Add("bpfink_webhook_delivery_failures_total", 1, m.labels("webhook", webhook)...)
+}
+
+// RecordWebhookDelivered sends count of alerts delivered to a webhook, by webhook
+func (m *Metrics) RecordWebhookDelivered(webhook string, count int) {
+	// If rolename is not empty, override the defaultRolename
+	if m.RoleName != "" {
+		defaultRolename = m.RoleName
+	}
+	metricName := fmt.Sprintf("webhook.delivered.%s.by_role.%s.%s.count.minutely", quote(webhook), quote(defaultRolename), quote(m.Hostname))
+	goMetrics.GetOrRegisterCounter(metricName, m.EveryMinuteRegister).Inc(int64(count))
+	m.Prometheus.Add("bpfink_webhook_delivered_total", float64(count), m.labels("webhook", webhook)...)
+}
+
+// RecordWebhookDropped sends count of alerts dropped, undeliverable or sent while the queue was full, by webhook
+func (m *Metrics) RecordWebhookDropped(webhook string, count int) {
+	// If rolename is not empty, override the defaultRolename
+	if m.RoleName != "" {
+		defaultRolename = m.RoleName
+	}
+	metricName := fmt.Sprintf("webhook.dropped.%s.by_role.%s.%s.count.minutely", quote(webhook), quote(defaultRolename), quote(m.Hostname))
+	goMetrics.GetOrRegisterCounter(metricName, m.EveryMinuteRegister).Inc(int64(count))
+	m.Prometheus.Add("bpfink_webhook_dropped_total", float64(count), m.labels("webhook", webhook)...)
+}
+
+// RecordConsumeLatency sends the time taken to consume an event, by consumer and path class.
+// Like the other consumer metrics, it is a no-op without metrics.
+func (m *Metrics) RecordConsumeLatency(consumer, class string, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	// If rolename is not empty, override the defaultRolename
+	if m.RoleName != "" {
+		defaultRolename = m.RoleName
+	}
+	metricName := fmt.Sprintf("consumer.consume_latency.%s.%s.by_role.%s.%s.minutely", quote(consumer), quote(class), quote(defaultRolename), quote(m.Hostname))
+	goMetrics.GetOrRegisterTimer(metricName, m.EveryMinuteRegister).Update(latency)
+	m.Prometheus.Observe("bpfink_consumer_consume_seconds", latency.Seconds(), m.labels("consumer", consumer, "class", class)...)
+}
+
+// RecordConsumer sends count of a consumer metric, i.e. MetricParseErrors, by consumer and path class
+func (m *Metrics) RecordConsumer(metric, consumer, class string) {
+	if m == nil {
+		return
+	}
+	// If rolename is not empty, override the defaultRolename
+	if m.RoleName != "" {
+		defaultRolename = m.RoleName
+	}
+	metricName := fmt.Sprintf("consumer.%s.%s.%s.by_role.%s.%s.count.minutely", metric, quote(consumer), quote(class), quote(defaultRolename), quote(m.Hostname))
+	goMetrics.GetOrRegisterCounter(metricName, m.EveryMinuteRegister).Inc(1)
+	m.Prometheus.Add("bpfink_consumer_"+metric+"_total", 1, m.labels("consumer", consumer, "class", class)...)
+}
+
+// RecordConsumersInFlight sends the number of events being consumed, by consumer
+func (m *Metrics) RecordConsumersInFlight(consumer string, delta int64) {
+	if m == nil {
+		return
+	}
+	// If rolename is not empty, override the defaultRolename
+	if m.RoleName != "" {
+		defaultRolename = m.RoleName
+	}
+	metricName := fmt.Sprintf("consumer.in_flight.%s.by_role.%s.%s.minutely", quote(consumer), quote(defaultRolename), quote(m.Hostname))
+	goMetrics.GetOrRegisterCounter(metricName, m.EveryMinuteRegister).Inc(delta)
+	m.Prometheus.Add("bpfink_consumer_in_flight", float64(delta), m.labels("consumer", consumer)...)
 }
 
 // RecordVersion graphite metric to show the version of bpfink running on each host
@@ -106,6 +232,7 @@ func (m *Metrics) RecordVersion(version string) {
 	versionInt, _ := strconv.ParseInt(strings.Replace(version, ".", "", -1), 10, 64)
 	metricName := fmt.Sprintf("installed.by_role.%s.%s.version.hourly", quote(defaultRolename), quote(m.Hostname))
 	goMetrics.GetOrRegisterGauge(metricName, m.EveryHourRegister).Update(versionInt)
+	m.Prometheus.Set("bpfink_version_info", 1, m.labels("version", version)...)
 }
 
 // RecordByInstalledHost graphite metric to show how manay host have bpfink installed
@@ -116,6 +243,18 @@ func (m *Metrics) RecordByInstalledHost() {
 	}
 	metricName := fmt.Sprintf("installed.by_role.%s.%s.count.hourly", quote(defaultRolename), quote(m.Hostname))
 	goMetrics.GetOrRegisterGauge(metricName, m.EveryHourRegister).Update(int64(1))
+	m.Prometheus.Set("bpfink_installed", 1, m.labels()...)
+}
+
+// RecordBPFObject graphite metric to show which BPF object is loaded on each host
+func (m *Metrics) RecordBPFObject(object string) {
+	// If rolename is not empty, override the defaultRolename
+	if m.RoleName != "" {
+		defaultRolename = m.RoleName
+	}
+	metricName := fmt.Sprintf("installed.by_role.%s.%s.bpf_object.%s.hourly", quote(defaultRolename), quote(m.Hostname), quote(object))
+	goMetrics.GetOrRegisterGauge(metricName, m.EveryHourRegister).Update(int64(1))
+	m.Prometheus.Set("bpfink_bpf_object_info", 1, m.labels("object", object)...)
 }
 
 // RecordBPFMetrics send metrics for BPF hits and misses per probe
@@ -135,6 +274,8 @@ func (m *Metrics) RecordBPFMetrics() {
 				vfsMiss := fmt.Sprintf("bpf.by_role.%s.%s.%s.kprobe.miss_rate.minutely", quote(defaultRolename), quote(m.Hostname), key)
 				goMetrics.GetOrRegisterGaugeFloat64(vfsHit, m.EveryMinuteRegister).Update(BPFMetrics[key].hitRate)
 				goMetrics.GetOrRegisterGaugeFloat64(vfsMiss, m.EveryMinuteRegister).Update(BPFMetrics[key].missedRate)
+				m.Prometheus.Set("bpfink_kprobe_hit_ratio", BPFMetrics[key].hitRate, m.labels("probe", key)...)
+				m.Prometheus.Set("bpfink_kprobe_miss_ratio", BPFMetrics[key].missedRate, m.labels("probe", key)...)
 			}
 		}
 	}()
@@ -153,57 +294,24 @@ func (m *Metrics) fetchBPFMetrics() (map[string]bpfMetrics, error) {
 		}
 	}()
 
+	probes := []string{
+		provbeVfsWrite, provbeVfsRename, provbeVfsUnlink, provbeVfsRmDir, pdonePathCreate, pdoDentryOpen,
+		pnotifyChange, pvfsSetXattr, pvfsRemoveXattr,
+	}
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
 		tokens := strings.Fields(line)
 
-		if strings.Contains(tokens[0], provbeVfsWrite) {
-			bpfMetric, err := m.parseBPFLine(tokens, provbeVfsWrite)
-			if err != nil {
-				return nil, err
+		for _, probe := range probes {
+			if !strings.Contains(tokens[0], probe) {
+				continue
 			}
-			BPFMetrics[provbeVfsWrite] = *bpfMetric
-		}
-
-		if strings.Contains(tokens[0], provbeVfsRename) {
-			bpfMetric, err := m.parseBPFLine(tokens, provbeVfsRename)
-			if err != nil {
-				return nil, err
-			}
-			BPFMetrics[provbeVfsRename] = *bpfMetric
-		}
-
-		if strings.Contains(tokens[0], provbeVfsUnlink) {
-			bpfMetric, err := m.parseBPFLine(tokens, provbeVfsUnlink)
+			bpfMetric, err := m.parseBPFLine(tokens, probe)
 			if err != nil {
 				return nil, err
 			}
-			BPFMetrics[provbeVfsUnlink] = *bpfMetric
-		}
-
-		if strings.Contains(tokens[0], provbeVfsRmDir) {
-			bpfMetric, err := m.parseBPFLine(tokens, provbeVfsRmDir)
-			if err != nil {
-				return nil, err
-			}
-			BPFMetrics[provbeVfsRmDir] = *bpfMetric
-		}
-
-		if strings.Contains(tokens[0], pdonePathCreate) {
-			bpfMetric, err := m.parseBPFLine(tokens, pdonePathCreate)
-			if err != nil {
-				return nil, err
-			}
-			BPFMetrics[pdonePathCreate] = *bpfMetric
-		}
-
-		if strings.Contains(tokens[0], pdoDentryOpen) {
-			bpfMetric, err := m.parseBPFLine(tokens, pdoDentryOpen)
-			if err != nil {
-				return nil, err
-			}
-			BPFMetrics[pdoDentryOpen] = *bpfMetric
+			BPFMetrics[probe] = *bpfMetric
 		}
 	}
 
@@ -251,6 +359,11 @@ func (m *Metrics) parseBPFLine(tokens []string, probeName string) (*bpfMetrics,
 	}, nil
 }
 
+// labels returns the role and host labels of the Prometheus series followed by the given pairs
+func (m *Metrics) labels(pairs ...string) []string {
+	return append([]string{"role", defaultRolename, "host", m.Hostname}, pairs...)
+}
+
 func quote(str string) string {
 	underscorePrecedes := false
 	quotedString := strings.Map(func(r rune) rune {
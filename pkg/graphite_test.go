@@ -1,7 +1,10 @@
 package pkg
 
 import (
+	"io/ioutil"
 	"math"
+	"os"
+	"path/filepath"
 	"testing"
 
 	goMetrics "github.com/rcrowley/go-metrics"
@@ -59,6 +62,71 @@ func TestVersionMetric(t *testing.T) {
 	})
 }
 
+func TestBPFObjectMetric(t *testing.T) {
+	m := InitMetrics()
+	defer m.EveryHourRegister.UnregisterAll()
+	m.RecordBPFObject("vfs-4.19.o")
+
+	testIfMetricsAreExpected(t, m.EveryHourRegister, map[string]float64{
+		"security.piv.bpfink.installed.by_role.unknown_role.test_host.bpf_object.vfs-4_19_o.hourly": 1,
+	})
+}
+
+func TestConsumerMetrics(t *testing.T) {
+	m := InitMetrics()
+	defer m.EveryMinuteRegister.UnregisterAll()
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+	dir, err := ioutil.TempDir("", "bpfink_consumer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(file, []byte("a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	consumer := &BaseConsumer{AgentDB: db, Metrics: &m, ParserLoader: &GenericState{
+		GenericListener: NewGenericListener(func(l *GenericListener) { l.File, l.Digest = file, "sha256" }),
+	}}
+	if err := consumer.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte("b"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := consumer.Consume(Event{Path: file, Mode: writeEvent, UID: UnknownUID, LoginUID: UnknownUID}); err != nil {
+		t.Fatal(err)
+	}
+	// a directory in place of the file can not be parsed
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(file, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := consumer.Consume(Event{Path: file, Mode: writeEvent, UID: UnknownUID, LoginUID: UnknownUID}); err == nil {
+		t.Error("want a parse error")
+	}
+	m.RecordConsumersInFlight(ConsumerGeneric, 1)
+	m.RecordConsumersInFlight(ConsumerGeneric, 1)
+	m.RecordConsumersInFlight(ConsumerGeneric, -1)
+
+	class := PathClass(dir)
+	testIfMetricsAreExpected(t, m.EveryMinuteRegister, map[string]float64{
+		"security.piv.bpfink.consumer.consume_latency.generic." + class + ".by_role.unknown_role.test_host.minutely":    2,
+		"security.piv.bpfink.consumer.changes.generic." + class + ".by_role.unknown_role.test_host.count.minutely":      1,
+		"security.piv.bpfink.consumer.parse_errors.generic." + class + ".by_role.unknown_role.test_host.count.minutely": 1,
+		"security.piv.bpfink.consumer.in_flight.generic.by_role.unknown_role.test_host.minutely":                        1,
+	})
+	for path, want := range map[string]string{"/etc/ssh/sshd_config": "etc", "/root/.bashrc": "home", "/home/a/.ssh": "home", "/": "root"} {
+		if class := PathClass(path); class != want {
+			t.Errorf("PathClass(%s) want %s, got %s", path, want, class)
+		}
+	}
+}
+
 func testIfMetricsAreExpected(t *testing.T, registry goMetrics.Registry, expectedMetrics map[string]float64) {
 	actualMetrics := registry.GetAll()
 	if len(expectedMetrics) != len(actualMetrics) {
@@ -77,6 +145,8 @@ func testIfMetricsAreExpected(t *testing.T, registry goMetrics.Registry, expecte
 				metricValueFloat64 = metric.Value()
 			case goMetrics.Counter:
 				metricValueFloat64 = float64(metric.Count())
+			case goMetrics.Timer:
+				metricValueFloat64 = float64(metric.Count())
 			default:
 				t.Fatalf("%s has unexpected type: %T", metricName, metric)
 			}
@@ -0,0 +1,169 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/afero"
+
+	"github.com/bookingcom/bpfink/pkg/lang/group"
+	"github.com/bookingcom/bpfink/pkg/lang/gshadow"
+)
+
+type (
+	// Group struct representing a group, members listed in group or gshadow are merged
+	Group struct {
+		Name     string
+		GID      string
+		Password string
+		Members  []string
+		Admins   []string
+	}
+
+	// Groups map of group objects
+	Groups map[string]*Group
+
+	// GroupsListener struct of listener for groups
+	GroupsListener struct {
+		afero.Fs
+		Group, GShadow string
+		// Privileged groups grant root or root equivalent access, i.e. wheel, sudo or docker
+		Privileged []string
+		zerolog.Logger
+	}
+)
+
+// Equal method to compare two groups
+func (g1 *Group) Equal(g2 *Group) bool {
+	return g1.Name == g2.Name && g1.GID == g2.GID && g1.Password == g2.Password &&
+		ArrayEqual(g1.Members, g2.Members) && ArrayEqual(g1.Admins, g2.Admins)
+}
+
+func groupDiff(old, new Groups) (add, del Groups) {
+	add, del = Groups{}, Groups{}
+	for k, v := range new {
+		add[k] = v
+	}
+	for k, v := range old {
+		del[k] = v
+	}
+	for k, v1 := range add {
+		if v2, ok := del[k]; ok && v1.Equal(v2) {
+			delete(add, k)
+			delete(del, k)
+		}
+	}
+	return
+}
+
+// groupChanges describes a diff in plain words, the privileged groups it touches are returned as well
+func (gl *GroupsListener) groupChanges(add, del Groups) (changes, privileged []string) {
+	touched := Set{}
+	for name, next := range add {
+		touched.Push(name)
+		current, ok := del[name]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("group %s added with gid %s", name, next.GID))
+			for _, member := range next.Members {
+				changes = append(changes, fmt.Sprintf("%s added to group %s", member, name))
+			}
+			continue
+		}
+		if current.GID != next.GID {
+			changes = append(changes, fmt.Sprintf("group %s gid changed from %s to %s", name, current.GID, next.GID))
+		}
+		if current.Password != next.Password {
+			changes = append(changes, fmt.Sprintf("group %s password changed", name))
+		}
+		added, removed := ArrayDiff(current.Members, next.Members)
+		for _, member := range added {
+			changes = append(changes, fmt.Sprintf("%s added to group %s", member, name))
+		}
+		for _, member := range removed {
+			changes = append(changes, fmt.Sprintf("%s removed from group %s", member, name))
+		}
+		added, removed = ArrayDiff(current.Admins, next.Admins)
+		for _, admin := range added {
+			changes = append(changes, fmt.Sprintf("%s became administrator of group %s", admin, name))
+		}
+		for _, admin := range removed {
+			changes = append(changes, fmt.Sprintf("%s is no longer administrator of group %s", admin, name))
+		}
+	}
+	for name := range del {
+		if _, ok := add[name]; !ok {
+			touched.Push(name)
+			changes = append(changes, fmt.Sprintf("group %s removed", name))
+		}
+	}
+	for _, name := range gl.Privileged {
+		if _, ok := touched[name]; ok {
+			privileged = append(privileged, name)
+		}
+	}
+	sort.Strings(changes)
+	sort.Strings(privileged)
+	return changes, privileged
+}
+
+// NewGroupsListener new function to create group listener
+func NewGroupsListener(options ...func(*GroupsListener)) *GroupsListener {
+	gl := &GroupsListener{Logger: zerolog.Nop()}
+	for _, option := range options {
+		option(gl)
+	}
+	return gl
+}
+
+func (gl *GroupsListener) parse() (Groups, error) {
+	gl.Debug().Msg("parsing group file")
+	groupData := group.Parser{FileName: gl.Group, Logger: gl.Logger}
+	if err := groupData.Parse(); err != nil {
+		return nil, err
+	}
+	groups := Groups{}
+	for _, entry := range groupData.Groups {
+		groups[entry.Name] = &Group{Name: entry.Name, GID: entry.GID, Members: entry.Members}
+	}
+	if gl.GShadow == "" {
+		return groups, nil
+	}
+
+	gl.Debug().Msg("parsing gshadow file")
+	gshadowData := gshadow.Parser{FileName: gl.GShadow, Logger: gl.Logger}
+	if err := gshadowData.Parse(); err != nil {
+		return nil, err
+	}
+	for _, entry := range gshadowData.Groups {
+		existing, ok := groups[entry.Name]
+		if !ok {
+			gl.Warn().Str("group", entry.Name).Msg("group listed in gshadow only")
+			continue
+		}
+		switch entry.Password {
+		case "!!", "*", "!", "":
+		default:
+			existing.Password = MaskLeft(entry.Password)
+		}
+		existing.Members = ArrayClean(append(existing.Members, entry.Members...))
+		existing.Admins = entry.Admins
+	}
+	return groups, nil
+}
+
+// Register method returns list of paths to files to be watched
+func (gl *GroupsListener) Register() (out []string) {
+	files := []string{gl.Group}
+	if gl.GShadow != "" {
+		files = append(files, gl.GShadow)
+	}
+	if base, ok := gl.Fs.(*afero.BasePathFs); ok {
+		for _, file := range files {
+			rpath, _ := base.RealPath(file)
+			out = append(out, rpath)
+		}
+		return out
+	}
+	return files
+}
@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGroupChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	groupFile, gshadowFile := filepath.Join(dir, "group"), filepath.Join(dir, "gshadow")
+	write := func(file, content string) {
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	listener := NewGroupsListener(func(l *GroupsListener) {
+		l.Group, l.GShadow, l.Privileged = groupFile, gshadowFile, []string{"wheel", "docker"}
+	})
+
+	write(groupFile, "root:x:0:\nwheel:x:10:alice\nusers:x:100:alice,bob\nstaff:x:50:\n")
+	write(gshadowFile, "root:*::\nwheel:!::alice\nusers:!::alice,bob\nstaff:!::\n")
+	old, err := listener.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(groupFile, "root:x:0:\nwheel:x:10:alice,mallory\nusers:x:101:alice\ndocker:x:998:mallory\n")
+	write(gshadowFile, "root:*::\nwheel:!:mallory:alice\nusers:!::alice\ndocker:!::mallory\n")
+	new, err := listener.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, privileged := listener.groupChanges(groupDiff(old, new))
+	want := []string{
+		"bob removed from group users",
+		"group docker added with gid 998",
+		"group staff removed",
+		"group users gid changed from 100 to 101",
+		"mallory added to group docker",
+		"mallory added to group wheel",
+		"mallory became administrator of group wheel",
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("groupChanges want: %q, got: %q", want, changes)
+	}
+	if !reflect.DeepEqual(privileged, []string{"docker", "wheel"}) {
+		t.Errorf("groupChanges want privileged: %q, got: %q", []string{"docker", "wheel"}, privileged)
+	}
+}
@@ -0,0 +1,255 @@
+package pkg
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// UnknownUID is used by event sources which can not tell who triggered an event
+	UnknownUID = ^uint32(0)
+	// Unknown is reported as process and user name when the event source can not tell them
+	Unknown = "unknown"
+
+	inotifyFileMask = unix.IN_MODIFY | unix.IN_ATTRIB | unix.IN_DELETE_SELF
+	inotifyDirMask  = unix.IN_CREATE | unix.IN_MOVED_TO | unix.IN_ATTRIB | unix.IN_DELETE_SELF
+	inotifyBufSize  = 4096 * (unix.SizeofInotifyEvent + unix.NAME_MAX + 1)
+)
+
+type (
+	inotifyWatch struct {
+		path  string
+		inode uint64
+		isDir bool
+	}
+	// Inotify struct that represents an inotify based event system, used where kprobes can not be loaded.
+	// Inotify does not report the process or user behind an event, so those are left unknown.
+	Inotify struct {
+		fileMapping
+		fd          int
+		file        *os.File
+		mux         sync.Mutex
+		watches     map[int32]inotifyWatch
+		descriptors map[string]int32
+		events      chan Event
+		lost        chan uint64
+		zerolog.Logger
+		closeChannelLoops chan struct{}
+	}
+)
+
+// InitInotify function to initialize and start the inotify event source
+func InitInotify(logger zerolog.Logger) (*Inotify, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error initializing inotify")
+		return nil, err
+	}
+	in := &Inotify{
+		fileMapping:       newFileMapping(),
+		fd:                fd,
+		file:              os.NewFile(uintptr(fd), "inotify"),
+		watches:           make(map[int32]inotifyWatch),
+		descriptors:       make(map[string]int32),
+		events:            make(chan Event, chanSize),
+		lost:              make(chan uint64, chanSize),
+		Logger:            logger,
+		closeChannelLoops: make(chan struct{}, 1),
+	}
+	go in.start()
+	return in, nil
+}
+
+// Events returns the channel on which inotify events are pushed
+func (in *Inotify) Events() chan Event { return in.events }
+
+// Lost returns the channel on which queue overflows are pushed, inotify does not tell how many events were lost
+func (in *Inotify) Lost() chan uint64 { return in.lost }
+
+// Stop method to release the inotify instance
+func (in *Inotify) Stop() error {
+	close(in.closeChannelLoops)
+	in.Debug().Msg("closing inotify")
+	if err := in.file.Close(); err != nil {
+		in.Error().Err(err).Msgf("Error closing inotify: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (in *Inotify) start() {
+	buf := make([]byte, inotifyBufSize)
+	for {
+		n, err := in.file.Read(buf)
+		if err != nil {
+			select {
+			case <-in.closeChannelLoops:
+				in.Debug().Msg("chan Closed")
+			default:
+				in.Error().Err(err).Msg("failed to read inotify events")
+			}
+			return
+		}
+		if !in.dispatch(buf[:n]) {
+			return
+		}
+	}
+}
+
+// dispatch pushes the events read from inotify, it returns false once inotify is stopped
+func (in *Inotify) dispatch(buf []byte) bool {
+	for offset := 0; offset+unix.SizeofInotifyEvent <= len(buf); {
+		raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameStart := offset + unix.SizeofInotifyEvent
+		name := string(bytes.TrimRight(buf[nameStart:nameStart+int(raw.Len)], "\x00"))
+		offset = nameStart + int(raw.Len)
+		if raw.Mask&unix.IN_Q_OVERFLOW != 0 {
+			in.Error().Msg("inotify queue overflow, events were lost")
+			select {
+			case in.lost <- 1:
+			case <-in.closeChannelLoops:
+				return false
+			}
+			continue
+		}
+		if event, ok := in.translate(raw.Wd, raw.Mask, name); ok {
+			in.Debug().Object("event", LogEvent(event)).Msg("message from inotify")
+			in.events <- event
+		}
+	}
+	return true
+}
+
+// translate maps an inotify event onto the event semantics of the BPF program
+func (in *Inotify) translate(wd int32, mask uint32, name string) (Event, bool) {
+	in.mux.Lock()
+	watch, ok := in.watches[wd]
+	in.mux.Unlock()
+	if !ok || mask&unix.IN_IGNORED != 0 {
+		return Event{}, false
+	}
+	event := Event{UID: UnknownUID, LoginUID: UnknownUID, Com: Unknown, Inode: watch.inode}
+	switch {
+	case mask&unix.IN_MODIFY != 0:
+		event.Mode = writeEvent
+		event.Path = watch.path
+	case mask&unix.IN_ATTRIB != 0 && name == "":
+		// inotify does not tell attribute from extended attribute changes
+		event.Mode = attrChange
+		event.Path = watch.path
+	case mask&unix.IN_CREATE != 0:
+		event.Mode = fileCreate
+		if mask&unix.IN_ISDIR != 0 {
+			event.Mode = dirCreate
+		}
+		event.Path = name
+		event.Device = in.inode(watch.path, name)
+	case mask&unix.IN_MOVED_TO != 0:
+		event.Mode = renameEvent
+		event.Path = name
+		event.Device = in.inode(watch.path, name)
+		event.NewInode = watch.inode
+		// the target is replaced, its old inode is gone and so is the watch on it
+		if target, err := in.getInodeFromFile(path.Join(watch.path, name)); err == nil {
+			event.NewDevice = target
+			in.forget(path.Join(watch.path, name))
+		}
+	case mask&unix.IN_DELETE_SELF != 0:
+		event.Mode = delFile
+		if watch.isDir {
+			event.Mode = delDir
+		}
+		event.Path = watch.path
+		in.mux.Lock()
+		delete(in.watches, wd)
+		in.mux.Unlock()
+	default:
+		return Event{}, false
+	}
+	return event, true
+}
+
+func (in *Inotify) inode(dir, name string) uint64 {
+	fstat := &syscall.Stat_t{}
+	if err := syscall.Stat(path.Join(dir, name), fstat); err != nil {
+		in.Debug().Err(err).Msgf("Error stating file: %v/%v", dir, name)
+		return 0
+	}
+	return fstat.Ino
+}
+
+// forget drops the inotify watch of a file without touching the file mapping
+func (in *Inotify) forget(name string) {
+	in.mux.Lock()
+	defer in.mux.Unlock()
+	wd, ok := in.descriptors[name]
+	if !ok {
+		return
+	}
+	delete(in.descriptors, name)
+	delete(in.watches, wd)
+	if _, err := unix.InotifyRmWatch(in.fd, uint32(wd)); err != nil {
+		in.Debug().Err(err).Str("file", name).Msg("failed to remove inotify watch")
+	}
+}
+
+// AddFile method to add a new file to inotify monitor
+func (in *Inotify) AddFile(name string) error {
+	fstat := &syscall.Stat_t{}
+	if err := syscall.Stat(name, fstat); err != nil {
+		in.Error().Err(err).Msgf("Error stating file: %v", name)
+		return err
+	}
+	isDir := fstat.Mode&syscall.S_IFMT == syscall.S_IFDIR
+	mask := uint32(inotifyFileMask)
+	if isDir {
+		mask = inotifyDirMask
+	}
+	in.forget(name)
+	wd, err := unix.InotifyAddWatch(in.fd, name, mask)
+	if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: name, Err: err}
+	}
+	in.Debug().Str("file", name).Msgf("created/updated Key : %v", fstat.Ino)
+	in.mux.Lock()
+	in.watches[int32(wd)] = inotifyWatch{path: name, inode: fstat.Ino, isDir: isDir}
+	in.descriptors[name] = int32(wd)
+	in.mux.Unlock()
+	in.MapInode(fstat.Ino, name)
+	return nil
+}
+
+// RemoveFile method to remove a file from inotify monitor
+func (in *Inotify) RemoveFile(name string) error {
+	key, err := in.getInodeFromFile(name)
+	if err != nil {
+		in.Error().Err(err)
+		return err
+	}
+	in.forget(name)
+	in.mapping.Delete(key)
+	in.reverse.Delete(name)
+	in.Debug().Msgf("map key: %v, with value: %v", key, name)
+	return nil
+}
+
+// RemoveInode method to remove a file from inotify monitor
+func (in *Inotify) RemoveInode(key uint64) (string, error) {
+	name, err := in.GetFileFromInode(key)
+	if err != nil {
+		in.Error().Err(err)
+		return "", err
+	}
+	in.forget(name)
+	in.mapping.Delete(key)
+	in.reverse.Delete(name)
+	in.Debug().Msgf("map key: %v, with value: %v", key, name)
+	return name, nil
+}
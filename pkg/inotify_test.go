@@ -0,0 +1,202 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/sys/unix"
+)
+
+// testInotify returns an inotify event source without inotify instance, watching dir and dir/passwd
+func testInotify(t *testing.T, dir string) (*Inotify, map[string]uint64) {
+	in := &Inotify{
+		fileMapping:       newFileMapping(),
+		fd:                -1,
+		watches:           make(map[int32]inotifyWatch),
+		descriptors:       make(map[string]int32),
+		events:            make(chan Event, chanSize),
+		lost:              make(chan uint64, chanSize),
+		Logger:            zerolog.Nop(),
+		closeChannelLoops: make(chan struct{}, 1),
+	}
+	inodes := make(map[string]uint64)
+	for wd, name := range []string{dir, filepath.Join(dir, "passwd")} {
+		fstat := &syscall.Stat_t{}
+		if err := syscall.Stat(name, fstat); err != nil {
+			t.Fatal(err)
+		}
+		inodes[name] = fstat.Ino
+		in.watches[int32(wd+1)] = inotifyWatch{path: name, inode: fstat.Ino, isDir: name == dir}
+		in.descriptors[name] = int32(wd + 1)
+		in.MapInode(fstat.Ino, name)
+	}
+	return in, inodes
+}
+
+func TestInotifyTranslate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_inotify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwd, shadow := filepath.Join(dir, "passwd"), filepath.Join(dir, "shadow")
+	for _, file := range []string{passwd, shadow} {
+		if err := ioutil.WriteFile(file, []byte("root:x:0:0::/root:/bin/sh\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sudoers.d"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	inode := func(name string) uint64 {
+		fstat := &syscall.Stat_t{}
+		if err := syscall.Stat(name, fstat); err != nil {
+			t.Fatal(err)
+		}
+		return fstat.Ino
+	}
+	dirInode, passwdInode, shadowInode, sudoersInode := inode(dir), inode(passwd), inode(shadow), inode(filepath.Join(dir, "sudoers.d"))
+
+	const dirWd, fileWd = 1, 2
+	for _, test := range []struct {
+		name  string
+		wd    int32
+		mask  uint32
+		file  string
+		want  Event
+		drops bool
+	}{
+		{name: "unknown watch", wd: 42, mask: unix.IN_MODIFY, drops: true},
+		{name: "ignored", wd: fileWd, mask: unix.IN_IGNORED, drops: true},
+		{name: "modify", wd: fileWd, mask: unix.IN_MODIFY, want: Event{Mode: writeEvent, Path: passwd, Inode: passwdInode}},
+		{name: "attributes", wd: fileWd, mask: unix.IN_ATTRIB, want: Event{Mode: attrChange, Path: passwd, Inode: passwdInode}},
+		{name: "attributes of a directory entry", wd: dirWd, mask: unix.IN_ATTRIB, file: "shadow", drops: true},
+		{name: "create file", wd: dirWd, mask: unix.IN_CREATE, file: "shadow",
+			want: Event{Mode: fileCreate, Path: "shadow", Inode: dirInode, Device: shadowInode}},
+		{name: "create directory", wd: dirWd, mask: unix.IN_CREATE | unix.IN_ISDIR, file: "sudoers.d",
+			want: Event{Mode: dirCreate, Path: "sudoers.d", Inode: dirInode, Device: sudoersInode}},
+		{name: "move from", wd: dirWd, mask: unix.IN_MOVED_FROM, file: "shadow", drops: true},
+		{name: "move to", wd: dirWd, mask: unix.IN_MOVED_TO, file: "shadow",
+			want: Event{Mode: renameEvent, Path: "shadow", Inode: dirInode, Device: shadowInode, NewInode: dirInode}},
+		{name: "delete file", wd: fileWd, mask: unix.IN_DELETE_SELF, want: Event{Mode: delFile, Path: passwd, Inode: passwdInode}},
+		{name: "delete directory", wd: dirWd, mask: unix.IN_DELETE_SELF, want: Event{Mode: delDir, Path: dir, Inode: dirInode}},
+		{name: "overflow", wd: -1, mask: unix.IN_Q_OVERFLOW, drops: true},
+	} {
+		in, _ := testInotify(t, dir)
+		event, ok := in.translate(test.wd, test.mask, test.file)
+		if test.drops {
+			if ok {
+				t.Errorf("%s want no event, got: %+v", test.name, event)
+			}
+			continue
+		}
+		test.want.UID, test.want.LoginUID, test.want.Com = UnknownUID, UnknownUID, Unknown
+		if !ok || !reflect.DeepEqual(event, test.want) {
+			t.Errorf("%s want: %+v, got: %+v, %v", test.name, test.want, event, ok)
+		}
+		if test.want.Mode == delFile || test.want.Mode == delDir {
+			if _, ok := in.translate(test.wd, unix.IN_MODIFY, ""); ok {
+				t.Errorf("%s want the watch of a deleted file dropped", test.name)
+			}
+		}
+	}
+}
+
+// rawInotify encodes events the way the kernel hands them to read
+func rawInotify(t *testing.T, events ...unix.InotifyEvent) []byte {
+	var buf bytes.Buffer
+	for _, event := range events {
+		name := make([]byte, event.Len)
+		if event.Len != 0 {
+			copy(name, "shadow")
+		}
+		if err := binary.Write(&buf, binary.LittleEndian, event); err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(name)
+	}
+	return buf.Bytes()
+}
+
+func TestInotifyRenamePair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_inotify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwd, temp := filepath.Join(dir, "passwd"), filepath.Join(dir, "passwd+")
+	if err := ioutil.WriteFile(passwd, []byte("root:x:0:0::/root:/bin/sh\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	in, inodes := testInotify(t, dir)
+	// passwd is replaced atomically, a temporary file is written and renamed over it
+	if err := ioutil.WriteFile(temp, []byte("root:x:0:0::/root:/bin/bash\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(temp, passwd); err != nil {
+		t.Fatal(err)
+	}
+	fstat := &syscall.Stat_t{}
+	if err := syscall.Stat(passwd, fstat); err != nil {
+		t.Fatal(err)
+	}
+
+	if from, ok := in.translate(1, unix.IN_MOVED_FROM, "passwd+"); ok {
+		t.Errorf("want the source of a rename dropped, got: %+v", from)
+	}
+	to, ok := in.translate(1, unix.IN_MOVED_TO, "passwd")
+	want := Event{Mode: renameEvent, Path: "passwd", Inode: inodes[dir], Device: fstat.Ino, NewInode: inodes[dir],
+		NewDevice: inodes[passwd], UID: UnknownUID, LoginUID: UnknownUID, Com: Unknown}
+	if !ok || !reflect.DeepEqual(to, want) {
+		t.Errorf("rename want: %+v, got: %+v", want, to)
+	}
+	// the replaced file took its watch along, the watcher adds the new one
+	if _, ok := in.descriptors[passwd]; ok {
+		t.Error("rename want the watch of the replaced file dropped")
+	}
+	if _, ok := in.translate(2, unix.IN_MODIFY, ""); ok {
+		t.Error("rename want no event from the watch of the replaced file")
+	}
+}
+
+func TestInotifyOverflow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_inotify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "passwd"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	in, _ := testInotify(t, dir)
+
+	buf := rawInotify(t,
+		unix.InotifyEvent{Wd: 2, Mask: unix.IN_MODIFY},
+		unix.InotifyEvent{Wd: -1, Mask: unix.IN_Q_OVERFLOW},
+		unix.InotifyEvent{Wd: 1, Mask: unix.IN_MOVED_FROM, Len: 16},
+		unix.InotifyEvent{Wd: 2, Mask: unix.IN_ATTRIB},
+	)
+	if !in.dispatch(buf) {
+		t.Fatal("dispatch want to go on reading events")
+	}
+	if len(in.lost) != 1 || <-in.lost != 1 {
+		t.Error("want an overflow reported as lost events")
+	}
+	if len(in.events) != 2 || (<-in.events).Mode != writeEvent || (<-in.events).Mode != attrChange {
+		t.Error("want the events around an overflow pushed")
+	}
+
+	// once stopped, an overflow which can not be reported ends the loop
+	in.lost = make(chan uint64)
+	close(in.closeChannelLoops)
+	if in.dispatch(rawInotify(t, unix.InotifyEvent{Wd: -1, Mask: unix.IN_Q_OVERFLOW})) {
+		t.Error("dispatch want to stop once inotify is stopped")
+	}
+}
@@ -0,0 +1,164 @@
+package pkg
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	osReleaseFile = "/proc/sys/kernel/osrelease"
+	// fallbackBPFObject is used when no versioned object fits the running kernel
+	fallbackBPFObject = "vfs.o"
+	// bpfLayoutSection is the section of vfs.c holding the version of the data_t layout of the object
+	bpfLayoutSection = "layout"
+	// BPFLayout version of the data_t layout decoded into rawEvent
+	BPFLayout = 2
+	// LegacyBPFLayout version of the objects built before the layout section, decoded into legacyRawEvent
+	LegacyBPFLayout = 1
+	// attrProbesMajor and attrProbesMinor is the version from which notify_change and the xattr functions
+	// take the user namespace of the mount as first argument
+	attrProbesMajor, attrProbesMinor = 5, 12
+)
+
+// nolint:gochecknoglobals
+var attrKprobes = []string{"kprobe/notify_change", "kprobe/vfs_setxattr", "kprobe/vfs_removexattr"}
+
+// nolint:gochecknoglobals
+var bpfObjectPattern = regexp.MustCompile(`^vfs-(\d+)\.(\d+)\.o$`)
+
+type bpfObject struct {
+	name         string
+	major, minor int
+	layout       uint32
+}
+
+// KernelRelease returns the release of the running kernel, i.e. 4.19.0-9-amd64
+func KernelRelease() (string, error) {
+	release, err := ioutil.ReadFile(osReleaseFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(release)), nil
+}
+
+func parseKernelVersion(release string) (major, minor int, err error) {
+	parts := strings.SplitN(release, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("unexpected kernel release %q", release)
+	}
+	if major, err = strconv.Atoi(parts[0]); err != nil {
+		return 0, 0, fmt.Errorf("unexpected kernel release %q: %v", release, err)
+	}
+	minorDigits := strings.IndexFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' })
+	if minorDigits == -1 {
+		minorDigits = len(parts[1])
+	}
+	if minor, err = strconv.Atoi(parts[1][:minorDigits]); err != nil {
+		return 0, 0, fmt.Errorf("unexpected kernel release %q: %v", release, err)
+	}
+	return major, minor, nil
+}
+
+// BPFObjectLayout returns the version of the event layout of a BPF object, LegacyBPFLayout when it has none
+func BPFObjectLayout(path string) (uint32, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close() // nolint:errcheck
+	section := file.Section(bpfLayoutSection)
+	if section == nil {
+		return LegacyBPFLayout, nil
+	}
+	data, err := section.Data()
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < 4 {
+		return 0, fmt.Errorf("unexpected %s section in %s", bpfLayoutSection, path)
+	}
+	return binary.LittleEndian.Uint32(data), nil
+}
+
+// SelectBPFObject picks the vfs-X.Y.o object of dir that best fits the kernel release.
+// The object built for the same Major.Minor version wins, then a plain vfs.o built locally,
+// and finally the newest object built for an older kernel. The kernel version wins over the event layout,
+// a legacy object built for the kernel is picked over a newer one built for an older kernel,
+// objects of layouts other than BPFLayout and LegacyBPFLayout are never picked.
+func SelectBPFObject(dir, release string) (string, error) {
+	major, minor, err := parseKernelVersion(release)
+	if err != nil {
+		return "", err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var objects []bpfObject
+	var fallback *bpfObject
+	for _, file := range files {
+		object := bpfObject{name: file.Name()}
+		if file.Name() != fallbackBPFObject {
+			matches := bpfObjectPattern.FindStringSubmatch(file.Name())
+			if matches == nil {
+				continue
+			}
+			object.major, _ = strconv.Atoi(matches[1])
+			object.minor, _ = strconv.Atoi(matches[2])
+		}
+		if object.layout, err = BPFObjectLayout(filepath.Join(dir, file.Name())); err != nil ||
+			(object.layout != BPFLayout && object.layout != LegacyBPFLayout) {
+			continue
+		}
+		if file.Name() == fallbackBPFObject {
+			fallback = &object
+			continue
+		}
+		objects = append(objects, object)
+	}
+	// newest first, so the first older object is the closest one
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].major != objects[j].major {
+			return objects[i].major > objects[j].major
+		}
+		return objects[i].minor > objects[j].minor
+	})
+	for _, object := range objects {
+		if object.major == major && object.minor == minor {
+			return filepath.Join(dir, object.name), nil
+		}
+	}
+	if fallback != nil {
+		return filepath.Join(dir, fallback.name), nil
+	}
+	for _, object := range objects {
+		if object.major < major || (object.major == major && object.minor < minor) {
+			return filepath.Join(dir, object.name), nil
+		}
+	}
+	return "", fmt.Errorf("no BPF object in %s fits kernel %s", dir, release)
+}
+
+// AttrProbesFit tells whether the attribute probes of an object read the arguments of the running kernel,
+// they read them at the position of the kernel headers the object was built against, which moved in 5.12.
+// Objects not named after a kernel version are assumed to be built for the running kernel.
+func AttrProbesFit(object, release string) bool {
+	matches := bpfObjectPattern.FindStringSubmatch(filepath.Base(object))
+	major, minor, err := parseKernelVersion(release)
+	if matches == nil || err != nil {
+		return true
+	}
+	objectMajor, _ := strconv.Atoi(matches[1])
+	objectMinor, _ := strconv.Atoi(matches[2])
+	shifted := func(major, minor int) bool {
+		return major > attrProbesMajor || (major == attrProbesMajor && minor >= attrProbesMinor)
+	}
+	return shifted(objectMajor, objectMinor) == shifted(major, minor)
+}
@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeBPFObject writes a BPF ELF object holding only a layout section, none when layout is LegacyBPFLayout
+func writeBPFObject(t *testing.T, path string, layout uint32) {
+	names := "\x00.shstrtab\x00" + bpfLayoutSection + "\x00"
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, layout)
+	header := elf.Header64{
+		Type: uint16(elf.ET_REL), Machine: uint16(elf.EM_BPF), Version: uint32(elf.EV_CURRENT),
+		Ehsize: 64, Shentsize: 64, Shnum: 2, Shstrndx: 1,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS], header.Ident[elf.EI_DATA], header.Ident[elf.EI_VERSION] = byte(elf.ELFCLASS64), byte(elf.ELFDATA2LSB), byte(elf.EV_CURRENT)
+	sections := []elf.Section64{
+		{},
+		{Name: 1, Type: uint32(elf.SHT_STRTAB), Off: 64, Size: uint64(len(names)), Addralign: 1},
+	}
+	if layout != LegacyBPFLayout {
+		header.Shnum = 3
+		sections = append(sections, elf.Section64{
+			Name: uint32(len("\x00.shstrtab\x00")), Type: uint32(elf.SHT_PROGBITS),
+			Flags: uint64(elf.SHF_ALLOC | elf.SHF_WRITE), Off: 64 + 32, Size: 4, Addralign: 4,
+		})
+	}
+	header.Shoff = 64 + 32 + 8
+	buf := &bytes.Buffer{}
+	for _, part := range []interface{}{header, []byte(names), make([]byte, 32-len(names)), data, make([]byte, 4), sections} {
+		if err := binary.Write(buf, binary.LittleEndian, part); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSelectBPFObject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_objects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"vfs-3.10.o", "vfs-4.9.o", "vfs-4.14.o", "vfs-4.18.o", "vfs-4.19.o"} {
+		writeBPFObject(t, filepath.Join(dir, name), BPFLayout)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "vfs.ll"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var selectEntries = []struct {
+		release, object string
+	}{
+		{"4.19.0-9-amd64", "vfs-4.19.o"},
+		{"4.18.0-193.el8.x86_64", "vfs-4.18.o"},
+		{"3.10.0-1127.el7.x86_64", "vfs-3.10.o"},
+		{"4.15.0-101-generic", "vfs-4.14.o"},
+		{"5.4.0", "vfs-4.19.o"},
+		{"3.2.0", ""},
+	}
+	for _, entry := range selectEntries {
+		object, err := SelectBPFObject(dir, entry.release)
+		switch {
+		case entry.object == "" && err == nil:
+			t.Errorf("SelectBPFObject(%s) want an error, got: %s", entry.release, object)
+		case entry.object != "" && object != filepath.Join(dir, entry.object):
+			t.Errorf("SelectBPFObject(%s) want: %s, got: %s (%v)", entry.release, entry.object, object, err)
+		}
+	}
+
+	writeBPFObject(t, filepath.Join(dir, "vfs.o"), BPFLayout)
+	for release, object := range map[string]string{"4.19.0": "vfs-4.19.o", "4.15.0": "vfs.o", "3.2.0": "vfs.o"} {
+		if selected, _ := SelectBPFObject(dir, release); selected != filepath.Join(dir, object) {
+			t.Errorf("SelectBPFObject(%s) want: %s, got: %s", release, object, selected)
+		}
+	}
+
+	// legacy objects built for the kernel are picked over other objects, objects of unknown layouts never
+	writeBPFObject(t, filepath.Join(dir, "vfs-4.19.o"), LegacyBPFLayout)
+	writeBPFObject(t, filepath.Join(dir, "vfs-4.18.o"), BPFLayout+1)
+	for release, object := range map[string]string{"4.19.0": "vfs-4.19.o", "4.18.0": "vfs.o"} {
+		if selected, _ := SelectBPFObject(dir, release); selected != filepath.Join(dir, object) {
+			t.Errorf("SelectBPFObject(%s) want: %s, got: %s", release, object, selected)
+		}
+	}
+	if err := os.Remove(filepath.Join(dir, "vfs.o")); err != nil {
+		t.Fatal(err)
+	}
+	for release, object := range map[string]string{"4.19.0": "vfs-4.19.o", "5.4.0": "vfs-4.19.o", "4.18.0": "vfs-4.14.o"} {
+		if selected, _ := SelectBPFObject(dir, release); selected != filepath.Join(dir, object) {
+			t.Errorf("SelectBPFObject(%s) want: %s, got: %s", release, object, selected)
+		}
+	}
+	for _, name := range []string{"vfs-3.10.o", "vfs-4.9.o", "vfs-4.14.o"} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if selected, err := SelectBPFObject(dir, "4.18.0"); err == nil {
+		t.Errorf("SelectBPFObject(4.18.0) want an error with only an object of unknown layout, got: %s", selected)
+	}
+	if layout, err := BPFObjectLayout(filepath.Join(dir, "vfs-4.19.o")); err != nil || layout != LegacyBPFLayout {
+		t.Errorf("BPFObjectLayout want: %d, got: %d (%v)", LegacyBPFLayout, layout, err)
+	}
+}
+
+func TestAttrProbesFit(t *testing.T) {
+	for _, entry := range []struct {
+		object, release string
+		fit             bool
+	}{
+		{"/usr/lib/bpfink/vfs-4.19.o", "4.19.0-9-amd64", true},
+		{"/usr/lib/bpfink/vfs-4.19.o", "5.4.0", true},
+		{"/usr/lib/bpfink/vfs-4.19.o", "5.12.0", false},
+		{"/usr/lib/bpfink/vfs-4.19.o", "6.1.0-18-amd64", false},
+		{"/usr/lib/bpfink/vfs-5.15.o", "6.1.0-18-amd64", true},
+		{"pkg/ebpf/vfs.o", "6.1.0-18-amd64", true},
+	} {
+		if fit := AttrProbesFit(entry.object, entry.release); fit != entry.fit {
+			t.Errorf("AttrProbesFit(%s, %s) want: %v, got: %v", entry.object, entry.release, entry.fit, fit)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package authorizedkeys
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// Key struct that represents a key of an authorized_keys file
+type Key struct {
+	Options     []string
+	Type        string
+	Blob        string
+	Comment     string
+	Fingerprint string
+}
+
+// Parser struct to handle parsing of authorized_keys file
+type Parser struct {
+	zerolog.Logger
+	FileName string
+	Keys     []Key
+}
+
+// Parse func that parses an authorized_keys file to collect keys
+func (p *Parser) Parse() error {
+	file, err := os.Open(p.FileName)
+	if err != nil {
+		p.Error().Err(err)
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			p.Error().Err(err)
+		}
+	}()
+
+	scanner := bufio.NewScanner(file)
+	// keys and options such as a long from= list can exceed the default token size
+	scanner.Buffer(make([]byte, bufio.MaxScanTokenSize), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		key, err := ParseLine(line)
+		if err != nil {
+			p.Warn().Err(err).Str("file", p.FileName).Msg("skipping malformed key")
+			continue
+		}
+		p.Keys = append(p.Keys, key)
+	}
+	return scanner.Err()
+}
+
+// ParseLine parses one authorized_keys line: [options] keytype base64-key [comment]
+func ParseLine(line string) (Key, error) {
+	key := Key{}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return key, fmt.Errorf("empty line")
+	}
+	if !isKeyType(fields[0]) {
+		options, rest := splitOptions(line)
+		key.Options = options
+		fields = strings.Fields(rest)
+	}
+	if len(fields) < 2 || !isKeyType(fields[0]) {
+		return key, fmt.Errorf("no key type found")
+	}
+	key.Type, key.Blob = fields[0], fields[1]
+	key.Comment = strings.Join(fields[2:], " ")
+
+	blob, err := base64.StdEncoding.DecodeString(key.Blob)
+	if err != nil {
+		return key, fmt.Errorf("invalid %s key: %v", key.Type, err)
+	}
+	// the blob starts with the key type, a mismatch is a malformed or tampered line
+	if len(blob) < 4 || int(binary.BigEndian.Uint32(blob))+4 > len(blob) ||
+		string(blob[4:4+binary.BigEndian.Uint32(blob)]) != key.Type {
+		return key, fmt.Errorf("%s key blob does not match its type", key.Type)
+	}
+	sum := sha256.Sum256(blob)
+	key.Fingerprint = "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+	return key, nil
+}
+
+// isKeyType covers RSA, DSA, ECDSA, Ed25519, their FIDO (sk-) variants and certificates
+func isKeyType(field string) bool {
+	for _, prefix := range []string{"ssh-", "ecdsa-sha2-", "sk-ssh-", "sk-ecdsa-sha2-"} {
+		if strings.HasPrefix(field, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitOptions splits the comma separated options prefix, commas and spaces within quotes included
+func splitOptions(line string) (options []string, rest string) {
+	quoted, start := false, 0
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			options = append(options, line[start:i])
+			start = i + 1
+		case (c == ' ' || c == '\t') && !quoted:
+			return append(options, line[start:i]), line[i:]
+		}
+	}
+	return append(options, line[start:]), ""
+}
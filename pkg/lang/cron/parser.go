@@ -0,0 +1,87 @@
+package cron
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// nolint:gochecknoglobals
+var (
+	assignment = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.*)$`)
+	fields     = regexp.MustCompile(`\s+`)
+)
+
+// Job struct that represents one scheduled command, User is only set in system crontabs
+type Job struct {
+	Schedule string // the five time fields or a nickname, i.e. @reboot
+	User     string
+	Command  string
+}
+
+// Parser struct to handle parsing of a crontab
+type Parser struct {
+	zerolog.Logger
+	FileName string
+	// System crontabs, /etc/crontab and the files of /etc/cron.d, have a user field before the command
+	System bool
+	Jobs   []Job
+	// Env lists the variables set for the jobs, i.e. PATH=/usr/bin:/bin
+	Env []string
+}
+
+// Parse func that parses a crontab to collect its jobs and variables
+func (p *Parser) Parse() error {
+	file, err := os.Open(p.FileName)
+	if err != nil {
+		p.Error().Err(err)
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			p.Error().Err(err)
+		}
+	}()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if match := assignment.FindStringSubmatch(line); match != nil {
+			p.Env = append(p.Env, match[1]+"="+strings.Trim(match[2], `"'`))
+			continue
+		}
+		if job, ok := p.job(line); ok {
+			p.Jobs = append(p.Jobs, job)
+		} else {
+			p.Warn().Str("file", p.FileName).Str("line", line).Msg("failed to parse cron job")
+		}
+	}
+	return scanner.Err()
+}
+
+// job splits a line in its schedule, user and command, the command keeps its spacing
+func (p *Parser) job(line string) (Job, bool) {
+	timeFields := 5
+	if line[0] == '@' {
+		timeFields = 1
+	}
+	userField := 0
+	if p.System {
+		userField = 1
+	}
+	parts := fields.Split(line, timeFields+userField+1)
+	if len(parts) != timeFields+userField+1 {
+		return Job{}, false
+	}
+	job := Job{Schedule: strings.Join(parts[:timeFields], " "), Command: parts[len(parts)-1]}
+	if p.System {
+		job.User = parts[timeFields]
+	}
+	return job, true
+}
@@ -1,9 +1,10 @@
 package generic
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"hash"
 	"io"
 	"os"
@@ -12,22 +13,50 @@ import (
 	"golang.org/x/crypto/blake2b"
 )
 
-// Parser struct to handle parsing access.conf
+const (
+	// Blake2b keyed BLAKE2b-256 digest, the default
+	Blake2b = "blake2b"
+	// HMACSHA256 HMAC-SHA256 digest
+	HMACSHA256 = "hmac-sha256"
+	// SHA256 plain SHA-256 digest, comparable against known-good values
+	SHA256 = "sha256"
+
+	keyIDSize = 8
+)
+
+// Parser struct to handle hashing of generic files
 type Parser struct {
 	zerolog.Logger
 	FileName string
 	Hash     []byte
 	Key      []byte
+	Digest   string
 }
 
-const nonceSize = 12
+// NewHash returns the hash function for the given digest, keyed with key where relevant
+func NewHash(digest string, key []byte) (hash.Hash, error) {
+	switch digest {
+	case "", Blake2b:
+		return blake2b.New256(key)
+	case HMACSHA256:
+		return hmac.New(sha256.New, key), nil
+	case SHA256:
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown digest %q, choices are %q, %q, %q", digest, Blake2b, HMACSHA256, SHA256)
+	}
+}
 
-func defaultHashFunc() hash.Hash {
-	h, _ := blake2b.New256(nil)
-	return h
+// KeyID returns a short identifier of the key used by a keyed digest, without disclosing the key
+func KeyID(digest string, key []byte) string {
+	if digest == SHA256 {
+		return ""
+	}
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:keyIDSize])
 }
 
-// Parse func that parses a access file to collect accessObjects
+// Parse func that computes the digest of a generic file
 func (p *Parser) Parse() error {
 	file, err := os.Open(p.FileName)
 	if err != nil {
@@ -40,36 +69,15 @@ func (p *Parser) Parse() error {
 		}
 	}()
 	p.Debug().Msgf("hashing file: %v", p.FileName)
-	hashFunc := defaultHashFunc()
-	if _, err := io.Copy(hashFunc, file); err != nil {
-		return err
-	}
-
-	hashSum := hashFunc.Sum(nil)
-
-	nonce, err := generateNonce()
+	hashFunc, err := NewHash(p.Digest, p.Key)
 	if err != nil {
 		return err
 	}
-	block, err := aes.NewCipher(p.Key)
-	if err != nil {
-		return err
-	}
-	aead, err := cipher.NewGCM(block)
-	if err != nil {
+	if _, err := io.Copy(hashFunc, file); err != nil {
 		return err
 	}
 
-	nonce = append(nonce, aead.Seal(nil, nonce, hashSum, nil)...)
-	p.Hash = nonce
-	p.Debug().Msgf("Hash: %v", string(p.Hash))
+	p.Hash = hashFunc.Sum(nil)
+	p.Debug().Msgf("Hash: %x", p.Hash)
 	return nil
 }
-
-func generateNonce() ([]byte, error) {
-	nonce := make([]byte, nonceSize)
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, err
-	}
-	return nonce, nil
-}
@@ -0,0 +1,77 @@
+package group
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	name = iota
+	password
+	gid
+	members
+	fieldCount
+)
+
+// Group struct that represents a group in group file
+type Group struct {
+	Name     string
+	Password string
+	GID      string
+	Members  []string
+}
+
+// Parser struct to handle parsing of group file
+type Parser struct {
+	zerolog.Logger
+	FileName string
+	Groups   []Group
+}
+
+// Parse func that parses a group file to collect groups
+func (p *Parser) Parse() error {
+	file, err := os.Open(p.FileName)
+	if err != nil {
+		p.Error().Err(err)
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			p.Error().Err(err)
+		}
+	}()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 || string(line[0]) == "#" {
+			continue
+		}
+		entries := strings.Split(line, ":")
+		if len(entries) != fieldCount {
+			p.Warn().Str("file", p.FileName).Str("group", entries[name]).Msg("malformed group entry")
+			continue
+		}
+		p.Groups = append(p.Groups, Group{
+			Name:     strings.TrimSpace(entries[name]),
+			Password: strings.TrimSpace(entries[password]),
+			GID:      strings.TrimSpace(entries[gid]),
+			Members:  List(entries[members]),
+		})
+	}
+	return scanner.Err()
+}
+
+// List splits a comma separated list of users, dropping empty entries
+func List(field string) (users []string) {
+	for _, user := range strings.Split(field, ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			users = append(users, user)
+		}
+	}
+	return users
+}
@@ -0,0 +1,69 @@
+package gshadow
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/bookingcom/bpfink/pkg/lang/group"
+)
+
+const (
+	name = iota
+	password
+	admins
+	members
+	fieldCount
+)
+
+// Group struct that represents a group in gshadow file
+type Group struct {
+	Name     string
+	Password string
+	Admins   []string
+	Members  []string
+}
+
+// Parser struct to handle parsing of gshadow file
+type Parser struct {
+	zerolog.Logger
+	FileName string
+	Groups   []Group
+}
+
+// Parse func that parses a gshadow file to collect groups
+func (p *Parser) Parse() error {
+	file, err := os.Open(p.FileName)
+	if err != nil {
+		p.Error().Err(err)
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			p.Error().Err(err)
+		}
+	}()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 || string(line[0]) == "#" {
+			continue
+		}
+		entries := strings.Split(line, ":")
+		if len(entries) != fieldCount {
+			p.Warn().Str("file", p.FileName).Str("group", entries[name]).Msg("malformed gshadow entry")
+			continue
+		}
+		p.Groups = append(p.Groups, Group{
+			Name:     strings.TrimSpace(entries[name]),
+			Password: strings.TrimSpace(entries[password]),
+			Admins:   group.List(entries[admins]),
+			Members:  group.List(entries[members]),
+		})
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,125 @@
+package ldso
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// maxDepth bounds the include chains of ld.so.conf, ldconfig does not and loops on cycles
+const maxDepth = 16
+
+// Parser struct to handle parsing of ld.so.conf and the files it includes
+type Parser struct {
+	zerolog.Logger
+	FileName string
+	// Dirs are the library directories, in order of search
+	Dirs []string
+	// Files lists every parsed file, IncludeDirs the directories of include globs, both are watched for changes
+	Files       []string
+	IncludeDirs []string
+}
+
+// Parse func that parses ld.so.conf to collect library directories and follows its includes
+func (p *Parser) Parse() error {
+	return p.parse(p.FileName, 0)
+}
+
+func (p *Parser) parse(fileName string, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("too many levels of includes in %s", fileName)
+	}
+	lines, err := readLines(fileName)
+	if err != nil {
+		p.Error().Err(err)
+		return err
+	}
+	p.Files = append(p.Files, fileName)
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "include":
+			for _, pattern := range fields[1:] {
+				p.include(fileName, pattern, depth)
+			}
+		case "hwcap":
+		default:
+			// a dir may be followed by the =libc5 style type of its libraries, dirs may be separated by commas or colons
+			for _, dir := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ':' || r == ' ' || r == '\t' }) {
+				if dir = strings.SplitN(dir, "=", 2)[0]; dir != "" {
+					p.Dirs = append(p.Dirs, filepath.Clean(dir))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// include follows an include glob, relative patterns are relative to the directory of the including file
+func (p *Parser) include(from, pattern string, depth int) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(from), pattern)
+	}
+	p.IncludeDirs = append(p.IncludeDirs, filepath.Dir(pattern))
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		p.Warn().Err(err).Str("file", from).Str("include", pattern).Msg("failed to follow include")
+		return
+	}
+	for _, file := range files {
+		if err := p.parse(file, depth+1); err != nil {
+			p.Warn().Err(err).Str("file", from).Str("include", file).Msg("failed to follow include")
+		}
+	}
+}
+
+// PreloadParser struct to handle parsing of ld.so.preload
+type PreloadParser struct {
+	zerolog.Logger
+	FileName string
+	// Libraries are loaded in every dynamically linked program, in order
+	Libraries []string
+}
+
+// Parse func that parses ld.so.preload, libraries are separated by spaces, tabs, colons or new lines
+func (p *PreloadParser) Parse() error {
+	lines, err := readLines(p.FileName)
+	if err != nil {
+		p.Error().Err(err)
+		return err
+	}
+	for _, line := range lines {
+		p.Libraries = append(p.Libraries, strings.FieldsFunc(line, func(r rune) bool {
+			return r == ':' || r == ' ' || r == '\t'
+		})...)
+	}
+	return nil
+}
+
+// readLines returns the lines of a file stripped from comments, empty ones left out
+func readLines(fileName string) (lines []string, err error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if comment := strings.IndexByte(line, '#'); comment != -1 {
+			line = line[:comment]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,102 @@
+package pam
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// Include is the type of the Debian @include lines, their Module is the service included
+const Include = "@include"
+
+// Entry struct that represents one line of a PAM stack, Type keeps its - prefix silencing missing modules.
+// For the include and substack controls, Module is the service referenced.
+type Entry struct {
+	Type    string
+	Control string
+	Module  string
+	Args    []string
+}
+
+// String renders an entry the way it reads in pam.d, bracketed controls and arguments with single spaces
+func (e Entry) String() string {
+	fields := []string{e.Type}
+	if e.Control != "" {
+		fields = append(fields, e.Control)
+	}
+	return strings.Join(append(append(fields, e.Module), e.Args...), " ")
+}
+
+// Parser struct to handle parsing of a pam.d service file
+type Parser struct {
+	zerolog.Logger
+	FileName string
+	// Entries are kept in order, the order of the modules of a stack decides its outcome
+	Entries []Entry
+}
+
+// Parse func that parses a service file to collect its entries
+func (p *Parser) Parse() error {
+	file, err := os.Open(p.FileName)
+	if err != nil {
+		p.Error().Err(err)
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			p.Error().Err(err)
+		}
+	}()
+
+	continued := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if comment := strings.IndexByte(line, '#'); comment != -1 {
+			line = line[:comment]
+		}
+		line = strings.TrimSpace(continued + " " + line)
+		if strings.HasSuffix(line, `\`) {
+			continued = strings.TrimSuffix(line, `\`)
+			continue
+		}
+		continued = ""
+		if len(line) == 0 {
+			continue
+		}
+		fields := split(line)
+		switch {
+		case fields[0] == Include && len(fields) == 2:
+			p.Entries = append(p.Entries, Entry{Type: Include, Module: fields[1]})
+		case len(fields) >= 3:
+			p.Entries = append(p.Entries, Entry{Type: fields[0], Control: fields[1], Module: fields[2], Args: fields[3:]})
+		default:
+			p.Warn().Str("file", p.FileName).Str("line", line).Msg("failed to parse pam entry")
+		}
+	}
+	return scanner.Err()
+}
+
+// split separates the fields of a line, a bracketed field, i.e. [success=1 default=ignore], being one field
+func split(line string) (fields []string) {
+	for line != "" {
+		end := strings.IndexAny(line, " \t")
+		if bracket := strings.IndexByte(line, '['); bracket != -1 && (end == -1 || bracket < end) {
+			if closing := strings.IndexByte(line[bracket:], ']'); closing != -1 {
+				end = strings.IndexAny(line[bracket+closing:], " \t")
+				if end != -1 {
+					end += bracket + closing
+				}
+			}
+		}
+		if end == -1 {
+			fields = append(fields, strings.Join(strings.Fields(line), " "))
+			break
+		}
+		fields = append(fields, strings.Join(strings.Fields(line[:end]), " "))
+		line = strings.TrimLeft(line[end:], " \t")
+	}
+	return fields
+}
@@ -0,0 +1,146 @@
+package sshdconfig
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// maxDepth mirrors sshd, which stops following includes after 16 levels
+const maxDepth = 16
+
+// Directive struct that represents a keyword and its arguments, Match is the criteria of the enclosing Match block
+type Directive struct {
+	Keyword string
+	// Name is the keyword as written, Keyword is lower cased as sshd ignores its case
+	Name  string
+	Args  []string
+	Match string
+	File  string
+}
+
+// Parser struct to handle parsing of sshd_config and the files it includes
+type Parser struct {
+	zerolog.Logger
+	FileName   string
+	Directives []Directive
+	// Matches lists the criteria of every Match block, in order
+	Matches []string
+	// Files lists every parsed file, Dirs the directories of Include globs, both are watched for changes
+	Files []string
+	Dirs  []string
+}
+
+// Parse func that parses sshd_config to collect directives and follows its includes
+func (p *Parser) Parse() error {
+	return p.parse(p.FileName, "", 0)
+}
+
+// parse reads a file whose directives start in the Match block of the including file
+func (p *Parser) parse(fileName, match string, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("too many levels of includes in %s", fileName)
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		p.Error().Err(err)
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			p.Error().Err(err)
+		}
+	}()
+	p.Files = append(p.Files, fileName)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		name, args := split(line)
+		switch keyword := strings.ToLower(name); keyword {
+		case "match":
+			match = strings.Join(args, " ")
+			if strings.EqualFold(match, "all") {
+				match = ""
+			} else {
+				p.Matches = append(p.Matches, match)
+			}
+		case "include":
+			for _, pattern := range args {
+				p.include(fileName, pattern, match, depth)
+			}
+		default:
+			p.Directives = append(p.Directives, Directive{Keyword: keyword, Name: name, Args: args, Match: match, File: fileName})
+		}
+	}
+	return scanner.Err()
+}
+
+// include follows an Include glob, relative patterns are relative to the directory of sshd_config, /etc/ssh usually.
+// A Match block started in an included file ends with it.
+func (p *Parser) include(from, pattern, match string, depth int) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(p.FileName), pattern)
+	}
+	p.Dirs = append(p.Dirs, filepath.Dir(pattern))
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		p.Warn().Err(err).Str("file", from).Str("include", pattern).Msg("failed to follow include")
+		return
+	}
+	for _, file := range files {
+		if err := p.parse(file, match, depth+1); err != nil {
+			p.Warn().Err(err).Str("file", from).Str("include", file).Msg("failed to follow include")
+		}
+	}
+}
+
+// Values returns the arguments of every occurrence of a keyword, the global one first as sshd keeps the first value
+func (p *Parser) Values(keyword string) (values [][]string) {
+	keyword = strings.ToLower(keyword)
+	for _, directive := range p.Directives {
+		if directive.Keyword == keyword {
+			values = append(values, directive.Args)
+		}
+	}
+	return values
+}
+
+// split separates the keyword, optionally followed by '=', from its arguments which may be double quoted
+func split(line string) (string, []string) {
+	end := strings.IndexAny(line, " \t=")
+	if end == -1 {
+		return line, nil
+	}
+	keyword, rest := line[:end], strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+
+	var args []string
+	for rest != "" {
+		if rest[0] == '"' {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing == -1 {
+				args = append(args, rest[1:])
+				break
+			}
+			args = append(args, rest[1:closing+1])
+			rest = strings.TrimLeft(rest[closing+2:], " \t")
+			continue
+		}
+		end := strings.IndexAny(rest, " \t")
+		if end == -1 {
+			args = append(args, rest)
+			break
+		}
+		args = append(args, rest[:end])
+		rest = strings.TrimLeft(rest[end:], " \t")
+	}
+	return keyword, args
+}
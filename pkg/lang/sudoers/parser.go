@@ -0,0 +1,353 @@
+package sudoers
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// maxDepth mirrors sudo, which stops following includes after 128 levels
+	maxDepth = 128
+	// DefaultRunAs is the user commands run as when no Runas_Spec is given
+	DefaultRunAs = "root"
+)
+
+// nolint:gochecknoglobals
+var (
+	aliasKinds = map[string]string{
+		"User_Alias":  "User_Alias",
+		"Runas_Alias": "Runas_Alias",
+		"Host_Alias":  "Host_Alias",
+		"Cmnd_Alias":  "Cmnd_Alias",
+		"Cmd_Alias":   "Cmnd_Alias",
+	}
+	tags = map[string]bool{
+		"NOPASSWD": true, "PASSWD": true, "NOEXEC": true, "EXEC": true, "SETENV": true, "NOSETENV": true,
+		"LOG_INPUT": true, "NOLOG_INPUT": true, "LOG_OUTPUT": true, "NOLOG_OUTPUT": true, "MAIL": true,
+		"NOMAIL": true, "FOLLOW": true, "NOFOLLOW": true, "INTERCEPT": true, "NOINTERCEPT": true,
+	}
+	digests     = map[string]bool{"sha224": true, "sha256": true, "sha384": true, "sha512": true}
+	option      = regexp.MustCompile(`^(ROLE|TYPE|TIMEOUT|NOTBEFORE|NOTAFTER|CWD|CHROOT|APPARMOR_PROFILE|PRIVS|LIMITPRIVS)=\S+\s*`)
+	commaSpaces = regexp.MustCompile(`\s*,\s*`)
+	include     = regexp.MustCompile(`^[#@](include|includedir)\s+(.+)$`)
+)
+
+type (
+	// Alias struct that represents one alias definition, i.e. User_Alias ADMINS = alice, bob
+	Alias struct {
+		Kind    string
+		Name    string
+		Members []string
+	}
+	// Default struct that represents a Defaults line, Binding is the @host, :user, !cmnd or >runas suffix
+	Default struct {
+		Binding  string
+		Settings []string
+	}
+	// Command struct that represents one command of a privilege with the Runas_Spec and tags applying to it
+	Command struct {
+		RunAs   string
+		Tags    []string
+		Command string
+	}
+	// Privilege struct that represents the hosts on which a list of commands may be run
+	Privilege struct {
+		Hosts    []string
+		Commands []Command
+	}
+	// UserSpec struct that represents a user specification line
+	UserSpec struct {
+		Users      []string
+		Privileges []Privilege
+	}
+	// Parser struct to handle parsing of a sudoers file and the files it includes
+	Parser struct {
+		zerolog.Logger
+		FileName  string
+		Aliases   []Alias
+		Defaults  []Default
+		UserSpecs []UserSpec
+		// Files lists every parsed file, Dirs every @includedir, both are watched for changes
+		Files []string
+		Dirs  []string
+	}
+)
+
+// Parse func that parses a sudoers file and follows its includes
+func (p *Parser) Parse() error {
+	return p.parse(p.FileName, 0)
+}
+
+func (p *Parser) parse(fileName string, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("too many levels of includes in %s", fileName)
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		p.Error().Err(err)
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			p.Error().Err(err)
+		}
+	}()
+	p.Files = append(p.Files, fileName)
+
+	scanner := bufio.NewScanner(file)
+	logical := ""
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasSuffix(line, "\\") {
+			logical += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		line, logical = strings.TrimSpace(logical+line), ""
+		if matches := include.FindStringSubmatch(line); matches != nil {
+			p.include(fileName, matches[1], strings.Trim(strings.TrimSpace(matches[2]), `"`), depth)
+			continue
+		}
+		if line = stripComment(line); line != "" {
+			p.line(line)
+		}
+	}
+	return scanner.Err()
+}
+
+// include follows an include directive, relative paths are relative to the including file
+func (p *Parser) include(from, directive, target string, depth int) {
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(from), target)
+	}
+	if directive == "include" {
+		if err := p.parse(target, depth+1); err != nil {
+			p.Warn().Err(err).Str("file", from).Str("include", target).Msg("failed to follow include")
+		}
+		return
+	}
+	p.Dirs = append(p.Dirs, target)
+	files, err := ioutil.ReadDir(target)
+	if err != nil {
+		p.Warn().Err(err).Str("file", from).Str("includedir", target).Msg("failed to follow includedir")
+		return
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		// like sudo, skip editor backups and package manager leftovers such as foo.rpmsave
+		if file.IsDir() || strings.HasSuffix(file.Name(), "~") || strings.Contains(file.Name(), ".") {
+			continue
+		}
+		names = append(names, file.Name())
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := p.parse(filepath.Join(target, name), depth+1); err != nil {
+			p.Warn().Err(err).Str("file", from).Str("include", name).Msg("failed to follow includedir")
+		}
+	}
+}
+
+// stripComment removes a trailing comment, a # followed by a digit is a uid and not a comment
+func stripComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		if line[i] != '#' || (i > 0 && line[i-1] != ' ' && line[i-1] != '\t' && line[i-1] != ',') {
+			continue
+		}
+		if i+1 < len(line) && line[i+1] >= '0' && line[i+1] <= '9' {
+			continue
+		}
+		return strings.TrimSpace(line[:i])
+	}
+	return line
+}
+
+func (p *Parser) line(line string) {
+	fields := strings.Fields(line)
+	switch {
+	case aliasKinds[fields[0]] != "":
+		p.alias(aliasKinds[fields[0]], strings.TrimSpace(strings.TrimPrefix(line, fields[0])))
+	case strings.HasPrefix(fields[0], "Defaults"):
+		p.defaults(fields[0], strings.TrimSpace(strings.TrimPrefix(line, fields[0])))
+	default:
+		p.userSpec(line)
+	}
+}
+
+func (p *Parser) alias(kind, definitions string) {
+	for _, definition := range split(definitions, ':') {
+		parts := strings.SplitN(definition, "=", 2)
+		if len(parts) != 2 {
+			p.Warn().Str("line", definition).Msg("malformed alias")
+			continue
+		}
+		p.Aliases = append(p.Aliases, Alias{
+			Kind:    kind,
+			Name:    strings.TrimSpace(parts[0]),
+			Members: list(parts[1]),
+		})
+	}
+}
+
+func (p *Parser) defaults(keyword, settings string) {
+	p.Defaults = append(p.Defaults, Default{
+		Binding:  strings.TrimPrefix(keyword, "Defaults"),
+		Settings: list(settings),
+	})
+}
+
+func (p *Parser) userSpec(line string) {
+	equal := strings.Index(line, "=")
+	if equal == -1 {
+		p.Warn().Str("line", line).Msg("malformed user specification")
+		return
+	}
+	who := strings.Fields(commaSpaces.ReplaceAllString(strings.TrimSpace(line[:equal]), ","))
+	if len(who) != 2 {
+		p.Warn().Str("line", line).Msg("malformed user specification")
+		return
+	}
+	spec := UserSpec{Users: strings.Split(who[0], ",")}
+	hosts := strings.Split(who[1], ",")
+	for i, privilege := range hostSplit(line[equal+1:]) {
+		if i > 0 {
+			parts := strings.SplitN(privilege, "=", 2)
+			if len(parts) != 2 {
+				p.Warn().Str("line", line).Msg("malformed user specification")
+				return
+			}
+			hosts, privilege = list(parts[0]), parts[1]
+		}
+		spec.Privileges = append(spec.Privileges, Privilege{Hosts: hosts, Commands: commands(privilege)})
+	}
+	p.UserSpecs = append(p.UserSpecs, spec)
+}
+
+// commands parses a Cmnd_Spec_List, the Runas_Spec and tags of a command apply to the following ones
+func commands(list string) (commands []Command) {
+	runAs, current := "", []string{}
+	for _, item := range split(list, ',') {
+		item = strings.TrimSpace(item)
+		if strings.HasPrefix(item, "(") {
+			if end := strings.Index(item, ")"); end != -1 {
+				runAs, item = strings.TrimSpace(item[1:end]), strings.TrimSpace(item[end+1:])
+			}
+		}
+		for {
+			if matches := option.FindString(item); matches != "" {
+				current = setTag(current, strings.TrimSpace(matches))
+				item = strings.TrimSpace(item[len(matches):])
+				continue
+			}
+			colon := strings.Index(item, ":")
+			if colon == -1 || !tags[item[:colon]] {
+				break
+			}
+			current = setTag(current, item[:colon])
+			item = strings.TrimSpace(item[colon+1:])
+		}
+		if item == "" {
+			continue
+		}
+		commands = append(commands, Command{
+			RunAs:   runAs,
+			Tags:    append([]string(nil), current...),
+			Command: strings.Join(strings.Fields(item), " "),
+		})
+	}
+	return commands
+}
+
+// setTag adds a tag, replacing its opposite or a previous value of the same option
+func setTag(current []string, tag string) []string {
+	name := strings.SplitN(tag, "=", 2)[0]
+	opposite := "NO" + name
+	if strings.HasPrefix(name, "NO") && tags[name[2:]] {
+		opposite = name[2:]
+	}
+	out := current[:0]
+	for _, existing := range current {
+		existingName := strings.SplitN(existing, "=", 2)[0]
+		if existingName != name && existingName != opposite {
+			out = append(out, existing)
+		}
+	}
+	return append(out, tag)
+}
+
+// hostSplit splits the privileges of a user specification, separated by colons which are not tag separators
+func hostSplit(spec string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(spec); i++ {
+		switch spec[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ':':
+			if depth != 0 {
+				continue
+			}
+			words := strings.Fields(spec[start:i])
+			if len(words) != 0 && tagged(words[len(words)-1]) {
+				continue
+			}
+			parts = append(parts, spec[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, spec[start:])
+}
+
+// tagged reports if the word before a colon is made of tags or digests only, chained ones as in NOPASSWD:SETENV:
+// included, the word may start with the Runas_Spec or the equal sign preceding them
+func tagged(word string) bool {
+	word = word[strings.LastIndexAny(word, "=)")+1:]
+	for _, part := range strings.Split(word, ":") {
+		if !tags[part] && !digests[part] {
+			return false
+		}
+	}
+	return true
+}
+
+// split splits on a separator which is neither escaped nor within parentheses or quotes
+func split(s string, separator byte) (parts []string) {
+	depth, quoted, start := 0, false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == '(' && !quoted:
+			depth++
+		case c == ')' && !quoted:
+			depth--
+		case c == separator && depth == 0 && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// list splits a comma separated list, trimming its entries
+func list(s string) (entries []string) {
+	for _, entry := range split(s, ',') {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,75 @@
+package systemd
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// Setting struct that represents one assignment of a section, an empty Value resets the values of the key
+type Setting struct {
+	Section string
+	Key     string
+	Value   string
+}
+
+// Parser struct to handle parsing of a unit file or a drop-in
+type Parser struct {
+	zerolog.Logger
+	FileName string
+	Settings []Setting
+}
+
+// Parse func that parses a unit file to collect its assignments, in order
+func (p *Parser) Parse() error {
+	file, err := os.Open(p.FileName)
+	if err != nil {
+		p.Error().Err(err)
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			p.Error().Err(err)
+		}
+	}()
+
+	section, continued := "", ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if continued != "" {
+			// comments within a continued line are skipped, as systemd does
+			if len(line) != 0 && (line[0] == '#' || line[0] == ';') {
+				continue
+			}
+			line = continued + " " + line
+			continued = ""
+		}
+		if strings.HasSuffix(line, `\`) {
+			continued = strings.TrimSpace(strings.TrimSuffix(line, `\`))
+			continue
+		}
+		switch {
+		case len(line) == 0 || line[0] == '#' || line[0] == ';':
+		case line[0] == '[' && line[len(line)-1] == ']':
+			section = line[1 : len(line)-1]
+		default:
+			separator := strings.IndexByte(line, '=')
+			if separator == -1 {
+				p.Warn().Str("file", p.FileName).Str("line", line).Msg("failed to parse unit assignment")
+				continue
+			}
+			p.Settings = append(p.Settings, Setting{
+				Section: section,
+				Key:     strings.TrimSpace(line[:separator]),
+				Value:   strings.TrimSpace(line[separator+1:]),
+			})
+		}
+	}
+	if continued != "" {
+		p.Warn().Str("file", p.FileName).Str("line", continued).Msg("unit file ends with a line continuation")
+	}
+	return scanner.Err()
+}
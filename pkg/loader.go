@@ -0,0 +1,204 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/afero"
+
+	"github.com/bookingcom/bpfink/pkg/lang/ldso"
+)
+
+// nolint:gochecknoglobals
+var sharedObject = regexp.MustCompile(`\.so(\.|$)`)
+
+type (
+	// Loader struct used to store what the dynamic loader loads: the preloaded libraries, the library search path
+	// and the libraries found there, with the SHA-256 of the preloaded ones and of those added while watched
+	Loader struct {
+		Preload    []string
+		SearchPath []string
+		Libraries  map[string]string
+	}
+	// LoaderListener struct used for filestream events.
+	LoaderListener struct {
+		zerolog.Logger
+		afero.Fs
+		// Preload is ld.so.preload, Config is ld.so.conf
+		Preload, Config string
+		// Dirs are the trusted library directories searched after the ones of ld.so.conf, i.e. /lib and /usr/lib
+		Dirs []string
+	}
+)
+
+// IsEmpty method to check if diff is empty
+func (l Loader) IsEmpty() bool {
+	return len(l.Preload) == 0 && len(l.SearchPath) == 0 && len(l.Libraries) == 0
+}
+
+// loaderChanges describes the changes of what the loader loads in plain words, i.e. "/tmp/x.so added to ld.so.preload".
+// The libraries of search path directories added or removed are left out, the directory change being reported.
+func loaderChanges(old, new Loader) (changes []string) {
+	add, del := ArrayDiff(old.Preload, new.Preload)
+	for _, library := range add {
+		changes = append(changes, fmt.Sprintf("%s added to ld.so.preload", library))
+	}
+	for _, library := range del {
+		changes = append(changes, fmt.Sprintf("%s removed from ld.so.preload", library))
+	}
+	add, del = ArrayDiff(old.SearchPath, new.SearchPath)
+	for _, dir := range add {
+		changes = append(changes, fmt.Sprintf("search path %s added", dir))
+	}
+	for _, dir := range del {
+		changes = append(changes, fmt.Sprintf("search path %s removed", dir))
+	}
+	searched, preloaded := Array2Set(old.SearchPath), Array2Set(new.Preload)
+	for library, digest := range new.Libraries {
+		_, wasSearched := searched[filepath.Dir(library)]
+		_, isPreloaded := preloaded[library]
+		switch previous, ok := old.Libraries[library]; {
+		case !ok && (wasSearched || isPreloaded):
+			changes = append(changes, fmt.Sprintf("library %s added, sha256 %s", library, digest))
+		case ok && previous != "" && previous != digest:
+			changes = append(changes, fmt.Sprintf("library %s modified, sha256 %s", library, digest))
+		}
+	}
+	searched = Array2Set(new.SearchPath)
+	for library := range old.Libraries {
+		_, isSearched := searched[filepath.Dir(library)]
+		if _, ok := new.Libraries[library]; !ok && isSearched {
+			changes = append(changes, fmt.Sprintf("library %s removed", library))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// NewLoaderListener function to create a new file event listener
+func NewLoaderListener(options ...func(*LoaderListener)) *LoaderListener {
+	ll := &LoaderListener{Logger: zerolog.Nop()}
+	for _, option := range options {
+		option(ll)
+	}
+	return ll
+}
+
+// parse reads ld.so.preload and ld.so.conf and lists the libraries of the search path. The preloaded libraries are hashed,
+// like the libraries hashed in the previous state or missing from it once it has been created, so the libraries in place
+// when bpfink starts are not all read. The files and directories to watch and the include directories are returned.
+func (ll *LoaderListener) parse(previous Loader) (Loader, []string, []string, error) {
+	ll.Debug().Msgf("parsing loader: %v %v", ll.Preload, ll.Config)
+	content := Loader{Libraries: map[string]string{}}
+	var files, dirs []string
+	if ll.Preload != "" {
+		files = append(files, ll.Preload)
+		if fileExists(ll.Preload) {
+			parser := ldso.PreloadParser{FileName: ll.Preload, Logger: ll.Logger}
+			if err := parser.Parse(); err != nil {
+				return Loader{}, nil, nil, err
+			}
+			content.Preload = parser.Libraries
+		}
+	}
+	if ll.Config != "" {
+		parser := ldso.Parser{FileName: ll.Config, Logger: ll.Logger}
+		if err := parser.Parse(); err != nil {
+			return Loader{}, nil, nil, err
+		}
+		content.SearchPath = parser.Dirs
+		files = append(files, parser.Files...)
+		dirs = append(dirs, parser.IncludeDirs...)
+	}
+	for _, dir := range ll.Dirs {
+		content.SearchPath = append(content.SearchPath, filepath.Clean(dir))
+	}
+	content.SearchPath = orderedClean(content.SearchPath)
+
+	for _, dir := range content.SearchPath {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if !IsNotExist(err) {
+				ll.Warn().Err(err).Str("dir", dir).Msg("failed to read library directory")
+			}
+			continue
+		}
+		files = append(files, dir)
+		for _, info := range infos {
+			if info.IsDir() || !sharedObject.MatchString(info.Name()) {
+				continue
+			}
+			library := filepath.Join(dir, info.Name())
+			digest, hashed := previous.Libraries[library]
+			if (hashed && digest != "") || (!hashed && !previous.IsEmpty()) {
+				digest = ll.digest(library)
+				files = append(files, library)
+			}
+			content.Libraries[library] = digest
+		}
+	}
+	for _, library := range content.Preload {
+		content.Libraries[library] = ll.digest(library)
+		files = append(files, library)
+	}
+	return content, files, dirs, nil
+}
+
+// digest returns the SHA-256 of a library, libraries which can not be read have none
+func (ll *LoaderListener) digest(library string) string {
+	content, err := ioutil.ReadFile(library)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			ll.Warn().Err(err).Str("file", library).Msg("failed to read library")
+		}
+		return ""
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// orderedClean removes the duplicates of a list, keeping the first occurrences in order
+func orderedClean(list []string) (out []string) {
+	seen := make(map[string]bool, len(list))
+	for _, item := range list {
+		if !seen[item] {
+			seen[item] = true
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// Files returns the loader configuration files, the library directories and the include directories
+func (ll *LoaderListener) Files() []string {
+	_, files, dirs, err := ll.parse(Loader{})
+	if err != nil {
+		return []string{ll.Preload, ll.Config}
+	}
+	return append(files, dirs...)
+}
+
+// Register method returns list of paths to files to be watched
+func (ll *LoaderListener) Register(includes []string) (out []string) {
+	var files []string
+	for _, file := range append([]string{ll.Preload, ll.Config}, includes...) {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	if base, ok := ll.Fs.(*afero.BasePathFs); ok {
+		for _, file := range files {
+			rpath, _ := base.RealPath(file)
+			out = append(out, rpath)
+		}
+		return ArrayClean(out)
+	}
+	return ArrayClean(files)
+}
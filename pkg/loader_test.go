@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoaderChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	preload, config, confDir := filepath.Join(dir, "ld.so.preload"), filepath.Join(dir, "ld.so.conf"), filepath.Join(dir, "ld.so.conf.d")
+	lib, local, opt := filepath.Join(dir, "lib"), filepath.Join(dir, "local"), filepath.Join(dir, "opt")
+	for _, d := range []string{confDir, lib, local, opt} {
+		if err := os.Mkdir(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(file, content string) {
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(config, "include ld.so.conf.d/*.conf\n")
+	write(filepath.Join(confDir, "local.conf"), "# libc default configuration\n"+local+"\n")
+	write(filepath.Join(lib, "libc.so.6"), "libc")
+	write(filepath.Join(lib, "README"), "not a library")
+	write(filepath.Join(local, "libfoo.so"), "foo")
+	listener := NewLoaderListener(func(l *LoaderListener) { l.Preload, l.Config, l.Dirs = preload, config, []string{lib} })
+
+	old, files, dirs, err := listener.parse(Loader{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{preload, config, filepath.Join(confDir, "local.conf"), local, lib}; !reflect.DeepEqual(files, want) {
+		t.Errorf("parse want files: %v, got: %v", want, files)
+	}
+	if !reflect.DeepEqual(dirs, []string{confDir}) {
+		t.Errorf("parse want include dirs: %v, got: %v", []string{confDir}, dirs)
+	}
+	want := Loader{
+		SearchPath: []string{local, lib},
+		Libraries:  map[string]string{filepath.Join(lib, "libc.so.6"): "", filepath.Join(local, "libfoo.so"): ""},
+	}
+	if !reflect.DeepEqual(old, want) {
+		t.Errorf("parse want: %v, got: %v", want, old)
+	}
+
+	write(preload, "# hook\n"+filepath.Join(local, "libfoo.so")+"\n")
+	write(filepath.Join(confDir, "opt.conf"), opt+"\n")
+	write(filepath.Join(opt, "libbar.so.1"), "bar")
+	write(filepath.Join(lib, "libevil.so"), "evil")
+	new, _, _, err := listener.parse(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+	changes := []string{
+		filepath.Join(local, "libfoo.so") + " added to ld.so.preload",
+		"library " + filepath.Join(lib, "libevil.so") + " added, sha256 " + digest("evil"),
+		"search path " + opt + " added",
+	}
+	if got := loaderChanges(old, new); !reflect.DeepEqual(got, changes) {
+		t.Errorf("loaderChanges want: %q, got: %q", changes, got)
+	}
+	if new.Libraries[filepath.Join(local, "libfoo.so")] != digest("foo") || new.Libraries[filepath.Join(opt, "libbar.so.1")] != digest("bar") {
+		t.Errorf("parse want the preloaded and new libraries hashed, got: %v", new.Libraries)
+	}
+
+	write(filepath.Join(lib, "libevil.so"), "eviler")
+	if err := os.Remove(filepath.Join(opt, "libbar.so.1")); err != nil {
+		t.Fatal(err)
+	}
+	newer, _, _, err := listener.parse(new)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes = []string{
+		"library " + filepath.Join(lib, "libevil.so") + " modified, sha256 " + digest("eviler"),
+		"library " + filepath.Join(opt, "libbar.so.1") + " removed",
+	}
+	if got := loaderChanges(new, newer); !reflect.DeepEqual(got, changes) {
+		t.Errorf("loaderChanges want: %q, got: %q", changes, got)
+	}
+}
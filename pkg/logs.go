@@ -15,12 +15,36 @@ type (
 	LogUsers Users
 	// LogUser type wrapper
 	LogUser User
+	// LogUserFindings type wrapper
+	LogUserFindings []UserFinding
+	// LogUserFinding type wrapper
+	LogUserFinding UserFinding
+	// LogAuthorizedKey type wrapper
+	LogAuthorizedKey AuthorizedKey
 	// LogAccess type wrapper
 	LogAccess Access
 	// LogGeneric type wrapper
 	LogGeneric GenericState
 	//LogGenericDiff type wrapper
 	LogGenericDiff GenericDiff
+	// LogAttributes type wrapper
+	LogAttributes Attributes
+	// LogAncestry type wrapper
+	LogAncestry Ancestry
+	// LogProcess type wrapper
+	LogProcess Process
+	// LogGroups type wrapper
+	LogGroups Groups
+	// LogGroup type wrapper
+	LogGroup Group
+	// LogSudoers type wrapper
+	LogSudoers Sudoers
+	// LogSSHDConfig type wrapper
+	LogSSHDConfig SSHDConfig
+	// LogCron type wrapper
+	LogCron Cron
+	// LogCronJob type wrapper
+	LogCronJob CronJob
 )
 
 // MarshalZerologObject method to wrap a logger
@@ -35,6 +59,9 @@ func (le LogEvent) MarshalZerologObject(e *zerolog.Event) {
 	e.Int32("mode", le.Mode)
 	e.Uint64("inode", le.Inode)
 	e.Str("command", le.Com)
+	if le.XAttr != "" {
+		e.Str("xattr", le.XAttr)
+	}
 }
 
 // MarshalZerologArray method to marshal array
@@ -48,17 +75,68 @@ func (lu LogUsers) MarshalZerologArray(a *zerolog.Array) {
 func (lu LogUser) MarshalZerologObject(e *zerolog.Event) {
 	e.Str("user", lu.Name)
 	e.Str("passwd", lu.Password)
-	var truncKeys []string
-	maxLength := 80
-	for _, key := range lu.Keys {
-		keyLen := len(key)
-		if keyLen < maxLength {
-			truncKeys = append(truncKeys, key)
-		} else {
-			truncKeys = append(truncKeys, key[keyLen-maxLength:])
+	e.Str("password_state", lu.PasswordState)
+	e.Str("uid", lu.UID)
+	e.Str("gid", lu.GID)
+	e.Str("home", lu.Home)
+	e.Str("shell", lu.Shell)
+	e.Dict("aging", zerolog.Dict().
+		Str("last_change", lu.Aging.LastChange).
+		Str("min_age", lu.Aging.MinAge).
+		Str("max_age", lu.Aging.MaxAge).
+		Str("warning", lu.Aging.Warning).
+		Str("inactivity", lu.Aging.Inactivity).
+		Str("expiration", lu.Aging.Expiration))
+	e.Array("keys", ZerologMarshalerArrayFunc(func(a *zerolog.Array) {
+		for _, key := range lu.Keys {
+			a.Object(LogAuthorizedKey(key))
+		}
+	}))
+	if len(lu.Dotfiles) != 0 {
+		dotfiles := zerolog.Dict()
+		for dotfile, digest := range lu.Dotfiles {
+			dotfiles.Str(dotfile, digest)
 		}
+		e.Dict("dotfiles", dotfiles)
+	}
+}
+
+// MarshalZerologArray method to marshal user findings
+func (lf LogUserFindings) MarshalZerologArray(a *zerolog.Array) {
+	for _, finding := range lf {
+		a.Object(LogUserFinding(finding))
 	}
-	e.Strs("keys", truncKeys)
+}
+
+// MarshalZerologObject method to marshal user finding object
+func (lf LogUserFinding) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("user", lf.User)
+	e.Str("type", lf.Type)
+	e.Str("detail", lf.Detail)
+}
+
+// MarshalZerologObject method to marshal authorized key object
+func (lk LogAuthorizedKey) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("type", lk.Type)
+	e.Str("fingerprint", lk.Fingerprint)
+	e.Strs("options", lk.Options)
+	e.Str("comment", lk.Comment)
+}
+
+// MarshalZerologArray method to marshal array
+func (lg LogGroups) MarshalZerologArray(a *zerolog.Array) {
+	for _, group := range lg {
+		a.Object(LogGroup(*group))
+	}
+}
+
+// MarshalZerologObject method to marshal group event
+func (lg LogGroup) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("group", lg.Name)
+	e.Str("gid", lg.GID)
+	e.Str("passwd", lg.Password)
+	e.Strs("members", lg.Members)
+	e.Strs("admins", lg.Admins)
 }
 
 // MarshalZerologObject method to marshal access object
@@ -70,10 +148,88 @@ func (la LogAccess) MarshalZerologObject(e *zerolog.Event) {
 // MarshalZerologObject method to marshal generic object
 func (lg LogGeneric) MarshalZerologObject(e *zerolog.Event) {
 	e.Hex("current", lg.current.Contents)
-	e.Hex("next", lg.next.Contents) // update to aes-gcm
+	e.Hex("next", lg.next.Contents)
+	digest := lg.next
+	if digest.IsEmpty() {
+		digest = lg.current
+	}
+	e.Str("digest", digest.Digest)
+	if digest.KeyID != "" {
+		e.Str("keyId", digest.KeyID)
+	}
 }
 
 //MarshalZerologObject method to marshal generic diff object
 func (lgd LogGenericDiff) MarshalZerologObject(e *zerolog.Event) {
 	e.Strs("Content", lgd.Rule)
 }
+
+// MarshalZerologObject method to marshal file attributes
+func (la LogAttributes) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("mode", Attributes(la).Permissions())
+	e.Uint32("uid", la.UID)
+	e.Uint32("gid", la.GID)
+	xattrs := zerolog.Dict()
+	for name, value := range la.XAttrs {
+		xattrs.Str(name, value)
+	}
+	e.Dict("xattrs", xattrs)
+}
+
+// MarshalZerologArray method to marshal a process chain
+func (la LogAncestry) MarshalZerologArray(a *zerolog.Array) {
+	for _, process := range la {
+		a.Object(LogProcess(process))
+	}
+}
+
+// MarshalZerologObject method to marshal a process
+func (lp LogProcess) MarshalZerologObject(e *zerolog.Event) {
+	e.Uint32("pid", lp.PID)
+	e.Str("comm", lp.Comm)
+	e.Str("exe", lp.Exe)
+	e.Str("cmdline", lp.Cmdline)
+}
+
+// MarshalZerologObject method to marshal sudoers object
+func (ls LogSudoers) MarshalZerologObject(e *zerolog.Event) {
+	aliases := zerolog.Dict()
+	for alias, members := range ls.Aliases {
+		aliases.Strs(alias, members)
+	}
+	e.Dict("aliases", aliases)
+	e.Strs("defaults", ls.Defaults)
+	rules := make([]string, 0, len(ls.Rules))
+	for _, rule := range ls.Rules {
+		rules = append(rules, rule.String())
+	}
+	e.Strs("rules", rules)
+}
+
+// MarshalZerologObject method to marshal the effective settings of sshd_config
+func (lc LogSSHDConfig) MarshalZerologObject(e *zerolog.Event) {
+	for _, setting := range lc.Settings {
+		e.Str(setting.Name(), setting.Value)
+	}
+}
+
+// MarshalZerologObject method to marshal cron object
+func (lc LogCron) MarshalZerologObject(e *zerolog.Event) {
+	e.Array("jobs", ZerologMarshalerArrayFunc(func(a *zerolog.Array) {
+		for _, job := range lc.Jobs {
+			a.Object(LogCronJob(job))
+		}
+	}))
+	e.Strs("env", lc.Env)
+}
+
+// MarshalZerologObject method to marshal a cron job
+func (lj LogCronJob) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("file", lj.File)
+	e.Str("user", lj.User)
+	e.Str("schedule", lj.Schedule)
+	e.Str("command", lj.Command)
+	if lj.Digest != "" {
+		e.Str("digest", lj.Digest)
+	}
+}
@@ -0,0 +1,225 @@
+package pkg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/afero"
+
+	"github.com/bookingcom/bpfink/pkg/lang/pam"
+)
+
+type (
+	// PAMEntry struct representing one line of a stack, Module is the service referenced by includes and substacks
+	PAMEntry struct {
+		Type, Control, Module string
+		Args                  []string
+	}
+	// PAM struct used to store the stack of every service file of pam.d, by service
+	PAM struct {
+		Stacks map[string][]PAMEntry
+	}
+	// PAMListener struct used for filestream events.
+	PAMListener struct {
+		zerolog.Logger
+		afero.Fs
+		pamDir string
+	}
+)
+
+// String renders an entry the way it reads in pam.d
+func (e PAMEntry) String() string {
+	return pam.Entry{Type: e.Type, Control: e.Control, Module: e.Module, Args: e.Args}.String()
+}
+
+// references returns the service an entry pulls in, if any
+func (e PAMEntry) references() (string, bool) {
+	if e.Type == pam.Include || e.Control == "include" || e.Control == "substack" {
+		return e.Module, true
+	}
+	return "", false
+}
+
+// IsEmpty method to check if diff is empty
+func (p PAM) IsEmpty() bool { return len(p.Stacks) == 0 }
+
+// includers returns the services including or stacking each service, directly or not
+func (p PAM) includers() map[string][]string {
+	direct := map[string][]string{}
+	for service, stack := range p.Stacks {
+		for _, entry := range stack {
+			if included, ok := entry.references(); ok {
+				direct[included] = append(direct[included], service)
+			}
+		}
+	}
+	includers := make(map[string][]string, len(direct))
+	for service := range direct {
+		seen := map[string]bool{service: true}
+		pending := append([]string(nil), direct[service]...)
+		for len(pending) != 0 {
+			includer := pending[0]
+			pending = pending[1:]
+			if seen[includer] {
+				continue
+			}
+			seen[includer] = true
+			includers[service] = append(includers[service], includer)
+			pending = append(pending, direct[includer]...)
+		}
+		sort.Strings(includers[service])
+	}
+	return includers
+}
+
+// pamStackChanges describes the entries inserted, removed or moved between two versions of a stack, by position from 1.
+// Entries kept in order are the longest common subsequence of both stacks, the others are moved when found on both sides.
+func pamStackChanges(old, new []PAMEntry) (changes []string) {
+	lcs := make([][]int, len(old)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(new)+1)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(new) - 1; j >= 0; j-- {
+			switch {
+			case old[i].String() == new[j].String():
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var removed, inserted []int
+	i, j := 0, 0
+	for i < len(old) || j < len(new) {
+		switch {
+		case i < len(old) && j < len(new) && old[i].String() == new[j].String():
+			i, j = i+1, j+1
+		case j < len(new) && (i == len(old) || lcs[i][j+1] >= lcs[i+1][j]):
+			inserted = append(inserted, j)
+			j++
+		default:
+			removed = append(removed, i)
+			i++
+		}
+	}
+	for _, to := range inserted {
+		moved := false
+		for k, from := range removed {
+			if old[from].String() == new[to].String() {
+				changes = append(changes, fmt.Sprintf("%s moved from %d to %d", new[to], from+1, to+1))
+				removed = append(removed[:k], removed[k+1:]...)
+				moved = true
+				break
+			}
+		}
+		if !moved {
+			changes = append(changes, fmt.Sprintf("%s inserted at %d", new[to], to+1))
+		}
+	}
+	for _, from := range removed {
+		changes = append(changes, fmt.Sprintf("%s removed from %d", old[from], from+1))
+	}
+	return changes
+}
+
+// pamChanges describes the changes of every stack, prefixed by their service, i.e. "sshd: auth sufficient pam_permit.so inserted at 1".
+// Services included by others list them, as their changes apply there too.
+func pamChanges(old, new PAM) (changes []string) {
+	services := map[string]bool{}
+	for service := range old.Stacks {
+		services[service] = true
+	}
+	for service := range new.Stacks {
+		services[service] = true
+	}
+	includers := new.includers()
+	for service := range services {
+		suffix := ""
+		if len(includers[service]) != 0 {
+			suffix = fmt.Sprintf(", included by %s", strings.Join(includers[service], ", "))
+		}
+		for _, change := range pamStackChanges(old.Stacks[service], new.Stacks[service]) {
+			changes = append(changes, fmt.Sprintf("%s: %s%s", service, change, suffix))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// PAMFileOpt function used to return metadata on a file
+func PAMFileOpt(fs afero.Fs, path string, logger zerolog.Logger) func(*PAMListener) {
+	return func(listener *PAMListener) {
+		listener.Fs = NewFile(func(file *File) {
+			file.Fs, file.Path, file.Logger = fs, path, logger
+		})
+		listener.pamDir = path
+		listener.Logger = logger
+	}
+}
+
+// NewPAMListener function to create a new file event listener
+func NewPAMListener(options ...func(*PAMListener)) *PAMListener {
+	pl := &PAMListener{Logger: zerolog.Nop()}
+	for _, option := range options {
+		option(pl)
+	}
+	return pl
+}
+
+// parse reads every service file of pam.d, the files are returned to be watched
+func (pl *PAMListener) parse() (PAM, []string, error) {
+	pl.Debug().Msgf("parsing pam.d: %v", pl.pamDir)
+	infos, err := ioutil.ReadDir(pl.pamDir)
+	if err != nil {
+		return PAM{}, nil, err
+	}
+	content := PAM{Stacks: map[string][]PAMEntry{}}
+	var files []string
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		file := filepath.Join(pl.pamDir, info.Name())
+		parser := pam.Parser{FileName: file, Logger: pl.Logger}
+		if err := parser.Parse(); err != nil {
+			pl.Warn().Err(err).Str("file", file).Msg("failed to parse pam service")
+			continue
+		}
+		files = append(files, file)
+		stack := make([]PAMEntry, 0, len(parser.Entries))
+		for _, entry := range parser.Entries {
+			stack = append(stack, PAMEntry{Type: entry.Type, Control: entry.Control, Module: entry.Module, Args: entry.Args})
+		}
+		content.Stacks[info.Name()] = stack
+	}
+	return content, files, nil
+}
+
+// Files returns pam.d and its service files
+func (pl *PAMListener) Files() []string {
+	_, files, err := pl.parse()
+	if err != nil {
+		return []string{pl.pamDir}
+	}
+	return append([]string{pl.pamDir}, files...)
+}
+
+// Register method returns list of paths to files to be watched
+func (pl *PAMListener) Register(includes []string) (out []string) {
+	files := append([]string{pl.pamDir}, includes...)
+	if base, ok := pl.Fs.(*afero.BasePathFs); ok {
+		for _, file := range files {
+			rpath, _ := base.RealPath(file)
+			out = append(out, rpath)
+		}
+		return ArrayClean(out)
+	}
+	return ArrayClean(files)
+}
@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPAMChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_pam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(service, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, service), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("common-auth", `# here are the per-package modules (the "Primary" block)
+auth	[success=1 default=ignore]	pam_unix.so nullok
+auth	requisite			pam_deny.so
+auth	required			pam_permit.so
+`)
+	write("sshd", "@include common-auth\naccount required pam_nologin.so\n-session optional pam_systemd.so\n")
+	write("sudo", "auth include sshd\n")
+	listener := NewPAMListener(func(l *PAMListener) { l.pamDir = dir })
+
+	old, files, err := listener.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, "common-auth"), filepath.Join(dir, "sshd"), filepath.Join(dir, "sudo")}; !reflect.DeepEqual(files, want) {
+		t.Errorf("parse want files: %v, got: %v", want, files)
+	}
+	var stack []string
+	for _, entry := range old.Stacks["common-auth"] {
+		stack = append(stack, entry.String())
+	}
+	if want := []string{
+		"auth [success=1 default=ignore] pam_unix.so nullok", "auth requisite pam_deny.so", "auth required pam_permit.so",
+	}; !reflect.DeepEqual(stack, want) {
+		t.Errorf("parse want stack: %q, got: %q", want, stack)
+	}
+
+	write("common-auth", `auth	sufficient	pam_permit.so
+auth	[success=1 \
+	default=ignore]	pam_unix.so nullok
+auth	required			pam_permit.so
+auth	optional	pam_exec.so quiet /tmp/.x/hook
+`)
+	write("sshd", "account required pam_nologin.so\n@include common-auth\n-session optional pam_systemd.so\n")
+	new, _, err := listener.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"common-auth: auth optional pam_exec.so quiet /tmp/.x/hook inserted at 4, included by sshd, sudo",
+		"common-auth: auth requisite pam_deny.so removed from 2, included by sshd, sudo",
+		"common-auth: auth sufficient pam_permit.so inserted at 1, included by sshd, sudo",
+		"sshd: account required pam_nologin.so moved from 2 to 1, included by sudo",
+	}
+	if changes := pamChanges(old, new); !reflect.DeepEqual(changes, want) {
+		t.Errorf("pamChanges want: %q, got: %q", want, changes)
+	}
+}
@@ -0,0 +1,285 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	prometheusCounter   = "counter"
+	prometheusGauge     = "gauge"
+	prometheusHistogram = "histogram"
+)
+
+// nolint:gochecknoglobals
+var (
+	// prometheusFamilies are the type and help of the series exposed on /metrics, by name
+	prometheusFamilies = map[string][2]string{
+		"bpfink_events_caught_total":               {prometheusCounter, "Events caught by the event source."},
+		"bpfink_events_lost_total":                 {prometheusCounter, "Events dropped by the kernel before reaching bpfink."},
+		"bpfink_events_suppressed_total":           {prometheusCounter, "Events matching a rule, by rule action."},
+		"bpfink_log_messages_total":                {prometheusCounter, "Log messages, by level."},
+		"bpfink_kprobe_hit_ratio":                  {prometheusGauge, "Ratio of kprobe hits over the last collection interval, by probe."},
+		"bpfink_kprobe_miss_ratio":                 {prometheusGauge, "Ratio of kprobe misses over the last collection interval, by probe."},
+		"bpfink_version_info":                      {prometheusGauge, "Version of bpfink running."},
+		"bpfink_installed":                         {prometheusGauge, "Set to 1 where bpfink is installed."},
+		"bpfink_bpf_object_info":                   {prometheusGauge, "BPF object loaded."},
+		"bpfink_webhook_queue_depth":               {prometheusGauge, "Alerts waiting to be delivered to webhooks."},
+		"bpfink_webhook_delivered_total":           {prometheusCounter, "Alerts delivered to webhooks."},
+		"bpfink_webhook_delivery_failures_total":   {prometheusCounter, "Failed webhook deliveries."},
+		"bpfink_webhook_dropped_total":             {prometheusCounter, "Alerts dropped by webhooks, undeliverable or sent while the queue was full."},
+		"bpfink_consumer_consume_seconds":          {prometheusHistogram, "Time taken by consumers to consume an event."},
+		"bpfink_consumer_parse_errors_total":       {prometheusCounter, "Consumer parse errors."},
+		"bpfink_consumer_changes_total":            {prometheusCounter, "Changes found by consumers."},
+		"bpfink_consumer_reloads_total":            {prometheusCounter, "Consumers reloaded after their files changed."},
+		"bpfink_consumer_save_failures_total":      {prometheusCounter, "Consumer states failing to be saved."},
+		"bpfink_consumer_file_missing_polls_total": {prometheusCounter, "Polls for watched files which do not exist."},
+		"bpfink_consumer_in_flight":                {prometheusGauge, "Events being consumed."},
+	}
+	// prometheusBuckets are the upper bounds of the histogram buckets, in seconds
+	prometheusBuckets      = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+type (
+	// Prometheus struct keeping the value of every series exposed on /metrics, the methods are no-ops on nil
+	Prometheus struct {
+		mux sync.Mutex
+		// series values and histograms by family name and rendered labels
+		series     map[string]map[string]float64
+		histograms map[string]map[string]*histogram
+	}
+	// histogram counts the observations in each of the prometheusBuckets
+	histogram struct {
+		buckets []uint64
+		sum     float64
+		count   uint64
+	}
+	// Health struct tracking the event source, i.e. the BPF module, and the watcher loop for /healthz and /readyz.
+	// The methods are no-ops on nil.
+	Health struct {
+		Backend  string
+		mux      sync.Mutex
+		loaded   bool
+		watching bool
+		// failed is set once the event source or the watcher loop stopped after running
+		failed bool
+	}
+	// HealthStatus is the JSON body of /healthz and /readyz
+	HealthStatus struct {
+		Backend  string `json:"backend"`
+		Loaded   bool   `json:"loaded"`
+		Watching bool   `json:"watching"`
+	}
+)
+
+// NewPrometheus function to create an empty set of series
+func NewPrometheus() *Prometheus {
+	return &Prometheus{series: make(map[string]map[string]float64), histograms: make(map[string]map[string]*histogram)}
+}
+
+// labels renders label pairs, i.e. role, web, host, web-1 as {role="web",host="web-1"}
+func labels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	rendered := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		rendered = append(rendered, fmt.Sprintf(`%s="%s"`, pairs[i], prometheusLabelEscaper.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(rendered, ",") + "}"
+}
+
+func (p *Prometheus) update(name string, value float64, add bool, pairs []string) {
+	if p == nil {
+		return
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	series, ok := p.series[name]
+	if !ok {
+		series = make(map[string]float64)
+		p.series[name] = series
+	}
+	key := labels(pairs...)
+	if add {
+		value += series[key]
+	}
+	series[key] = value
+}
+
+// Add method increments a counter, labels are given as name and value pairs
+func (p *Prometheus) Add(name string, value float64, pairs ...string) {
+	p.update(name, value, true, pairs)
+}
+
+// Set method sets a gauge, labels are given as name and value pairs
+func (p *Prometheus) Set(name string, value float64, pairs ...string) {
+	p.update(name, value, false, pairs)
+}
+
+// Observe method adds an observation to a histogram, labels are given as name and value pairs
+func (p *Prometheus) Observe(name string, value float64, pairs ...string) {
+	if p == nil {
+		return
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	histograms, ok := p.histograms[name]
+	if !ok {
+		histograms = make(map[string]*histogram)
+		p.histograms[name] = histograms
+	}
+	key := labels(pairs...)
+	h, ok := histograms[key]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(prometheusBuckets))}
+		histograms[key] = h
+	}
+	for i, bound := range prometheusBuckets {
+		if value <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// withLabel adds a label to rendered labels, i.e. le to the labels of a histogram series
+func withLabel(key, name, value string) string {
+	label := fmt.Sprintf(`%s="%s"`, name, value)
+	if key == "" {
+		return "{" + label + "}"
+	}
+	return strings.TrimSuffix(key, "}") + "," + label + "}"
+}
+
+func formatFloat(value float64) string { return strconv.FormatFloat(value, 'g', -1, 64) }
+
+// WriteTo method writes the series in the Prometheus text exposition format, sorted by name and labels
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	var output strings.Builder
+	if p != nil {
+		p.mux.Lock()
+		names := make([]string, 0, len(p.series)+len(p.histograms))
+		for name := range p.series {
+			names = append(names, name)
+		}
+		for name := range p.histograms {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			family := prometheusFamilies[name]
+			if family[0] == "" {
+				family[0] = "untyped"
+			}
+			fmt.Fprintf(&output, "# HELP %s %s\n# TYPE %s %s\n", name, family[1], name, family[0])
+			series := p.series[name]
+			keys := make([]string, 0, len(series))
+			for key := range series {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				fmt.Fprintf(&output, "%s%s %s\n", name, key, formatFloat(series[key]))
+			}
+			histograms := p.histograms[name]
+			keys = make([]string, 0, len(histograms))
+			for key := range histograms {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				h := histograms[key]
+				for i, bound := range prometheusBuckets {
+					fmt.Fprintf(&output, "%s_bucket%s %d\n", name, withLabel(key, "le", formatFloat(bound)), h.buckets[i])
+				}
+				fmt.Fprintf(&output, "%s_bucket%s %d\n", name, withLabel(key, "le", "+Inf"), h.count)
+				fmt.Fprintf(&output, "%s_sum%s %s\n%s_count%s %d\n", name, key, formatFloat(h.sum), name, key, h.count)
+			}
+		}
+		p.mux.Unlock()
+	}
+	written, err := io.WriteString(w, output.String())
+	return int64(written), err
+}
+
+// SetLoaded method records whether the event source is loaded
+func (h *Health) SetLoaded(loaded bool) {
+	if h == nil {
+		return
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.failed = h.failed || (h.loaded && !loaded)
+	h.loaded = loaded
+}
+
+// SetWatching method records whether the watcher loop is running
+func (h *Health) SetWatching(watching bool) {
+	if h == nil {
+		return
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.failed = h.failed || (h.watching && !watching)
+	h.watching = watching
+}
+
+// Status method returns the current status, whether the agent is healthy and whether it is ready.
+// The agent is healthy while starting up, and ready once the event source is loaded and the watcher loop running.
+func (h *Health) Status() (status HealthStatus, healthy, ready bool) {
+	if h == nil {
+		return status, true, true
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	status = HealthStatus{Backend: h.Backend, Loaded: h.loaded, Watching: h.watching}
+	return status, !h.failed, h.loaded && h.watching
+}
+
+// NewMetricsHandler function to serve /metrics, /healthz and /readyz
+func NewMetricsHandler(prometheus *Prometheus, health *Health) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = prometheus.WriteTo(w)
+	})
+	status := func(ready bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			status, healthy, isReady := health.Status()
+			w.Header().Set("Content-Type", "application/json")
+			if (ready && !isReady) || (!ready && !healthy) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			_ = json.NewEncoder(w).Encode(status)
+		}
+	}
+	mux.HandleFunc("/healthz", status(false))
+	mux.HandleFunc("/readyz", status(true))
+	return mux
+}
+
+// ServeMetrics function to listen on address and serve the metrics handler in the background
+func ServeMetrics(address string, prometheus *Prometheus, health *Health, logger zerolog.Logger) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := http.Serve(listener, NewMetricsHandler(prometheus, health)); err != nil {
+			logger.Error().Err(err).Str("address", address).Msg("metrics endpoint stopped")
+		}
+	}()
+	return nil
+}
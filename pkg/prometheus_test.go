@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	m := InitMetrics()
+	defer m.EveryMinuteRegister.UnregisterAll()
+	defer m.EveryHourRegister.UnregisterAll()
+	m.Prometheus = NewPrometheus()
+	m.RecordByEventsCaught()
+	m.RecordByEventsCaught()
+	m.RecordByLogTypes("warn")
+	m.RecordBySuppressedEvents(RuleIgnore)
+	m.RecordVersion("0.1.12")
+	m.RecordByInstalledHost()
+	m.RecordConsumeLatency(ConsumerUsers, "etc", 3*time.Millisecond)
+	m.RecordConsumeLatency(ConsumerUsers, "etc", 2*time.Second)
+
+	recorder := httptest.NewRecorder()
+	NewMetricsHandler(m.Prometheus, nil).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := ioutil.ReadAll(recorder.Body)
+	for _, want := range []string{
+		"# TYPE bpfink_events_caught_total counter\n",
+		`bpfink_events_caught_total{role="unknown_role",host="test_host"} 2` + "\n",
+		`bpfink_log_messages_total{role="unknown_role",host="test_host",level="warn"} 1` + "\n",
+		`bpfink_events_suppressed_total{role="unknown_role",host="test_host",action="ignore"} 1` + "\n",
+		"# TYPE bpfink_version_info gauge\n",
+		`bpfink_version_info{role="unknown_role",host="test_host",version="0.1.12"} 1` + "\n",
+		`bpfink_installed{role="unknown_role",host="test_host"} 1` + "\n",
+		"# TYPE bpfink_consumer_consume_seconds histogram\n",
+		`bpfink_consumer_consume_seconds_bucket{role="unknown_role",host="test_host",consumer="users",class="etc",le="0.0025"} 0` + "\n",
+		`bpfink_consumer_consume_seconds_bucket{role="unknown_role",host="test_host",consumer="users",class="etc",le="0.005"} 1` + "\n",
+		`bpfink_consumer_consume_seconds_bucket{role="unknown_role",host="test_host",consumer="users",class="etc",le="+Inf"} 2` + "\n",
+		`bpfink_consumer_consume_seconds_sum{role="unknown_role",host="test_host",consumer="users",class="etc"} 2.003` + "\n",
+		`bpfink_consumer_consume_seconds_count{role="unknown_role",host="test_host",consumer="users",class="etc"} 2` + "\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("want %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	health := &Health{Backend: "ebpf"}
+	handler := NewMetricsHandler(NewPrometheus(), health)
+	status := func(path string) (int, string) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code, strings.TrimSpace(recorder.Body.String())
+	}
+
+	for _, step := range []struct {
+		update          func()
+		healthz, readyz int
+		body            string
+	}{
+		{func() {}, http.StatusOK, http.StatusServiceUnavailable, `{"backend":"ebpf","loaded":false,"watching":false}`},
+		{func() { health.SetLoaded(true) }, http.StatusOK, http.StatusServiceUnavailable, `{"backend":"ebpf","loaded":true,"watching":false}`},
+		{func() { health.SetWatching(true) }, http.StatusOK, http.StatusOK, `{"backend":"ebpf","loaded":true,"watching":true}`},
+		{func() { health.SetWatching(false) }, http.StatusServiceUnavailable, http.StatusServiceUnavailable, `{"backend":"ebpf","loaded":true,"watching":false}`},
+	} {
+		step.update()
+		if code, body := status("/healthz"); code != step.healthz || body != step.body {
+			t.Errorf("/healthz want %d %s, got %d %s", step.healthz, step.body, code, body)
+		}
+		if code, _ := status("/readyz"); code != step.readyz {
+			t.Errorf("/readyz want %d, got %d", step.readyz, code)
+		}
+	}
+}
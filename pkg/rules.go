@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	// RuleIgnore action keeps the state up to date without reporting the change
+	RuleIgnore = "ignore"
+	// RuleTag action reports the change tagged, as a managed change by default
+	RuleTag = "tag"
+	// RuleLower action reports the change at info instead of warn level
+	RuleLower = "lower"
+
+	defaultRuleTag = "managed change"
+)
+
+type (
+	// Rule struct describing writers whose changes are expected, i.e. configuration management.
+	// All the criteria set must match, regular expressions are not anchored.
+	Rule struct {
+		Name   string
+		Comm   string   // command name of the writer
+		Exe    string   // executable path of the writer
+		Parent string   // command name or executable path of any ancestor of the writer
+		Path   string   // changed file
+		UID    []uint32 // uid of the writer
+		Action string
+		Tag    string
+
+		comm, exe, parent, path *regexp.Regexp
+	}
+	// Rules list of rules, the first matching rule applies
+	Rules []*Rule
+)
+
+// Compile method to validate a rule and compile its regular expressions
+func (r *Rule) Compile() (err error) {
+	switch r.Action {
+	case RuleIgnore, RuleLower:
+	case RuleTag:
+		if r.Tag == "" {
+			r.Tag = defaultRuleTag
+		}
+	default:
+		return fmt.Errorf("rule %q: unknown action %q, choices are %q, %q, %q", r.Name, r.Action, RuleIgnore, RuleTag, RuleLower)
+	}
+	for _, criterion := range []struct {
+		expr string
+		re   **regexp.Regexp
+	}{{r.Comm, &r.comm}, {r.Exe, &r.exe}, {r.Parent, &r.parent}, {r.Path, &r.path}} {
+		if criterion.expr == "" {
+			continue
+		}
+		if *criterion.re, err = regexp.Compile(criterion.expr); err != nil {
+			return fmt.Errorf("rule %q: %v", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// Match method to check if a rule applies to an event
+func (r *Rule) Match(e Event) bool {
+	writer := Process{Comm: Unknown}
+	if len(e.Ancestry) != 0 {
+		writer = e.Ancestry[0]
+	}
+	if r.comm != nil && !r.comm.MatchString(writer.Comm) {
+		return false
+	}
+	if r.exe != nil && (writer.Exe == "" || !r.exe.MatchString(writer.Exe)) {
+		return false
+	}
+	if r.path != nil && !r.path.MatchString(e.Path) {
+		return false
+	}
+	if len(r.UID) != 0 && !r.matchUID(e.UID) {
+		return false
+	}
+	if r.parent != nil && !r.matchParent(e.Ancestry) {
+		return false
+	}
+	return true
+}
+
+func (r *Rule) matchUID(uid uint32) bool {
+	for _, ruleUID := range r.UID {
+		if ruleUID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchParent(ancestry Ancestry) bool {
+	for i := 1; i < len(ancestry); i++ {
+		if r.parent.MatchString(ancestry[i].Comm) || (ancestry[i].Exe != "" && r.parent.MatchString(ancestry[i].Exe)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compile method to compile all rules
+func (rs Rules) Compile() error {
+	for _, rule := range rs {
+		if err := rule.Compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Match method returns the first rule matching an event, nil if none does
+func (rs Rules) Match(e Event) *Rule {
+	for _, rule := range rs {
+		if rule.Match(e) {
+			return rule
+		}
+	}
+	return nil
+}
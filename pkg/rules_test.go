@@ -0,0 +1,48 @@
+package pkg
+
+import "testing"
+
+func TestRulesMatch(t *testing.T) {
+	rules := Rules{
+		{Name: "puppet", Comm: "^puppet$", Exe: "^/opt/puppetlabs/", UID: []uint32{0}, Action: RuleTag},
+		{Name: "salt", Parent: "^/usr/bin/salt-minion$", Path: "^/etc/", Action: RuleLower},
+		{Name: "motd", Path: "^/etc/motd$", Action: RuleIgnore},
+	}
+	if err := rules.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	if rules[0].Tag != defaultRuleTag {
+		t.Errorf("tag rule want default tag %q, got: %q", defaultRuleTag, rules[0].Tag)
+	}
+
+	puppet := Process{PID: 10, Comm: "puppet", Exe: "/opt/puppetlabs/puppet/bin/ruby"}
+	minion := Process{PID: 2, Comm: "salt-minion", Exe: "/usr/bin/salt-minion"}
+	var matchEntries = []struct {
+		event Event
+		rule  string
+	}{
+		{Event{Path: "/etc/hosts", UID: 0, Ancestry: Ancestry{puppet}}, "puppet"},
+		{Event{Path: "/etc/hosts", UID: 1000, Ancestry: Ancestry{puppet}}, ""},
+		{Event{Path: "/etc/hosts", UID: 0, Ancestry: Ancestry{{PID: 11, Comm: "puppet", Exe: "/tmp/puppet"}}}, ""},
+		{Event{Path: "/etc/hosts", UID: 0, Ancestry: Ancestry{{PID: 3, Comm: "cp", Exe: "/usr/bin/cp"}, minion}}, "salt"},
+		{Event{Path: "/root/.bashrc", UID: 0, Ancestry: Ancestry{{PID: 3, Comm: "cp", Exe: "/usr/bin/cp"}, minion}}, ""},
+		{Event{Path: "/etc/hosts", UID: 0, Ancestry: Ancestry{minion}}, ""},
+		{Event{Path: "/etc/motd", UID: UnknownUID}, "motd"},
+	}
+	for _, entry := range matchEntries {
+		rule := rules.Match(entry.event)
+		switch {
+		case rule == nil && entry.rule != "":
+			t.Errorf("Match(%s, %s) want: %s, got none", entry.event.Path, entry.event.Ancestry, entry.rule)
+		case rule != nil && rule.Name != entry.rule:
+			t.Errorf("Match(%s, %s) want: %q, got: %s", entry.event.Path, entry.event.Ancestry, entry.rule, rule.Name)
+		}
+	}
+
+	if err := (Rules{{Name: "bad", Action: "drop"}}).Compile(); err == nil {
+		t.Error("Compile want an error on unknown action")
+	}
+	if err := (Rules{{Name: "bad", Comm: "(", Action: RuleIgnore}}).Compile(); err == nil {
+		t.Error("Compile want an error on invalid regexp")
+	}
+}
@@ -0,0 +1,325 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultSyslogSocket   = "/dev/log"
+	defaultJournaldSocket = "/run/systemd/journal/socket"
+	defaultSinkTag        = "bpfink"
+	defaultFileBackups    = 5
+	// syslogFacilityAuthPriv is the facility of security messages which should not be world readable
+	syslogFacilityAuthPriv = 10
+)
+
+// nolint:gochecknoglobals
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "authpriv": 10,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+type (
+	// FileSink struct writing alerts as lines to a file rotated by size and age, JSON ones unless an Encoder is set.
+	// Rotated files are suffixed .1, the most recent, to .Backups, the oldest.
+	FileSink struct {
+		Path    string
+		MaxSize int64         // bytes, 0 disables size rotation
+		MaxAge  time.Duration // 0 disables age rotation
+		Backups int
+		Encoder Encoder
+		mux     sync.Mutex
+		file    *os.File
+		size    int64
+		opened  time.Time
+	}
+	// SyslogSink struct sending alerts as RFC 5424 messages to a local syslog socket,
+	// the message being the JSON alert unless an Encoder is set
+	SyslogSink struct {
+		Socket   string
+		Facility int
+		Tag      string
+		Encoder  Encoder
+		mux      sync.Mutex
+		conn     net.Conn
+		hostname string
+	}
+	// JournaldSink struct sending alerts with the native journal protocol, each alert field becoming a journal field
+	JournaldSink struct {
+		Socket string
+		Tag    string
+		mux    sync.Mutex
+		conn   net.Conn
+	}
+)
+
+// NewFileSink function to create a file sink, the file is opened in append mode
+func NewFileSink(options ...func(*FileSink)) (*FileSink, error) {
+	fs := &FileSink{Backups: defaultFileBackups}
+	for _, option := range options {
+		option(fs)
+	}
+	return fs, fs.open()
+}
+
+func (fs *FileSink) open() error {
+	file, err := os.OpenFile(fs.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	fs.file, fs.size, fs.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+// rotate shifts the backups, dropping the oldest one, and opens a new file
+func (fs *FileSink) rotate() error {
+	if err := fs.file.Close(); err != nil {
+		return err
+	}
+	if fs.Backups == 0 {
+		if err := os.Remove(fs.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return fs.open()
+	}
+	for i := fs.Backups - 1; i > 0; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", fs.Path, i), fmt.Sprintf("%s.%d", fs.Path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(fs.Path, fs.Path+".1"); err != nil {
+		return err
+	}
+	return fs.open()
+}
+
+// Send method appends an alert, rotating the file first when it would exceed MaxSize or is older than MaxAge
+func (fs *FileSink) Send(alert Alert) error {
+	line, err := encode(fs.Encoder, alert)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+	if fs.size != 0 && ((fs.MaxSize != 0 && fs.size+int64(len(line)) > fs.MaxSize) ||
+		(fs.MaxAge != 0 && time.Since(fs.opened) >= fs.MaxAge)) {
+		if err := fs.rotate(); err != nil {
+			return err
+		}
+	}
+	written, err := fs.file.Write(line)
+	fs.size += int64(written)
+	return err
+}
+
+// Close method closes the file
+func (fs *FileSink) Close() error {
+	fs.mux.Lock()
+	defer fs.mux.Unlock()
+	return fs.file.Close()
+}
+
+// SyslogFacility returns the code of a syslog facility name, i.e. authpriv or local0
+func SyslogFacility(name string) (int, error) {
+	facility, ok := syslogFacilities[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility %q", name)
+	}
+	return facility, nil
+}
+
+// syslogSeverity maps a level to its syslog severity
+func syslogSeverity(level zerolog.Level) int {
+	switch level {
+	case zerolog.PanicLevel:
+		return 1
+	case zerolog.FatalLevel:
+		return 2
+	case zerolog.ErrorLevel:
+		return 3
+	case zerolog.WarnLevel:
+		return 4
+	case zerolog.InfoLevel:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// dialLocal connects to a local datagram socket, or to a stream socket as rsyslog may provide
+func dialLocal(socket string) (net.Conn, error) {
+	conn, err := net.Dial("unixgram", socket)
+	if err == nil {
+		return conn, nil
+	}
+	return net.Dial("unix", socket)
+}
+
+// NewSyslogSink function to create a syslog sink, the socket is connected on first use
+func NewSyslogSink(options ...func(*SyslogSink)) *SyslogSink {
+	ss := &SyslogSink{Socket: defaultSyslogSocket, Facility: syslogFacilityAuthPriv, Tag: defaultSinkTag}
+	for _, option := range options {
+		option(ss)
+	}
+	ss.hostname, _ = os.Hostname()
+	if ss.hostname == "" {
+		ss.hostname = "-"
+	}
+	return ss
+}
+
+// Format method renders an alert as an RFC 5424 message without structured data
+func (ss *SyslogSink) Format(alert Alert) ([]byte, error) {
+	message, err := encode(ss.Encoder, alert)
+	if err != nil {
+		return nil, err
+	}
+	header := fmt.Sprintf("<%d>1 %s %s %s %d - - ", ss.Facility*8+syslogSeverity(alert.Level),
+		alert.Time.Format("2006-01-02T15:04:05.000000Z07:00"), ss.hostname, ss.Tag, os.Getpid())
+	return append([]byte(header), message...), nil
+}
+
+// Send method writes an alert to the socket, reconnecting once if syslog was restarted
+func (ss *SyslogSink) Send(alert Alert) error {
+	message, err := ss.Format(alert)
+	if err != nil {
+		return err
+	}
+	ss.mux.Lock()
+	defer ss.mux.Unlock()
+	return sendLocal(&ss.conn, ss.Socket, message)
+}
+
+// Close method closes the socket
+func (ss *SyslogSink) Close() error {
+	ss.mux.Lock()
+	defer ss.mux.Unlock()
+	return closeLocal(&ss.conn)
+}
+
+// sendLocal writes a message to a local socket, stream sockets get newline framed messages
+func sendLocal(conn *net.Conn, socket string, message []byte) (err error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		if *conn == nil {
+			if *conn, err = dialLocal(socket); err != nil {
+				return err
+			}
+		}
+		framed := message
+		if (*conn).LocalAddr().Network() == "unix" {
+			framed = append(message, '\n')
+		}
+		if _, err = (*conn).Write(framed); err == nil {
+			return nil
+		}
+		_ = closeLocal(conn)
+	}
+	return err
+}
+
+func closeLocal(conn *net.Conn) error {
+	if *conn == nil {
+		return nil
+	}
+	err := (*conn).Close()
+	*conn = nil
+	return err
+}
+
+// NewJournaldSink function to create a journald sink, the socket is connected on first use
+func NewJournaldSink(options ...func(*JournaldSink)) *JournaldSink {
+	js := &JournaldSink{Socket: defaultJournaldSocket, Tag: defaultSinkTag}
+	for _, option := range options {
+		option(js)
+	}
+	return js
+}
+
+// journalField turns an alert key into a journal field name, i.e. processName into BPFINK_PROCESS_NAME
+func journalField(key string) string {
+	var name strings.Builder
+	name.WriteString("BPFINK_")
+	for i, r := range key {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			if i > 0 {
+				name.WriteRune('_')
+			}
+			name.WriteRune(r)
+		case r >= 'a' && r <= 'z':
+			name.WriteRune(r - 'a' + 'A')
+		case r >= '0' && r <= '9':
+			name.WriteRune(r)
+		default:
+			name.WriteRune('_')
+		}
+	}
+	return name.String()
+}
+
+// Format method serializes an alert with the native journal protocol, values spanning lines are length prefixed
+func (js *JournaldSink) Format(alert Alert) ([]byte, error) {
+	var buffer bytes.Buffer
+	write := func(name, value string) {
+		if !strings.Contains(value, "\n") {
+			buffer.WriteString(name + "=" + value + "\n")
+			return
+		}
+		buffer.WriteString(name + "\n")
+		_ = binary.Write(&buffer, binary.LittleEndian, uint64(len(value)))
+		buffer.WriteString(value + "\n")
+	}
+	write("MESSAGE", alert.Message)
+	write("PRIORITY", fmt.Sprintf("%d", syslogSeverity(alert.Level)))
+	write("SYSLOG_IDENTIFIER", js.Tag)
+	for key, value := range alert.Fields {
+		if key == zerolog.MessageFieldName {
+			continue
+		}
+		switch value := value.(type) {
+		case string:
+			write(journalField(key), value)
+		default:
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			write(journalField(key), string(encoded))
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+// Send method writes an alert to the journal socket
+func (js *JournaldSink) Send(alert Alert) error {
+	message, err := js.Format(alert)
+	if err != nil {
+		return err
+	}
+	js.mux.Lock()
+	defer js.mux.Unlock()
+	return sendLocal(&js.conn, js.Socket, message)
+}
+
+// Close method closes the socket
+func (js *JournaldSink) Close() error {
+	js.mux.Lock()
+	defer js.mux.Unlock()
+	return closeLocal(&js.conn)
+}
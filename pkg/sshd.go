@@ -0,0 +1,182 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/afero"
+
+	"github.com/bookingcom/bpfink/pkg/lang/sshdconfig"
+)
+
+// nolint:gochecknoglobals
+var (
+	// DefaultSSHDCritical are the settings whose changes are reported at error level, Match stands for Match blocks
+	DefaultSSHDCritical = []string{
+		"PermitRootLogin", "PasswordAuthentication", "PermitEmptyPasswords", "PubkeyAuthentication",
+		"KbdInteractiveAuthentication", "ChallengeResponseAuthentication", "AuthenticationMethods", "UsePAM",
+		"AuthorizedKeysFile", "AuthorizedKeysCommand", "AuthorizedKeysCommandUser", "AuthorizedPrincipalsFile",
+		"AuthorizedPrincipalsCommand", "TrustedUserCAKeys", "PermitUserEnvironment", "ForceCommand",
+		"AllowUsers", "AllowGroups", "DenyUsers", "DenyGroups", "Match",
+	}
+	// sshdAccumulated are the keywords whose occurrences add up, sshd keeps the first value of the others
+	sshdAccumulated = map[string]bool{
+		"acceptenv": true, "allowgroups": true, "allowusers": true, "denygroups": true, "denyusers": true,
+		"hostkey": true, "hostcertificate": true, "listenaddress": true, "port": true, "setenv": true, "subsystem": true,
+	}
+)
+
+type (
+	// SSHDSetting struct representing the effective value of a keyword, globally or in a Match block
+	SSHDSetting struct {
+		Match   string // criteria of the Match block, empty for global settings
+		Keyword string // as first written, i.e. PermitRootLogin
+		Value   string
+	}
+	// SSHDConfig struct used to store the effective settings of sshd_config and the files it includes
+	SSHDConfig struct {
+		Settings map[string]SSHDSetting // by Match criteria and lower cased keyword
+		Matches  []string
+	}
+	// SSHDListener struct used for filestream events.
+	SSHDListener struct {
+		zerolog.Logger
+		afero.Fs
+		sshdConfig string
+		// Critical settings raise the level of changes to error, DefaultSSHDCritical when empty
+		Critical []string
+	}
+)
+
+// Name renders where a setting applies, i.e. "Match User bob: PasswordAuthentication"
+func (s SSHDSetting) Name() string {
+	if s.Match == "" {
+		return s.Keyword
+	}
+	return fmt.Sprintf("Match %s: %s", s.Match, s.Keyword)
+}
+
+// IsEmpty method to check if diff is empty
+func (c SSHDConfig) IsEmpty() bool { return len(c.Settings) == 0 && len(c.Matches) == 0 }
+
+// sshdSettingKey is the key of a setting in SSHDConfig.Settings
+func sshdSettingKey(match, keyword string) string {
+	if match == "" {
+		return strings.ToLower(keyword)
+	}
+	return fmt.Sprintf("Match %s: %s", match, strings.ToLower(keyword))
+}
+
+// sshdChanges describes the changes of effective settings in plain words, i.e. "PermitRootLogin changed from no to yes".
+// The critical settings changed are returned along.
+func sshdChanges(old, new SSHDConfig, critical []string) (changes, changedCritical []string) {
+	isCritical := make(map[string]string, len(critical))
+	for _, keyword := range critical {
+		isCritical[strings.ToLower(keyword)] = keyword
+	}
+	flag := func(keyword string) {
+		if name, ok := isCritical[strings.ToLower(keyword)]; ok {
+			changedCritical = append(changedCritical, name)
+		}
+	}
+	for key, setting := range new.Settings {
+		switch previous, ok := old.Settings[key]; {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("%s set to %s", setting.Name(), setting.Value))
+		case previous.Value != setting.Value:
+			changes = append(changes, fmt.Sprintf("%s changed from %s to %s", setting.Name(), previous.Value, setting.Value))
+		default:
+			continue
+		}
+		flag(setting.Keyword)
+	}
+	for key, setting := range old.Settings {
+		if _, ok := new.Settings[key]; !ok {
+			changes = append(changes, fmt.Sprintf("%s unset, was %s", setting.Name(), setting.Value))
+			flag(setting.Keyword)
+		}
+	}
+	add, del := ArrayDiff(old.Matches, new.Matches)
+	for _, match := range add {
+		changes = append(changes, fmt.Sprintf("Match %s added", match))
+	}
+	for _, match := range del {
+		changes = append(changes, fmt.Sprintf("Match %s removed", match))
+	}
+	if len(add) != 0 || len(del) != 0 {
+		flag("Match")
+	}
+	changedCritical = ArrayClean(changedCritical)
+	sort.Strings(changes)
+	sort.Strings(changedCritical)
+	return changes, changedCritical
+}
+
+// SSHDFileOpt function used to return metadata on a file
+func SSHDFileOpt(fs afero.Fs, path string, logger zerolog.Logger) func(*SSHDListener) {
+	return func(listener *SSHDListener) {
+		listener.Fs = NewFile(func(file *File) {
+			file.Fs, file.Path, file.Logger = fs, path, logger
+		})
+		listener.sshdConfig = path
+		listener.Logger = logger
+	}
+}
+
+// NewSSHDListener function to create a new file event listener
+func NewSSHDListener(options ...func(*SSHDListener)) *SSHDListener {
+	sl := &SSHDListener{Logger: zerolog.Nop()}
+	for _, option := range options {
+		option(sl)
+	}
+	if len(sl.Critical) == 0 {
+		sl.Critical = DefaultSSHDCritical
+	}
+	return sl
+}
+
+// parse reads sshd_config and everything it includes, the included files and Include directories are returned to be watched
+func (sl *SSHDListener) parse() (SSHDConfig, []string, []string, error) {
+	sl.Debug().Msgf("parsing sshd_config: %v", sl.sshdConfig)
+	parser := sshdconfig.Parser{FileName: sl.sshdConfig, Logger: sl.Logger}
+	if err := parser.Parse(); err != nil {
+		return SSHDConfig{}, nil, nil, err
+	}
+	config := SSHDConfig{Settings: map[string]SSHDSetting{}, Matches: ArrayClean(parser.Matches)}
+	for _, directive := range parser.Directives {
+		key, value := sshdSettingKey(directive.Match, directive.Keyword), strings.Join(directive.Args, " ")
+		setting, ok := config.Settings[key]
+		switch {
+		case !ok:
+			config.Settings[key] = SSHDSetting{Match: directive.Match, Keyword: directive.Name, Value: value}
+		case sshdAccumulated[directive.Keyword]:
+			setting.Value += ", " + value
+			config.Settings[key] = setting
+		}
+	}
+	return config, parser.Files, ArrayClean(parser.Dirs), nil
+}
+
+// Files returns sshd_config and the files and directories it includes
+func (sl *SSHDListener) Files() []string {
+	_, files, dirs, err := sl.parse()
+	if err != nil {
+		return []string{sl.sshdConfig}
+	}
+	return append(files, dirs...)
+}
+
+// Register method returns list of paths to files to be watched
+func (sl *SSHDListener) Register(includes []string) (out []string) {
+	files := append([]string{sl.sshdConfig}, includes...)
+	if base, ok := sl.Fs.(*afero.BasePathFs); ok {
+		for _, file := range files {
+			rpath, _ := base.RealPath(file)
+			out = append(out, rpath)
+		}
+		return ArrayClean(out)
+	}
+	return ArrayClean(files)
+}
@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSSHDChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_sshd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sshdConfig, includeDir := filepath.Join(dir, "sshd_config"), filepath.Join(dir, "sshd_config.d")
+	if err := os.Mkdir(includeDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	write := func(file, content string) {
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(sshdConfig, `# comment
+Include sshd_config.d/*.conf
+permitrootlogin no
+PermitRootLogin yes
+AllowUsers alice
+AllowUsers bob
+Subsystem sftp "/usr/lib/openssh/sftp-server -l INFO"
+Match User carol
+	PasswordAuthentication=yes
+`)
+	write(filepath.Join(includeDir, "ignored.bak"), "PermitRootLogin yes\n")
+	listener := NewSSHDListener(func(l *SSHDListener) { l.sshdConfig = sshdConfig })
+
+	old, files, dirs, err := listener.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, []string{sshdConfig}) || !reflect.DeepEqual(dirs, []string{includeDir}) {
+		t.Errorf("parse want includes: %v %v, got: %v %v", []string{sshdConfig}, []string{includeDir}, files, dirs)
+	}
+	wantSettings := map[string]SSHDSetting{
+		"permitrootlogin": {Keyword: "permitrootlogin", Value: "no"},
+		"allowusers":      {Keyword: "AllowUsers", Value: "alice, bob"},
+		"subsystem":       {Keyword: "Subsystem", Value: "sftp /usr/lib/openssh/sftp-server -l INFO"},
+		"Match User carol: passwordauthentication": {Match: "User carol", Keyword: "PasswordAuthentication", Value: "yes"},
+	}
+	if !reflect.DeepEqual(old.Settings, wantSettings) || !reflect.DeepEqual(old.Matches, []string{"User carol"}) {
+		t.Errorf("parse want settings: %v, got: %v %v", wantSettings, old.Settings, old.Matches)
+	}
+
+	write(filepath.Join(includeDir, "hardening.conf"), `PasswordAuthentication no
+X11Forwarding no
+Match Address 10.0.0.0/8
+	PermitRootLogin prohibit-password
+`)
+	write(sshdConfig, `Include sshd_config.d/*.conf
+PermitRootLogin without-password
+AllowUsers alice
+AllowUsers bob
+Subsystem sftp "/usr/lib/openssh/sftp-server -l INFO"
+`)
+	new, files, _, err := listener.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[1] != filepath.Join(includeDir, "hardening.conf") {
+		t.Errorf("parse want the included file followed, got: %v", files)
+	}
+	changes, critical := sshdChanges(old, new, listener.Critical)
+	want := []string{
+		"Match Address 10.0.0.0/8 added",
+		"Match Address 10.0.0.0/8: PermitRootLogin set to prohibit-password",
+		"Match User carol removed",
+		"Match User carol: PasswordAuthentication unset, was yes",
+		"PasswordAuthentication set to no",
+		"PermitRootLogin changed from no to without-password",
+		"X11Forwarding set to no",
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("sshdChanges want: %q, got: %q", want, changes)
+	}
+	if want := []string{"Match", "PasswordAuthentication", "PermitRootLogin"}; !reflect.DeepEqual(critical, want) {
+		t.Errorf("sshdChanges want critical: %q, got: %q", want, critical)
+	}
+	if _, critical := sshdChanges(old, new, []string{"x11forwarding"}); !reflect.DeepEqual(critical, []string{"x11forwarding"}) {
+		t.Errorf("sshdChanges want the configured critical settings, got: %q", critical)
+	}
+}
@@ -0,0 +1,211 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/afero"
+
+	"github.com/bookingcom/bpfink/pkg/lang/sudoers"
+)
+
+type (
+	// SudoersRule struct representing one command a user may run, on a host, as a run as user
+	SudoersRule struct {
+		User, Host, RunAs string
+		Tags              []string
+		Command           string
+	}
+	// Sudoers struct used to store the content of sudoers and the files it includes
+	Sudoers struct {
+		Aliases  map[string][]string // members by "Kind Name", i.e. "User_Alias ADMINS"
+		Defaults []string            // one entry per setting, i.e. "Defaults:alice !requiretty"
+		Rules    []SudoersRule
+	}
+	// SudoersListener struct used for filestream events.
+	SudoersListener struct {
+		zerolog.Logger
+		afero.Fs
+		sudoers string
+	}
+)
+
+// Privilege renders what a rule grants, run as root and on any host being implied
+func (r SudoersRule) Privilege() string {
+	privilege := ""
+	for _, tag := range r.Tags {
+		if strings.Contains(tag, "=") {
+			privilege += tag + " "
+		} else {
+			privilege += tag + ": "
+		}
+	}
+	privilege += r.Command
+	if r.RunAs != sudoers.DefaultRunAs {
+		privilege += " as " + r.RunAs
+	}
+	if r.Host != "ALL" {
+		privilege += " on " + r.Host
+	}
+	return privilege
+}
+
+// String renders a rule the way it reads in sudoers
+func (r SudoersRule) String() string {
+	tags := ""
+	for _, tag := range r.Tags {
+		if strings.Contains(tag, "=") {
+			tags += tag + " "
+		} else {
+			tags += tag + ": "
+		}
+	}
+	return fmt.Sprintf("%s %s = (%s) %s%s", r.User, r.Host, r.RunAs, tags, r.Command)
+}
+
+// IsEmpty method to check if diff is empty
+func (s Sudoers) IsEmpty() bool {
+	return len(s.Aliases) == 0 && len(s.Defaults) == 0 && len(s.Rules) == 0
+}
+
+func (s Sudoers) rules() map[string]SudoersRule {
+	rules := make(map[string]SudoersRule, len(s.Rules))
+	for _, rule := range s.Rules {
+		rules[rule.String()] = rule
+	}
+	return rules
+}
+
+func sudoersDiff(old, new Sudoers) (add, del Sudoers) {
+	add, del = Sudoers{Aliases: map[string][]string{}}, Sudoers{Aliases: map[string][]string{}}
+	for alias, members := range new.Aliases {
+		if added, _ := ArrayDiff(old.Aliases[alias], members); len(added) != 0 {
+			add.Aliases[alias] = added
+		}
+	}
+	for alias, members := range old.Aliases {
+		if _, removed := ArrayDiff(members, new.Aliases[alias]); len(removed) != 0 {
+			del.Aliases[alias] = removed
+		}
+	}
+	add.Defaults, del.Defaults = ArrayDiff(old.Defaults, new.Defaults)
+	oldRules, newRules := old.rules(), new.rules()
+	for key, rule := range newRules {
+		if _, ok := oldRules[key]; !ok {
+			add.Rules = append(add.Rules, rule)
+		}
+	}
+	for key, rule := range oldRules {
+		if _, ok := newRules[key]; !ok {
+			del.Rules = append(del.Rules, rule)
+		}
+	}
+	return
+}
+
+// sudoersChanges describes a diff in plain words, i.e. "alice gained NOPASSWD: ALL"
+func sudoersChanges(add, del Sudoers) (changes []string) {
+	for _, rule := range add.Rules {
+		changes = append(changes, fmt.Sprintf("%s gained %s", rule.User, rule.Privilege()))
+	}
+	for _, rule := range del.Rules {
+		changes = append(changes, fmt.Sprintf("%s lost %s", rule.User, rule.Privilege()))
+	}
+	for alias, members := range add.Aliases {
+		changes = append(changes, fmt.Sprintf("%s gained %s", alias, strings.Join(members, ", ")))
+	}
+	for alias, members := range del.Aliases {
+		changes = append(changes, fmt.Sprintf("%s lost %s", alias, strings.Join(members, ", ")))
+	}
+	for _, setting := range add.Defaults {
+		changes = append(changes, fmt.Sprintf("%s set", setting))
+	}
+	for _, setting := range del.Defaults {
+		changes = append(changes, fmt.Sprintf("%s unset", setting))
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// SudoersFileOpt function used to return metadata on a file
+func SudoersFileOpt(fs afero.Fs, path string, logger zerolog.Logger) func(*SudoersListener) {
+	return func(listener *SudoersListener) {
+		listener.Fs = NewFile(func(file *File) {
+			file.Fs, file.Path, file.Logger = fs, path, logger
+		})
+		listener.sudoers = path
+		listener.Logger = logger
+	}
+}
+
+// NewSudoersListener function to create a new file event listener
+func NewSudoersListener(options ...func(*SudoersListener)) *SudoersListener {
+	sl := &SudoersListener{Logger: zerolog.Nop()}
+	for _, option := range options {
+		option(sl)
+	}
+	return sl
+}
+
+// parse reads sudoers and everything it includes, the included files and directories are returned to be watched
+func (sl *SudoersListener) parse() (Sudoers, []string, []string, error) {
+	sl.Debug().Msgf("parsing sudoers: %v", sl.sudoers)
+	parser := sudoers.Parser{FileName: sl.sudoers, Logger: sl.Logger}
+	if err := parser.Parse(); err != nil {
+		return Sudoers{}, nil, nil, err
+	}
+	content := Sudoers{Aliases: map[string][]string{}}
+	for _, alias := range parser.Aliases {
+		key := fmt.Sprintf("%s %s", alias.Kind, alias.Name)
+		content.Aliases[key] = append(content.Aliases[key], alias.Members...)
+	}
+	for _, defaults := range parser.Defaults {
+		for _, setting := range defaults.Settings {
+			content.Defaults = append(content.Defaults, fmt.Sprintf("Defaults%s %s", defaults.Binding, setting))
+		}
+	}
+	for _, spec := range parser.UserSpecs {
+		for _, user := range spec.Users {
+			for _, privilege := range spec.Privileges {
+				for _, host := range privilege.Hosts {
+					for _, command := range privilege.Commands {
+						runAs := command.RunAs
+						if runAs == "" {
+							runAs = sudoers.DefaultRunAs
+						}
+						tags := append([]string(nil), command.Tags...)
+						sort.Strings(tags)
+						content.Rules = append(content.Rules, SudoersRule{
+							User: user, Host: host, RunAs: runAs, Tags: tags, Command: command.Command,
+						})
+					}
+				}
+			}
+		}
+	}
+	return content, parser.Files, parser.Dirs, nil
+}
+
+// Files returns sudoers and the files and directories it includes
+func (sl *SudoersListener) Files() []string {
+	_, files, dirs, err := sl.parse()
+	if err != nil {
+		return []string{sl.sudoers}
+	}
+	return append(files, dirs...)
+}
+
+// Register method returns list of paths to files to be watched
+func (sl *SudoersListener) Register(includes []string) (out []string) {
+	files := append([]string{sl.sudoers}, includes...)
+	if base, ok := sl.Fs.(*afero.BasePathFs); ok {
+		for _, file := range files {
+			rpath, _ := base.RealPath(file)
+			out = append(out, rpath)
+		}
+		return ArrayClean(out)
+	}
+	return ArrayClean(files)
+}
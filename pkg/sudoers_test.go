@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSudoersChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_sudoers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sudoers, includeDir := filepath.Join(dir, "sudoers"), filepath.Join(dir, "sudoers.d")
+	if err := os.Mkdir(includeDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	write := func(file, content string) {
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(sudoers, `# comment
+Defaults env_reset, \
+	secure_path="/usr/sbin:/usr/bin"
+Defaults:alice !requiretty
+User_Alias ADMINS = carol # trailing comment
+Cmnd_Alias SHUTDOWN = /sbin/shutdown, /sbin/reboot
+root ALL=(ALL:ALL) ALL
+%wheel, ADMINS ALL = (root) SHUTDOWN, (www-data) NOPASSWD: /usr/bin/systemctl reload nginx, /bin/kill
+#includedir sudoers.d
+`)
+	write(filepath.Join(includeDir, "ignored.bak"), "mallory ALL=(ALL) NOPASSWD: ALL\n")
+	listener := NewSudoersListener(func(l *SudoersListener) { l.sudoers = sudoers })
+
+	old, files, dirs, err := listener.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, []string{sudoers}) || !reflect.DeepEqual(dirs, []string{includeDir}) {
+		t.Errorf("parse want includes: %v %v, got: %v %v", []string{sudoers}, []string{includeDir}, files, dirs)
+	}
+	wantRules := []string{
+		"root ALL = (ALL:ALL) ALL",
+		"%wheel ALL = (root) SHUTDOWN",
+		"%wheel ALL = (www-data) NOPASSWD: /usr/bin/systemctl reload nginx",
+		"%wheel ALL = (www-data) NOPASSWD: /bin/kill",
+		"ADMINS ALL = (root) SHUTDOWN",
+		"ADMINS ALL = (www-data) NOPASSWD: /usr/bin/systemctl reload nginx",
+		"ADMINS ALL = (www-data) NOPASSWD: /bin/kill",
+	}
+	var rules []string
+	for _, rule := range old.Rules {
+		rules = append(rules, rule.String())
+	}
+	if !reflect.DeepEqual(rules, wantRules) {
+		t.Errorf("parse want rules: %q, got: %q", wantRules, rules)
+	}
+	wantDefaults := []string{`Defaults env_reset`, `Defaults secure_path="/usr/sbin:/usr/bin"`, `Defaults:alice !requiretty`}
+	if !reflect.DeepEqual(old.Defaults, wantDefaults) {
+		t.Errorf("parse want defaults: %q, got: %q", wantDefaults, old.Defaults)
+	}
+
+	write(filepath.Join(includeDir, "admins"), `User_Alias ADMINS = carol, bob
+alice ALL=(ALL) NOPASSWD: ALL
+Defaults:alice requiretty
+`)
+	new, files, _, err := listener.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[1] != filepath.Join(includeDir, "admins") {
+		t.Errorf("parse want the includedir file followed, got: %v", files)
+	}
+	add, del := sudoersDiff(old, new)
+	want := []string{
+		"Defaults:alice requiretty set",
+		"User_Alias ADMINS gained bob",
+		"alice gained NOPASSWD: ALL as ALL",
+	}
+	if changes := sudoersChanges(add, del); !reflect.DeepEqual(changes, want) {
+		t.Errorf("sudoersChanges want: %q, got: %q", want, changes)
+	}
+	if add, del := sudoersDiff(new, old); !reflect.DeepEqual(sudoersChanges(add, del), []string{
+		"Defaults:alice requiretty unset",
+		"User_Alias ADMINS lost bob",
+		"alice lost NOPASSWD: ALL as ALL",
+	}) {
+		t.Errorf("sudoersChanges want the reverse diff, got: %q", sudoersChanges(add, del))
+	}
+}
+
+func TestSudoersChainedTags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_sudoers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sudoers := filepath.Join(dir, "sudoers")
+	if err := ioutil.WriteFile(sudoers, []byte(`alice ALL = (root) NOPASSWD:SETENV: /bin/ls
+bob ALL = (root) NOPASSWD: SETENV: /bin/ls, /bin/cat
+carol ALL=NOPASSWD:SETENV: /bin/ls : db = (root)NOPASSWD:sha256:abcd /bin/id
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	listener := NewSudoersListener(func(l *SudoersListener) { l.sudoers = sudoers })
+	content, _, _, err := listener.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rules []string
+	for _, rule := range content.Rules {
+		rules = append(rules, rule.String())
+	}
+	want := []string{
+		"alice ALL = (root) NOPASSWD: SETENV: /bin/ls",
+		"bob ALL = (root) NOPASSWD: SETENV: /bin/ls",
+		"bob ALL = (root) NOPASSWD: SETENV: /bin/cat",
+		"carol ALL = (root) NOPASSWD: SETENV: /bin/ls",
+		"carol db = (root) NOPASSWD: sha256:abcd /bin/id",
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("parse want rules: %q, got: %q", want, rules)
+	}
+}
@@ -0,0 +1,300 @@
+package pkg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/afero"
+
+	"github.com/bookingcom/bpfink/pkg/lang/systemd"
+)
+
+// nolint:gochecknoglobals
+var (
+	// DefaultSystemdKeys are the unit settings compared, by section and key
+	DefaultSystemdKeys = []string{
+		"Service.ExecStart", "Service.ExecStartPre", "Service.ExecStartPost", "Service.ExecReload",
+		"Service.ExecStop", "Service.ExecStopPost", "Service.ExecCondition", "Service.User", "Service.Group",
+		"Service.Environment", "Service.EnvironmentFile", "Service.UnsetEnvironment", "Service.PAMName",
+		"Service.WorkingDirectory", "Service.RootDirectory", "Service.AmbientCapabilities",
+		"Service.CapabilityBoundingSet", "Service.NoNewPrivileges", "Service.Type",
+		"Timer.OnCalendar", "Timer.OnBootSec", "Timer.OnStartupSec", "Timer.OnActiveSec",
+		"Timer.OnUnitActiveSec", "Timer.OnUnitInactiveSec", "Timer.Unit",
+		"Install.WantedBy", "Install.RequiredBy", "Install.Alias",
+	}
+	// systemdUnitSuffixes are the kinds of units parsed
+	systemdUnitSuffixes = []string{".service", ".timer"}
+	// systemdDependencies are the directories of enabled units, by suffix
+	systemdDependencies = map[string]string{".wants": "wanted", ".requires": "required"}
+)
+
+type (
+	// SystemdUnit struct representing the effective settings of a unit, its file being the one of the first unit directory
+	// holding it and drop-ins applying in the order of their name
+	SystemdUnit struct {
+		File     string // empty when the unit is only extended by drop-ins
+		Masked   bool   // linked to /dev/null
+		DropIns  []string
+		Settings map[string]string // values by section and key, i.e. Service.ExecStart, several values are joined with "; "
+	}
+	// Systemd struct used to store the units of the unit directories, by name, and the units they enable
+	Systemd struct {
+		Units map[string]SystemdUnit
+		Links []string // i.e. multi-user.target.wants/sshd.service
+	}
+	// SystemdListener struct used for filestream events.
+	SystemdListener struct {
+		zerolog.Logger
+		afero.Fs
+		// Dirs are the unit directories, by decreasing priority, i.e. /etc/systemd/system first
+		Dirs []string
+		// Keys are the settings compared, DefaultSystemdKeys when empty
+		Keys []string
+	}
+)
+
+// IsEmpty method to check if diff is empty
+func (s Systemd) IsEmpty() bool { return len(s.Units) == 0 && len(s.Links) == 0 }
+
+// systemdUnit reports if a file name is a unit of a kind parsed
+func systemdUnit(name string) bool {
+	for _, suffix := range systemdUnitSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// systemdLink splits an enablement link, i.e. multi-user.target.wants/sshd.service is sshd.service wanted by multi-user.target
+func systemdLink(link string) (unit, relation, target string) {
+	dir, unit := filepath.Split(link)
+	dir = filepath.Clean(dir)
+	for suffix, relation := range systemdDependencies {
+		if strings.HasSuffix(dir, suffix) {
+			return unit, relation, strings.TrimSuffix(dir, suffix)
+		}
+	}
+	return unit, "linked", dir
+}
+
+// systemdChanges describes the changes of units and links in plain words, i.e. "backdoor.service added: /etc/systemd/system/backdoor.service"
+func systemdChanges(old, new Systemd) (changes []string) {
+	for name, unit := range new.Units {
+		previous, ok := old.Units[name]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("%s added: %s", name, unit.File))
+			continue
+		}
+		switch {
+		case unit.Masked && !previous.Masked:
+			changes = append(changes, fmt.Sprintf("%s masked", name))
+		case !unit.Masked && previous.Masked:
+			changes = append(changes, fmt.Sprintf("%s unmasked", name))
+		}
+		if unit.File != previous.File {
+			changes = append(changes, fmt.Sprintf("%s now loaded from %s, was %s", name, unit.File, previous.File))
+		}
+		add, del := ArrayDiff(previous.DropIns, unit.DropIns)
+		for _, dropIn := range add {
+			changes = append(changes, fmt.Sprintf("%s drop-in added: %s", name, dropIn))
+		}
+		for _, dropIn := range del {
+			changes = append(changes, fmt.Sprintf("%s drop-in removed: %s", name, dropIn))
+		}
+		for key, value := range unit.Settings {
+			switch was, ok := previous.Settings[key]; {
+			case !ok:
+				changes = append(changes, fmt.Sprintf("%s %s set to %s", name, key, value))
+			case was != value:
+				changes = append(changes, fmt.Sprintf("%s %s changed from %s to %s", name, key, was, value))
+			}
+		}
+		for key, was := range previous.Settings {
+			if _, ok := unit.Settings[key]; !ok {
+				changes = append(changes, fmt.Sprintf("%s %s unset, was %s", name, key, was))
+			}
+		}
+	}
+	for name, unit := range old.Units {
+		if _, ok := new.Units[name]; !ok {
+			changes = append(changes, fmt.Sprintf("%s removed: %s", name, unit.File))
+		}
+	}
+	add, del := ArrayDiff(old.Links, new.Links)
+	for _, link := range add {
+		unit, relation, target := systemdLink(link)
+		changes = append(changes, fmt.Sprintf("%s enabled, %s by %s", unit, relation, target))
+	}
+	for _, link := range del {
+		unit, relation, target := systemdLink(link)
+		changes = append(changes, fmt.Sprintf("%s disabled, no longer %s by %s", unit, relation, target))
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// NewSystemdListener function to create a new file event listener
+func NewSystemdListener(options ...func(*SystemdListener)) *SystemdListener {
+	sl := &SystemdListener{Logger: zerolog.Nop()}
+	for _, option := range options {
+		option(sl)
+	}
+	if len(sl.Keys) == 0 {
+		sl.Keys = DefaultSystemdKeys
+	}
+	return sl
+}
+
+// parse reads the units of the unit directories, the unit files, drop-ins and directories are returned to be watched.
+// Enablement links are not watched themselves, their directories are.
+func (sl *SystemdListener) parse() (Systemd, []string, error) {
+	sl.Debug().Msgf("parsing units: %v", sl.Dirs)
+	content := Systemd{Units: map[string]SystemdUnit{}}
+	dropIns := map[string]map[string]string{} // drop-in paths by unit and drop-in name
+	var files []string
+	for _, dir := range sl.Dirs {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if !IsNotExist(err) {
+				sl.Warn().Err(err).Str("dir", dir).Msg("failed to read unit directory")
+			}
+			continue
+		}
+		files = append(files, dir)
+		for _, info := range infos {
+			path := filepath.Join(dir, info.Name())
+			switch name := info.Name(); {
+			case info.IsDir() && strings.HasSuffix(name, ".d") && systemdUnit(strings.TrimSuffix(name, ".d")):
+				unit := strings.TrimSuffix(name, ".d")
+				if dropIns[unit] == nil {
+					dropIns[unit] = map[string]string{}
+				}
+				files = append(files, path)
+				for _, dropIn := range sl.readDir(path) {
+					if _, ok := dropIns[unit][filepath.Base(dropIn)]; !ok && strings.HasSuffix(dropIn, ".conf") {
+						dropIns[unit][filepath.Base(dropIn)] = dropIn
+						files = append(files, dropIn)
+					}
+				}
+			case info.IsDir() && (strings.HasSuffix(name, ".wants") || strings.HasSuffix(name, ".requires")):
+				files = append(files, path)
+				links, err := ioutil.ReadDir(path)
+				if err != nil {
+					sl.Warn().Err(err).Str("dir", path).Msg("failed to read unit directory")
+					continue
+				}
+				for _, link := range links {
+					content.Links = append(content.Links, filepath.Join(name, link.Name()))
+				}
+			case systemdUnit(name):
+				if _, ok := content.Units[name]; ok {
+					continue
+				}
+				unit := SystemdUnit{File: path}
+				if info.Mode()&os.ModeSymlink != 0 {
+					target, err := os.Readlink(path)
+					unit.Masked = err == nil && target == os.DevNull
+				} else {
+					files = append(files, path)
+				}
+				content.Units[name] = unit
+			}
+		}
+	}
+	for name, unitDropIns := range dropIns {
+		if _, ok := content.Units[name]; !ok {
+			content.Units[name] = SystemdUnit{}
+		}
+		unit := content.Units[name]
+		for _, dropIn := range unitDropIns {
+			unit.DropIns = append(unit.DropIns, dropIn)
+		}
+		sort.Slice(unit.DropIns, func(i, j int) bool {
+			return filepath.Base(unit.DropIns[i]) < filepath.Base(unit.DropIns[j])
+		})
+		content.Units[name] = unit
+	}
+	for name, unit := range content.Units {
+		unit.Settings = sl.settings(unit)
+		content.Units[name] = unit
+	}
+	return content, files, nil
+}
+
+// settings applies the unit file and its drop-ins, an empty assignment resets the values of a key as in systemd
+func (sl *SystemdListener) settings(unit SystemdUnit) map[string]string {
+	compared := Array2Set(sl.Keys)
+	values := map[string][]string{}
+	if unit.Masked {
+		return map[string]string{}
+	}
+	for _, file := range append([]string{unit.File}, unit.DropIns...) {
+		if file == "" {
+			continue
+		}
+		parser := systemd.Parser{FileName: file, Logger: sl.Logger}
+		if err := parser.Parse(); err != nil {
+			sl.Warn().Err(err).Str("file", file).Msg("failed to parse unit")
+			continue
+		}
+		for _, setting := range parser.Settings {
+			key := setting.Section + "." + setting.Key
+			if _, ok := compared[key]; !ok {
+				continue
+			}
+			if setting.Value == "" {
+				delete(values, key)
+				continue
+			}
+			values[key] = append(values[key], setting.Value)
+		}
+	}
+	settings := make(map[string]string, len(values))
+	for key, value := range values {
+		settings[key] = strings.Join(value, "; ")
+	}
+	return settings
+}
+
+// readDir returns the regular files of a directory
+func (sl *SystemdListener) readDir(dir string) (files []string) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		sl.Warn().Err(err).Str("dir", dir).Msg("failed to read unit directory")
+		return nil
+	}
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			files = append(files, filepath.Join(dir, info.Name()))
+		}
+	}
+	return files
+}
+
+// Files returns the unit files, drop-ins and directories parsed
+func (sl *SystemdListener) Files() []string {
+	_, files, err := sl.parse()
+	if err != nil {
+		return sl.Dirs
+	}
+	return files
+}
+
+// Register method returns list of paths to files to be watched
+func (sl *SystemdListener) Register(includes []string) (out []string) {
+	files := append(append([]string(nil), sl.Dirs...), includes...)
+	if base, ok := sl.Fs.(*afero.BasePathFs); ok {
+		for _, file := range files {
+			rpath, _ := base.RealPath(file)
+			out = append(out, rpath)
+		}
+		return ArrayClean(out)
+	}
+	return ArrayClean(files)
+}
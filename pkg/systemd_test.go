@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSystemdChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_systemd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	etc, lib := filepath.Join(dir, "etc"), filepath.Join(dir, "lib")
+	for _, d := range []string{etc, lib, filepath.Join(etc, "multi-user.target.wants"), filepath.Join(etc, "ssh.service.d")} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(file, content string) {
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(lib, "ssh.service"), `[Unit]
+Description=OpenBSD Secure Shell server
+
+[Service]
+ExecStartPre=/usr/sbin/sshd -t
+ExecStart=/usr/sbin/sshd -D \
+	$SSHD_OPTS
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`)
+	write(filepath.Join(etc, "ssh.service.d", "override.conf"), "[Service]\nEnvironment=SSHD_OPTS=-4\n")
+	write(filepath.Join(etc, "ssh.service.d", "notes.txt"), "[Service]\nUser=nobody\n")
+	if err := os.Symlink(filepath.Join(lib, "ssh.service"), filepath.Join(etc, "multi-user.target.wants", "ssh.service")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(os.DevNull, filepath.Join(etc, "rescue.service")); err != nil {
+		t.Fatal(err)
+	}
+	write(filepath.Join(lib, "rescue.service"), "[Service]\nExecStart=/bin/sh\n")
+	listener := NewSystemdListener(func(l *SystemdListener) { l.Dirs = []string{etc, lib} })
+
+	old, files, err := listener.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFiles := []string{
+		etc, filepath.Join(etc, "multi-user.target.wants"), filepath.Join(etc, "ssh.service.d"),
+		filepath.Join(etc, "ssh.service.d", "override.conf"), lib, filepath.Join(lib, "ssh.service"),
+	}
+	if !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("parse want files: %v, got: %v", wantFiles, files)
+	}
+	wantUnits := map[string]SystemdUnit{
+		"ssh.service": {
+			File:    filepath.Join(lib, "ssh.service"),
+			DropIns: []string{filepath.Join(etc, "ssh.service.d", "override.conf")},
+			Settings: map[string]string{
+				"Service.ExecStartPre": "/usr/sbin/sshd -t",
+				"Service.ExecStart":    "/usr/sbin/sshd -D $SSHD_OPTS",
+				"Service.Environment":  "SSHD_OPTS=-4",
+				"Install.WantedBy":     "multi-user.target",
+			},
+		},
+		"rescue.service": {File: filepath.Join(etc, "rescue.service"), Masked: true, Settings: map[string]string{}},
+	}
+	if !reflect.DeepEqual(old.Units, wantUnits) || !reflect.DeepEqual(old.Links, []string{"multi-user.target.wants/ssh.service"}) {
+		t.Errorf("parse want units: %v, got: %v %v", wantUnits, old.Units, old.Links)
+	}
+
+	write(filepath.Join(etc, "ssh.service.d", "zz-debug.conf"), "[Service]\nExecStart=\nExecStart=/tmp/sshd -D\nUser=root\n")
+	write(filepath.Join(etc, "backdoor.service"), "[Service]\nExecStart=/tmp/.x/agent\n")
+	if err := os.Symlink(filepath.Join(etc, "backdoor.service"), filepath.Join(etc, "multi-user.target.wants", "backdoor.service")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(etc, "rescue.service")); err != nil {
+		t.Fatal(err)
+	}
+	new, _, err := listener.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"backdoor.service added: " + filepath.Join(etc, "backdoor.service"),
+		"backdoor.service enabled, wanted by multi-user.target",
+		"rescue.service Service.ExecStart set to /bin/sh",
+		"rescue.service now loaded from " + filepath.Join(lib, "rescue.service") + ", was " + filepath.Join(etc, "rescue.service"),
+		"rescue.service unmasked",
+		"ssh.service Service.ExecStart changed from /usr/sbin/sshd -D $SSHD_OPTS to /tmp/sshd -D",
+		"ssh.service Service.User set to root",
+		"ssh.service drop-in added: " + filepath.Join(etc, "ssh.service.d", "zz-debug.conf"),
+	}
+	if changes := systemdChanges(old, new); !reflect.DeepEqual(changes, want) {
+		t.Errorf("systemdChanges want: %q, got: %q", want, changes)
+	}
+}
@@ -1,24 +1,89 @@
 package pkg
 
 import (
-	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
+	"sort"
 	"strings"
 
 	"github.com/rs/zerolog"
 	"github.com/spf13/afero"
 
+	"github.com/bookingcom/bpfink/pkg/lang/authorizedkeys"
 	"github.com/bookingcom/bpfink/pkg/lang/passwd"
 	"github.com/bookingcom/bpfink/pkg/lang/shadow"
+	"github.com/bookingcom/bpfink/pkg/lang/sshdconfig"
+)
+
+// nolint:gochecknoglobals
+var defaultAuthorizedKeysFiles = []string{".ssh/authorized_keys", ".ssh/authorized_keys2"}
+
+const (
+	// PasswordSet the account has a usable password
+	PasswordSet = "set"
+	// PasswordLocked the password is prefixed by !, as done by passwd -l or usermod -L
+	PasswordLocked = "locked"
+	// PasswordDisabled the account has no password to log in with, i.e. * or !!
+	PasswordDisabled = "disabled"
+	// PasswordEmpty the account logs in without a password
+	PasswordEmpty = "empty"
+)
+
+// Findings reported on account changes
+const (
+	FindingAccountAdded      = "account added"
+	FindingAccountRemoved    = "account removed"
+	FindingUID0              = "new uid-0 account"
+	FindingUIDChanged        = "uid changed"
+	FindingGIDChanged        = "gid changed"
+	FindingHomeChanged       = "home changed"
+	FindingShellEnabled      = "shell enabled"
+	FindingShellDisabled     = "shell disabled"
+	FindingShellChanged      = "shell changed"
+	FindingPasswordSet       = "password set"
+	FindingPasswordChanged   = "password changed"
+	FindingPasswordRemoved   = "password removed"
+	FindingPasswordDisabled  = "password disabled"
+	FindingAccountLocked     = "account locked"
+	FindingAccountUnlocked   = "account unlocked"
+	FindingAgingChanged      = "password aging changed"
+	FindingExpirationChanged = "account expiration changed"
 )
 
 type (
-	// User struct representing a user
+	// User struct representing a user, the password is masked and only its state is reported
 	User struct {
-		Name     string
-		Password string
-		Keys     []string
+		Name          string
+		Password      string
+		PasswordState string
+		UID, GID      string
+		Home, Shell   string
+		Aging         Aging
+		Keys          []AuthorizedKey
+		// Dotfiles digests of the existing per user dotfiles by path
+		Dotfiles map[string]string
+	}
+
+	// Aging struct holding the shadow aging fields, in days or days since the epoch for LastChange and Expiration
+	Aging struct {
+		LastChange, MinAge, MaxAge, Warning, Inactivity, Expiration string
+	}
+
+	// UserFinding struct describing a security relevant change of an account, i.e. "new uid-0 account"
+	UserFinding struct {
+		User, Type, Detail string
+	}
+
+	// AuthorizedKey struct representing a key a user can log in with, identified by its fingerprint
+	AuthorizedKey struct {
+		Type        string
+		Fingerprint string
+		Options     []string
+		Comment     string
 	}
 
 	// Users map of user objects
@@ -28,23 +93,243 @@ type (
 	UsersListener struct {
 		afero.Fs
 		Shadow, Passwd string
+		// SSHDConfig is read for AuthorizedKeysFile, sshd defaults apply when it is not set
+		SSHDConfig string
+		// SSHDConfigWatched leaves sshd_config to the sshd consumer, the templates are read again on the next change of users
+		SSHDConfigWatched bool
+		// Dotfiles templates of per user files to watch, relative to the home directory, i.e. .bashrc
+		Dotfiles []string
 		zerolog.Logger
 	}
 
 	passwdListener struct {
 		zerolog.Logger
-		users map[string]string
+		users Users
 	}
 
 	shadowListener struct {
 		zerolog.Logger
-		users map[string]string
+		users map[string]shadow.User
 	}
 )
 
 // Equal method to compare to users
 func (u1 *User) Equal(u2 *User) bool {
-	return u1.Name == u2.Name && u1.Password == u2.Password && ArrayEqual(u1.Keys, u2.Keys)
+	return u1.Name == u2.Name && u1.Password == u2.Password && u1.PasswordState == u2.PasswordState &&
+		u1.UID == u2.UID && u1.GID == u2.GID && u1.Home == u2.Home && u1.Shell == u2.Shell &&
+		u1.Aging == u2.Aging && ArrayEqual(u1.keys(), u2.keys()) && Map(u1.Dotfiles).Equal(u2.Dotfiles)
+}
+
+// setPassword masks a passwd or shadow password field and records its state
+func (u1 *User) setPassword(password string) {
+	u1.Password = ""
+	switch {
+	case password == "":
+		u1.PasswordState = PasswordEmpty
+	case strings.Trim(password, "!") == "" || strings.HasPrefix(strings.TrimLeft(password, "!"), "*"):
+		u1.PasswordState = PasswordDisabled
+	case strings.HasPrefix(password, "!"):
+		u1.PasswordState, u1.Password = PasswordLocked, MaskLeft(strings.TrimLeft(password, "!"))
+	default:
+		u1.PasswordState, u1.Password = PasswordSet, MaskLeft(password)
+	}
+}
+
+// String renders a finding in plain words, i.e. "alice: shell enabled (/sbin/nologin to /bin/bash)"
+func (f UserFinding) String() string {
+	if f.Detail == "" {
+		return fmt.Sprintf("%s: %s", f.User, f.Type)
+	}
+	return fmt.Sprintf("%s: %s (%s)", f.User, f.Type, f.Detail)
+}
+
+// maskedEqual tells whether two masked passwords may be the same, the legacy mask kept the lock prefix
+func maskedEqual(masked1, masked2 string) bool {
+	suffix := func(s string) string {
+		if len(s) > 4 {
+			return s[len(s)-4:]
+		}
+		return s
+	}
+	return suffix(masked1) == suffix(masked2)
+}
+
+// loginShell tells whether a shell lets the account log in, an empty shell is /bin/sh
+func loginShell(shell string) bool {
+	base := path.Base(shell)
+	return base != "nologin" && base != "false"
+}
+
+// userFindings lists the account changes of a diff, keys and dotfiles are left to userChanges
+func userFindings(add, del Users) (findings []UserFinding) {
+	for name, next := range add {
+		current, ok := del[name]
+		if !ok {
+			findings = append(findings, UserFinding{name, FindingAccountAdded,
+				fmt.Sprintf("uid %s, shell %s, password %s", next.UID, next.Shell, next.PasswordState)})
+			if next.UID == "0" {
+				findings = append(findings, UserFinding{User: name, Type: FindingUID0})
+			}
+			continue
+		}
+		findings = append(findings, accountFindings(name, current, next)...)
+		findings = append(findings, passwordFindings(name, current, next)...)
+	}
+	for name := range del {
+		if _, ok := add[name]; !ok {
+			findings = append(findings, UserFinding{User: name, Type: FindingAccountRemoved})
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool { return findings[i].String() < findings[j].String() })
+	return findings
+}
+
+func accountFindings(name string, current, next *User) (findings []UserFinding) {
+	change := func(from, to string) string { return fmt.Sprintf("%s to %s", from, to) }
+	if current.UID != next.UID {
+		findings = append(findings, UserFinding{name, FindingUIDChanged, change(current.UID, next.UID)})
+		if next.UID == "0" {
+			findings = append(findings, UserFinding{User: name, Type: FindingUID0})
+		}
+	}
+	if current.GID != next.GID {
+		findings = append(findings, UserFinding{name, FindingGIDChanged, change(current.GID, next.GID)})
+	}
+	if current.Home != next.Home {
+		findings = append(findings, UserFinding{name, FindingHomeChanged, change(current.Home, next.Home)})
+	}
+	if current.Shell != next.Shell {
+		finding := FindingShellChanged
+		switch current, next := loginShell(current.Shell), loginShell(next.Shell); {
+		case !current && next:
+			finding = FindingShellEnabled
+		case current && !next:
+			finding = FindingShellDisabled
+		}
+		findings = append(findings, UserFinding{name, finding, change(current.Shell, next.Shell)})
+	}
+	if current.Aging.Expiration != next.Aging.Expiration {
+		findings = append(findings, UserFinding{name, FindingExpirationChanged,
+			change(current.Aging.Expiration, next.Aging.Expiration)})
+	}
+	currentAging, nextAging := current.Aging, next.Aging
+	currentAging.Expiration, nextAging.Expiration = "", ""
+	if current.Password != next.Password {
+		// a new password moves the last change date
+		currentAging.LastChange, nextAging.LastChange = "", ""
+	}
+	if currentAging != nextAging {
+		findings = append(findings, UserFinding{User: name, Type: FindingAgingChanged})
+	}
+	return findings
+}
+
+func passwordFindings(name string, current, next *User) []UserFinding {
+	finding := ""
+	switch {
+	case current.PasswordState == next.PasswordState:
+		if current.Password != next.Password {
+			finding = FindingPasswordChanged
+		}
+	case next.PasswordState == PasswordEmpty:
+		finding = FindingPasswordRemoved
+	case next.PasswordState == PasswordDisabled:
+		finding = FindingPasswordDisabled
+	case next.PasswordState == PasswordLocked:
+		finding = FindingAccountLocked
+	case current.PasswordState == PasswordLocked:
+		finding = FindingAccountUnlocked
+		if current.Password != next.Password {
+			return []UserFinding{{User: name, Type: finding}, {User: name, Type: FindingPasswordChanged}}
+		}
+	default:
+		finding = FindingPasswordSet
+	}
+	if finding == "" {
+		return nil
+	}
+	return []UserFinding{{User: name, Type: finding}}
+}
+
+// keys returns the keys of a user with their options, comments are left out as they grant nothing
+func (u1 *User) keys() (keys []string) {
+	for _, key := range u1.Keys {
+		keys = append(keys, key.String())
+	}
+	return keys
+}
+
+// String renders a key the way it is compared, options first
+func (k AuthorizedKey) String() string {
+	if len(k.Options) == 0 {
+		return k.Type + " " + k.Fingerprint
+	}
+	return strings.Join(k.Options, ",") + " " + k.Type + " " + k.Fingerprint
+}
+
+func authorizedKey(key authorizedkeys.Key) AuthorizedKey {
+	return AuthorizedKey{Type: key.Type, Fingerprint: key.Fingerprint, Options: key.Options, Comment: key.Comment}
+}
+
+// userChanges describes the findings, key and dotfile changes of a diff in plain words,
+// i.e. "alice: ssh-ed25519 key SHA256:... added"
+func userChanges(add, del Users) (changes []string) {
+	for _, finding := range userFindings(add, del) {
+		changes = append(changes, finding.String())
+	}
+	for name, next := range add {
+		current, ok := del[name]
+		if !ok {
+			current = &User{}
+		}
+		currentKeys := map[string]AuthorizedKey{}
+		for _, key := range current.Keys {
+			currentKeys[key.Fingerprint] = key
+		}
+		for _, key := range next.Keys {
+			previous, ok := currentKeys[key.Fingerprint]
+			delete(currentKeys, key.Fingerprint)
+			switch {
+			case !ok && len(key.Options) == 0:
+				changes = append(changes, fmt.Sprintf("%s: %s key %s added", name, key.Type, key.Fingerprint))
+			case !ok:
+				changes = append(changes, fmt.Sprintf("%s: %s key %s added with options %s",
+					name, key.Type, key.Fingerprint, strings.Join(key.Options, ",")))
+			case !ArrayEqual(previous.Options, key.Options):
+				changes = append(changes, fmt.Sprintf("%s: options of key %s changed from %q to %q",
+					name, key.Fingerprint, strings.Join(previous.Options, ","), strings.Join(key.Options, ",")))
+			}
+		}
+		for _, key := range currentKeys {
+			changes = append(changes, fmt.Sprintf("%s: %s key %s removed", name, key.Type, key.Fingerprint))
+		}
+		for dotfile, digest := range next.Dotfiles {
+			switch currentDigest, ok := current.Dotfiles[dotfile]; {
+			case !ok:
+				changes = append(changes, fmt.Sprintf("%s: %s created", name, dotfile))
+			case currentDigest != digest:
+				changes = append(changes, fmt.Sprintf("%s: %s modified", name, dotfile))
+			}
+		}
+		for dotfile := range current.Dotfiles {
+			if _, ok := next.Dotfiles[dotfile]; !ok {
+				changes = append(changes, fmt.Sprintf("%s: %s removed", name, dotfile))
+			}
+		}
+	}
+	for name, current := range del {
+		if _, ok := add[name]; ok {
+			continue
+		}
+		for _, key := range current.Keys {
+			changes = append(changes, fmt.Sprintf("%s: %s key %s removed", name, key.Type, key.Fingerprint))
+		}
+		for dotfile := range current.Dotfiles {
+			changes = append(changes, fmt.Sprintf("%s: %s removed", name, dotfile))
+		}
+	}
+	sort.Strings(changes)
+	return changes
 }
 
 func userDiff(old, new Users) (add, del Users) {
@@ -81,11 +366,7 @@ func (sl *shadowListener) shadowParse(fileName string) error {
 	}
 	sl.Debug().Msg("parsing shadow file")
 	for _, user := range shadowData.Users {
-		switch password := user.Password; password {
-		case "!!", "*", "!", "":
-		default:
-			sl.users[user.Username] = MaskLeft(password)
-		}
+		sl.users[user.Username] = user
 	}
 	return nil
 }
@@ -98,10 +379,14 @@ func (pl *passwdListener) passwdParse(fileName string) error {
 	}
 	pl.Debug().Msg("parsing password file")
 	for _, user := range passwdData.Users {
-		if user.Shell == "/sbin/nologin" { // do not treat user with no shell
-			continue
+		account := &User{Name: user.Username, UID: user.UID, GID: user.GID, Home: user.Home, Shell: user.Shell}
+		// x defers to shadow, anything else is a legacy password kept in passwd
+		if user.Password == "x" {
+			account.PasswordState = PasswordDisabled
+		} else {
+			account.setPassword(user.Password)
 		}
-		pl.users[user.Username] = user.Home
+		pl.users[user.Username] = account
 	}
 	return nil
 }
@@ -112,72 +397,160 @@ func (ul *UsersListener) file(name string) *File {
 	})
 }
 
-func (ul *UsersListener) shadow() (map[string]string, error) {
-	users := map[string]string{}
+func (ul *UsersListener) shadow() (map[string]shadow.User, error) {
+	users := map[string]shadow.User{}
 	listener := &shadowListener{Logger: ul.Logger, users: users}
 	err := listener.shadowParse(ul.Shadow)
 	return listener.users, err
 }
 
-func (ul *UsersListener) passwd() (map[string]string, error) {
-	users := map[string]string{}
+func (ul *UsersListener) passwd() (Users, error) {
+	users := Users{}
 	listener := &passwdListener{Logger: ul.Logger, users: users}
 	err := listener.passwdParse(ul.Passwd)
 	return listener.users, err
 }
 
-func (ul *UsersListener) parse() (Users, []string, error) {
-	users, includes := Users{}, []string{ul.Passwd, ul.Passwd}
-	passwds, err := ul.passwd()
-	ul.Debug().Msgf("passwds: %v", passwds)
+// accounts merges passwd and shadow, accounts listed in shadow only can not log in and are left out
+func (ul *UsersListener) accounts() (Users, error) {
+	users, err := ul.passwd()
+	ul.Debug().Int("passwds", len(users)).Msg("parsed passwd")
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	shadows, err := ul.shadow()
-	ul.Debug().Msgf("shadows: %v", shadows)
+	ul.Debug().Int("shadows", len(shadows)).Msg("parsed shadow")
+	if err != nil {
+		return nil, err
+	}
+	for name, user := range users {
+		entry, ok := shadows[name]
+		if !ok {
+			continue
+		}
+		if user.PasswordState == PasswordDisabled {
+			user.setPassword(entry.Password)
+		}
+		user.Aging = Aging{
+			LastChange: entry.Last, MinAge: entry.Minimum, MaxAge: entry.Maximum,
+			Warning: entry.Warning, Inactivity: entry.Inactivity, Expiration: entry.Expiration,
+		}
+	}
+	return users, nil
+}
+
+func (ul *UsersListener) parse() (Users, []string, error) {
+	includes := []string{ul.Passwd, ul.Passwd}
+	users, err := ul.accounts()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	for user, home := range passwds {
-		authorized := path.Join(home, ".ssh", "authorized_keys")
-		includes = append(includes, authorized)
-		keys := keys(ul.file(authorized))
-		if password, ok := shadows[user]; ok || len(keys) != 0 {
-			users[user] = &User{user, password, keys}
+	authorizedKeysFiles := ul.authorizedKeysFiles()
+	if ul.SSHDConfig != "" && !ul.SSHDConfigWatched {
+		includes = append(includes, ul.SSHDConfig)
+	}
+	for _, user := range users {
+		for _, template := range authorizedKeysFiles {
+			authorized := expandUserPath(template, user)
+			if !userPathWatched(user, authorized) {
+				continue
+			}
+			includes = append(includes, authorized)
+			user.Keys = append(user.Keys, keys(ul.file(authorized))...)
+		}
+		for _, template := range ul.Dotfiles {
+			dotfile := expandUserPath(template, user)
+			if !userPathWatched(user, dotfile) {
+				continue
+			}
+			includes = append(includes, dotfile)
+			if digest, ok := ul.dotfileDigest(dotfile); ok {
+				if user.Dotfiles == nil {
+					user.Dotfiles = map[string]string{}
+				}
+				user.Dotfiles[dotfile] = digest
+			}
 		}
 	}
 	return users, includes, nil
 }
 
-func keys(authorized *File) (keys []string) {
-	authorized.Debug().Str("file", authorized.Path).
-		Msg("parsing authorized_keys")
-	if fileExists(authorized.Path) {
-		file, err := authorized.Open(authorized.Path)
-		if err != nil {
-			authorized.Error().Err(err).Str("file", authorized.Path).
-				Msg("failed to open authorized keys file")
-			return
-		}
-		defer func() {
-			if err = file.Close(); err != nil {
-				authorized.Error().Err(err)
-			}
-		}()
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			line := scanner.Text()
-			key := "ssh-rsa "
-			keyOffset := len(key)
-			if i := strings.Index(line, key); i != -1 {
-				keys = append(keys, line[i+keyOffset:])
+// authorizedKeysFiles returns the AuthorizedKeysFile templates of sshd_config, Match blocks included
+func (ul *UsersListener) authorizedKeysFiles() []string {
+	if ul.SSHDConfig == "" {
+		return defaultAuthorizedKeysFiles
+	}
+	sshdConfig := sshdconfig.Parser{FileName: ul.SSHDConfig, Logger: ul.Logger}
+	if err := sshdConfig.Parse(); err != nil {
+		ul.Warn().Err(err).Str("file", ul.SSHDConfig).Msg("failed to parse sshd_config, using sshd defaults")
+		return defaultAuthorizedKeysFiles
+	}
+	values := sshdConfig.Values("AuthorizedKeysFile")
+	if len(values) == 0 {
+		return defaultAuthorizedKeysFiles
+	}
+	var templates []string
+	for _, args := range values {
+		for _, template := range args {
+			if template != "none" {
+				templates = append(templates, template)
 			}
 		}
-		authorized.Debug().Str("file", authorized.Path).
-			Strs("keys", keys).Msg("parsed authorized_keys")
+	}
+	return ArrayClean(templates)
+}
+
+// expandUserPath expands the %h, %u, %U and %% tokens of sshd, relative paths are relative to the home directory
+func expandUserPath(template string, user *User) string {
+	expanded := strings.NewReplacer("%%", "%", "%h", user.Home, "%u", user.Name, "%U", user.UID).Replace(template)
+	if !path.IsAbs(expanded) {
+		expanded = path.Join(user.Home, expanded)
+	}
+	return expanded
+}
+
+// userPathWatched tells whether the keys or dotfile of an account are watched, the paths of system accounts
+// without login shell are only watched when their directory exists so that they do not all end up polled
+func userPathWatched(user *User, name string) bool {
+	if loginShell(user.Shell) {
+		return true
+	}
+	info, err := os.Stat(path.Dir(name))
+	return err == nil && info.IsDir()
+}
+
+// dotfileDigest returns the digest of a dotfile, which is not logged as it may hold secrets
+func (ul *UsersListener) dotfileDigest(dotfile string) (string, bool) {
+	if !fileExists(dotfile) {
+		return "", false
+	}
+	content, err := ioutil.ReadFile(dotfile)
+	if err != nil {
+		ul.Error().Err(err).Str("file", dotfile).Msg("failed to read dotfile")
+		return "", false
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), true
+}
+
+func keys(authorized *File) (keys []AuthorizedKey) {
+	authorized.Debug().Str("file", authorized.Path).
+		Msg("parsing authorized_keys")
+	if !fileExists(authorized.Path) {
 		return
 	}
+	keysData := authorizedkeys.Parser{FileName: authorized.Path, Logger: authorized.Logger}
+	if err := keysData.Parse(); err != nil {
+		authorized.Error().Err(err).Str("file", authorized.Path).
+			Msg("failed to parse authorized keys file")
+		return
+	}
+	for _, key := range keysData.Keys {
+		keys = append(keys, authorizedKey(key))
+	}
+	authorized.Debug().Str("file", authorized.Path).
+		Int("keys", len(keys)).Msg("parsed authorized_keys")
 	return
 }
 
@@ -200,7 +573,7 @@ func (ul *UsersListener) Register(includes []string) (out []string) {
 		rshadow, _ := base.RealPath(ul.Shadow)
 		out = append(out, rpasswd, rshadow)
 	} else {
-		out = append(out, ul.Passwd, ul.Shadow)
+		out = append(append(out, includes...), ul.Passwd, ul.Shadow)
 	}
 	return ArrayClean(out)
 }
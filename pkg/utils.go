@@ -110,6 +110,14 @@ func (m1 Map) Equal(m2 Map) bool {
 	return true
 }
 
+// Keys method to return the keys of a map
+func (m1 Map) Keys() (keys []string) {
+	for k := range m1 {
+		keys = append(keys, k)
+	}
+	return
+}
+
 // GobMarshal function to marshal interface to byte slice
 func GobMarshal(i interface{}) ([]byte, error) {
 	buf := bytes.NewBuffer(nil)
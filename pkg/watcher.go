@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 )
@@ -15,15 +16,33 @@ type (
 	// Watcher struct defines a watcher object
 	Watcher struct {
 		zerolog.Logger
-		*FIM
+		EventSource
 		Key           []byte
+		Digest        string
 		Database      *AgentDB
 		Consumers     []Consumer
 		consumers     Consumers
 		CloseChannels chan struct{}
 		Excludes      []*regexp.Regexp
 		GenericDiff   []string
+		Rules         Rules
 		Metrics       *Metrics
+		Health        *Health
+		Alerts        *Alerts // handed to the consumers of files created in watched directories
+		reloads       chan reloadRequest
+	}
+	// EventSource describes a backend delivering file system events for the watched files
+	EventSource interface {
+		AddFile(name string) error
+		RemoveFile(name string) error
+		RemoveInode(key uint64) (string, error)
+		GetFileFromInode(key uint64) (string, error)
+		MapInode(key uint64, name string)
+		UnmapInode(key uint64)
+		UnmapFile(name string)
+		Events() chan Event
+		Lost() chan uint64
+		Stop() error
 	}
 	// Register defines register interface for a watcher
 	Register interface {
@@ -34,6 +53,31 @@ type (
 		Consume(e Event) error
 		Register
 	}
+	// Resyncer is implemented by consumers able to re-parse their files when events were lost
+	Resyncer interface {
+		Resync() error
+	}
+	// Initializer is implemented by consumers building their initial state before being watched
+	Initializer interface {
+		Init() error
+	}
+	// Configurer is implemented by consumers whose options may change on reload, a consumer watching the same
+	// files with other options replaces the running one
+	Configurer interface {
+		SameConfig(consumer Consumer) bool
+	}
+	// DirOwner is implemented by consumers parsing the files of some registered directories themselves,
+	// new files there are handed to them rather than getting their own generic consumer
+	DirOwner interface {
+		OwnsDir(dir string) bool
+	}
+	// reloadRequest carries a new set of consumers to the event loop
+	reloadRequest struct {
+		consumers []Consumer
+		fresh     map[Consumer]bool
+		options   []func(*Watcher)
+		done      chan struct{}
+	}
 	// Consumers map of consumers
 	Consumers struct {
 		zerolog.Logger
@@ -48,15 +92,21 @@ var (
 
 const (
 	renameEvent = 0
+	writeEvent  = 1
 	dirCreate   = 3
 	fileCreate  = 4
+	attrChange  = 5
+	xattrChange = 6
 	delFile     = -1
 	delDir      = -2
+
+	// resyncDelay lets a burst of lost events settle before consumers are re-parsed
+	resyncDelay = time.Second
 )
 
 // NewWatcher function to create new watcher function
 func NewWatcher(options ...func(*Watcher)) *Watcher {
-	watcher := &Watcher{Logger: zerolog.Nop(), consumers: Consumers{zerolog.Nop(), &sync.Map{}}, CloseChannels: make(chan struct{}, 1)}
+	watcher := &Watcher{Logger: zerolog.Nop(), consumers: Consumers{zerolog.Nop(), &sync.Map{}}, CloseChannels: make(chan struct{}, 1), reloads: make(chan reloadRequest)}
 	for _, option := range options {
 		option(watcher)
 	}
@@ -104,8 +154,8 @@ func (w *Watcher) add(file string, consumer Consumer) {
 	case IsNotExist(err):
 		w.Debug().Str("file", file).
 			Msgf("file does not exist polling filesystem")
-		w.consumers.Store(file, NewFileMissing(w.Events, func(fm *FileMissing) {
-			fm.Logger, fm.File, fm.Consumer = w.Logger, file, consumer
+		w.consumers.Store(file, NewFileMissing(w.Events(), func(fm *FileMissing) {
+			fm.Logger, fm.File, fm.Consumer, fm.Metrics = w.Logger, file, consumer, w.Metrics
 		}))
 	default:
 		w.Error().Str("file", file).AnErr("error", err).
@@ -116,8 +166,8 @@ func (w *Watcher) add(file string, consumer Consumer) {
 func (w *Watcher) remove(file string) {
 	if err := w.RemoveFile(file); err != nil {
 		w.Error().Err(err).Str("file", file).Msg("failed to remove consumer")
-		w.consumers.Delete(file)
 	}
+	w.consumers.Delete(file)
 }
 
 func (w *Watcher) addInode(event *Event, isdir bool) {
@@ -131,6 +181,12 @@ func (w *Watcher) addInode(event *Event, isdir bool) {
 	fullPath := path.Join(file, event.Path)
 	event.Path = fullPath
 
+	if owner, ok := w.dirOwner(file); ok {
+		w.Debug().Str("file", event.Path).Msg("file created in a directory parsed by its consumer")
+		w.add(event.Path, owner)
+		return
+	}
+
 	/* Exclude file from monitoring if it belongs to exclusion list
 	w.Excludes is a list of compiled regexp objects
 	*/
@@ -155,7 +211,7 @@ func (w *Watcher) addInode(event *Event, isdir bool) {
 				s.Logger = w.Logger
 			}),
 		}
-		consumer = &BaseConsumer{AgentDB: w.Database, ParserLoader: state}
+		consumer = &BaseConsumer{AgentDB: w.Database, ParserLoader: state, Alerts: w.Alerts, Metrics: w.Metrics}
 	} else {
 		state := &GenericState{
 			GenericListener: NewGenericListener(func(l *GenericListener) {
@@ -163,9 +219,10 @@ func (w *Watcher) addInode(event *Event, isdir bool) {
 				l.IsDir = isdir
 				l.Logger = w.Logger
 				l.Key = w.Key
+				l.Digest = w.Digest
 			}),
 		}
-		consumer = &BaseConsumer{AgentDB: w.Database, ParserLoader: state}
+		consumer = &BaseConsumer{AgentDB: w.Database, ParserLoader: state, Alerts: w.Alerts, Metrics: w.Metrics}
 	}
 
 	w.Consumers = append(w.Consumers, consumer)
@@ -178,8 +235,8 @@ func (w *Watcher) addInode(event *Event, isdir bool) {
 	case IsNotExist(err):
 		w.Debug().Str("file", event.Path).
 			Msgf("file does not exist polling filesystem")
-		w.consumers.Store(event.Path, NewFileMissing(w.Events, func(fm *FileMissing) {
-			fm.Logger, fm.File, fm.Consumer = w.Logger, event.Path, consumer
+		w.consumers.Store(event.Path, NewFileMissing(w.Events(), func(fm *FileMissing) {
+			fm.Logger, fm.File, fm.Consumer, fm.Metrics = w.Logger, event.Path, consumer, w.Metrics
 		}))
 	default:
 		w.Error().Str("file", event.Path).AnErr("error", err).
@@ -187,6 +244,23 @@ func (w *Watcher) addInode(event *Event, isdir bool) {
 	}
 }
 
+// dirOwner returns the consumer of a directory if it parses the files of the directory itself
+func (w *Watcher) dirOwner(dir string) (Consumer, bool) {
+	value, ok := w.consumers.Load(dir)
+	if !ok {
+		return nil, false
+	}
+	consumer, ok := value.(Consumer)
+	if !ok {
+		return nil, false
+	}
+	owner, ok := consumer.(DirOwner)
+	if !ok || !owner.OwnsDir(dir) {
+		return nil, false
+	}
+	return consumer, true
+}
+
 func (w *Watcher) removeInode(key uint64) {
 	file, err := w.RemoveInode(key)
 	if err != nil {
@@ -213,6 +287,8 @@ func (w *Watcher) Start() error {
 		}
 	}()
 	w.Debug().Msgf("consumer Count: %v", len(w.Consumers))
+	w.Health.SetWatching(true)
+	defer w.Health.SetWatching(false)
 	for _, consumer := range w.Consumers {
 		consumer.Register().Range(func(key, value interface{}) bool {
 			stringFile, ok := key.(string)
@@ -231,9 +307,21 @@ func (w *Watcher) Start() error {
 			return true
 		})
 	}
+	var resync <-chan time.Time
 	for {
 		select {
-		case event := <-w.Events:
+		case count := <-w.Lost():
+			w.Metrics.RecordByEventsLost(count)
+			if resync == nil {
+				resync = time.After(resyncDelay)
+			}
+		case <-resync:
+			resync = nil
+			go w.resync()
+		case request := <-w.reloads:
+			w.apply(request)
+			close(request.done)
+		case event := <-w.Events():
 			// Send metric to graphite for every event caught, increement by 1
 			w.Metrics.RecordByEventsCaught()
 			switch event.Mode {
@@ -252,6 +340,11 @@ func (w *Watcher) Start() error {
 					w.Error().Msgf("unable to handle rename properly: %s", err)
 				}
 			}
+			if event.Rule = w.Rules.Match(event); event.Rule != nil {
+				w.Metrics.RecordBySuppressedEvents(event.Rule.Action)
+				w.Debug().Str("rule", event.Rule.Name).Str("action", event.Rule.Action).
+					Str("file", event.Path).Msg("event matched rule")
+			}
 			w.Debug().Object("event", LogEvent(event)).Msg("event caught")
 			consumer, err := w.consumers.get(event.Path)
 			if err != nil {
@@ -279,27 +372,15 @@ func (w *Watcher) Start() error {
 					continue
 				}
 			}
+			kind := ConsumerKind(consumer)
+			w.Metrics.RecordConsumersInFlight(kind, 1)
 			go func(consumer Consumer, event Event) {
+				defer w.Metrics.RecordConsumersInFlight(kind, -1)
 				switch err := consumer.Consume(event); err {
 				case nil: // do nothing on nil
 				case ErrReload:
-					w.Debug().Msg("Reload triggered")
-					consumer.Register().Range(func(key, value interface{}) bool {
-						stringFile, ok := key.(string)
-						if !ok {
-							w.Error().Msg("error casting file string from register")
-							return false
-						}
-						consumerValue, ok := value.(Consumer)
-						if !ok {
-							w.Error().Msg("error casting consumer from register")
-							return false
-						}
-						w.Debug().Msg("Reloading consumers")
-						w.remove(stringFile)
-						w.add(stringFile, consumerValue)
-						return true
-					})
+					w.Metrics.RecordConsumer(MetricReloads, kind, PathClass(event.Path))
+					w.reload(consumer)
 				default:
 					w.Error().AnErr("error", err).Str("file", event.Path).Msg("consumer failed")
 				}
@@ -318,11 +399,177 @@ func (w *Watcher) Start() error {
 	}
 }
 
+// Reload method to apply a new set of consumers to a running watcher, without restarting its event source.
+// Files watched before and after keep their running consumer unless its options changed, consumers of new files
+// and reconfigured ones are initialized first.
+func (w *Watcher) Reload(consumers []Consumer, options ...func(*Watcher)) {
+	request := reloadRequest{consumers: consumers, fresh: make(map[Consumer]bool), options: options, done: make(chan struct{})}
+	for _, consumer := range consumers {
+		if w.watched(consumer) {
+			continue
+		}
+		request.fresh[consumer] = true
+		if initializer, ok := consumer.(Initializer); ok {
+			if err := initializer.Init(); err != nil {
+				w.Error().Err(err).Msg("failed to init consumer")
+			}
+		}
+	}
+	select {
+	case w.reloads <- request:
+		<-request.done
+	case <-w.CloseChannels:
+	}
+}
+
+// watched checks if every file of a consumer is already watched, by a consumer with the same configuration
+func (w *Watcher) watched(consumer Consumer) bool {
+	watched := true
+	consumer.Register().Range(func(key, value interface{}) bool {
+		var running Consumer
+		if running, watched = w.running(key); watched {
+			if configurer, ok := value.(Configurer); ok {
+				watched = configurer.SameConfig(running)
+			}
+		}
+		return watched
+	})
+	return watched
+}
+
+// running returns the consumer of a watched file, the one waited for when the file is missing
+func (w *Watcher) running(file interface{}) (Consumer, bool) {
+	value, ok := w.consumers.Load(file)
+	if missing, isMissing := value.(*FileMissing); isMissing {
+		return missing.Consumer, true
+	}
+	consumer, isConsumer := value.(Consumer)
+	return consumer, ok && isConsumer
+}
+
+// apply swaps the consumers from the event loop, so that no event is handled by a half reloaded watcher
+func (w *Watcher) apply(request reloadRequest) {
+	next := make(map[string]Consumer)
+	for _, consumer := range request.consumers {
+		consumer.Register().Range(func(key, value interface{}) bool {
+			stringFile, ok := key.(string)
+			if !ok {
+				w.Error().Msg("error casting file string from register")
+				return false
+			}
+			consumerValue, ok := value.(Consumer)
+			if !ok {
+				w.Error().Msg("error casting consumer from register")
+				return false
+			}
+			next[stringFile] = consumerValue
+			return true
+		})
+	}
+
+	var added, removed []string
+	for _, file := range w.consumers.Files() {
+		if _, ok := next[file]; ok {
+			continue
+		}
+		if value, _ := w.consumers.Load(file); isFileMissing(value) {
+			w.consumers.Delete(file) // never made it to the event source
+		} else {
+			w.remove(file)
+		}
+		removed = append(removed, file)
+	}
+	for file, consumer := range next {
+		if _, ok := w.consumers.Load(file); ok && !request.fresh[consumer] {
+			continue
+		}
+		w.add(file, consumer)
+		added = append(added, file)
+	}
+
+	var consumers []Consumer
+	for _, consumer := range w.Consumers {
+		kept := false
+		consumer.Register().Range(func(key, value interface{}) bool {
+			running, _ := w.running(key)
+			kept = running == value
+			return !kept
+		})
+		if kept {
+			consumers = append(consumers, consumer)
+		}
+	}
+	for _, consumer := range request.consumers {
+		if request.fresh[consumer] {
+			consumers = append(consumers, consumer)
+		}
+	}
+	w.Consumers = consumers
+	for _, option := range request.options {
+		option(w)
+	}
+	w.Info().Strs("added", added).Strs("removed", removed).Int("consumers", len(w.Consumers)).
+		Msg("configuration reloaded")
+}
+
+func isFileMissing(consumer interface{}) bool {
+	_, ok := consumer.(*FileMissing)
+	return ok
+}
+
+func (w *Watcher) reload(consumer Consumer) {
+	w.Debug().Msg("Reload triggered")
+	consumer.Register().Range(func(key, value interface{}) bool {
+		stringFile, ok := key.(string)
+		if !ok {
+			w.Error().Msg("error casting file string from register")
+			return false
+		}
+		consumerValue, ok := value.(Consumer)
+		if !ok {
+			w.Error().Msg("error casting consumer from register")
+			return false
+		}
+		w.Debug().Msg("Reloading consumers")
+		w.remove(stringFile)
+		w.add(stringFile, consumerValue)
+		return true
+	})
+}
+
+// resync re-parses every watched consumer once, as changes may have gone unnoticed when events were lost
+func (w *Watcher) resync() {
+	var resyncers []Consumer
+	seen := make(map[Consumer]bool)
+	w.consumers.Range(func(key, value interface{}) bool {
+		consumer, ok := value.(Consumer)
+		if !ok {
+			w.Error().Msg("error casting consumer from consumer map")
+			return true
+		}
+		if _, ok := consumer.(Resyncer); ok && !seen[consumer] {
+			seen[consumer] = true
+			resyncers = append(resyncers, consumer)
+		}
+		return true
+	})
+	w.Info().Int("consumers", len(resyncers)).Msg("events were lost, resyncing consumers")
+	for _, consumer := range resyncers {
+		switch err := consumer.(Resyncer).Resync(); err {
+		case nil:
+		case ErrReload:
+			w.reload(consumer)
+		default:
+			w.Error().Err(err).Msg("consumer resync failed")
+		}
+	}
+}
+
 func (w *Watcher) handleRenamingEvent(event *Event) error {
 	// delete mapping and consumer of a source file if we have that
 	if sourcePath, _ := w.GetFileFromInode(event.Device); sourcePath != "" {
 		w.consumers.Delete(sourcePath)
-		w.reverse.Delete(sourcePath)
+		w.UnmapFile(sourcePath)
 	}
 
 	if event.NewDevice == 0 { // renaming to non-existing file
@@ -334,8 +581,7 @@ func (w *Watcher) handleRenamingEvent(event *Event) error {
 
 		targetPath := path.Join(targetDir, event.Path)
 
-		w.mapping.Store(event.Device, targetPath)
-		w.reverse.Store(targetPath, event.Device)
+		w.MapInode(event.Device, targetPath)
 		event.Inode = event.NewInode // let's pretend we are creating a new file
 		w.addInode(event, false)     // TODO: implicit - proper event.Path is assigned in that function
 	} else { // renaming to existing file
@@ -345,7 +591,7 @@ func (w *Watcher) handleRenamingEvent(event *Event) error {
 			return err
 		}
 
-		w.mapping.Delete(event.NewDevice) // delete inode->name relation for old inode
+		w.UnmapInode(event.NewDevice) // delete inode->name relation for old inode
 
 		// this function add ebpf rules for new inode but keeping consumer for old path
 		// that's exactly that we need
@@ -361,9 +607,10 @@ func (w *Watcher) handleRenamingEvent(event *Event) error {
 	return nil
 }
 
-// Stop method to clean up anc gracefully exit the watcher and BPF
+// Stop method to clean up anc gracefully exit the watcher and its event source
 func (w *Watcher) Stop() error {
 	close(w.CloseChannels)
-	w.Logger.Debug().Msg("gracefully exiting BPF")
-	return w.StopBPF()
+	w.Logger.Debug().Msg("gracefully exiting event source")
+	w.Health.SetLoaded(false)
+	return w.EventSource.Stop()
 }
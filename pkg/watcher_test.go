@@ -0,0 +1,208 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/afero"
+)
+
+type testSource struct {
+	fileMapping
+	events chan Event
+	lost   chan uint64
+}
+
+func (s testSource) AddFile(name string) error              { return nil }
+func (s testSource) RemoveFile(name string) error           { return nil }
+func (s testSource) RemoveInode(key uint64) (string, error) { return "", nil }
+func (s testSource) Events() chan Event                     { return s.events }
+func (s testSource) Lost() chan uint64                      { return s.lost }
+func (s testSource) Stop() error                            { return nil }
+
+type testConsumer struct {
+	files []string
+	inits int
+}
+
+func (c *testConsumer) Consume(Event) error { return nil }
+func (c *testConsumer) Init() error         { c.inits++; return nil }
+func (c *testConsumer) Register() *sync.Map {
+	register := &sync.Map{}
+	for _, file := range c.files {
+		register.Store(file, c)
+	}
+	return register
+}
+
+func TestWatcherReload(t *testing.T) {
+	dir := "/etc"
+	passwd, hosts, resolv := dir+"/passwd", dir+"/hosts", dir+"/resolv.conf"
+	users, generic := &testConsumer{files: []string{passwd}}, &testConsumer{files: []string{hosts}}
+	w := NewWatcher(func(w *Watcher) {
+		w.EventSource = testSource{fileMapping: newFileMapping(), events: make(chan Event), lost: make(chan uint64)}
+		w.Consumers = []Consumer{users, generic}
+	})
+	w.add(passwd, users)
+	w.add(hosts, generic)
+	go func() { _ = w.Start() }()
+	defer func() { _ = w.Stop() }()
+
+	reloadedUsers, added := &testConsumer{files: []string{passwd}}, &testConsumer{files: []string{resolv}}
+	w.Reload([]Consumer{reloadedUsers, added}, func(w *Watcher) { w.GenericDiff = []string{dir} })
+
+	files := w.consumers.Files()
+	sort.Strings(files)
+	if len(files) != 2 || files[0] != passwd || files[1] != resolv {
+		t.Errorf("Reload want watched files: %v, got: %v", []string{passwd, resolv}, files)
+	}
+	if consumer, _ := w.consumers.get(passwd); consumer != users {
+		t.Error("Reload want the running consumer of a file still watched to be kept")
+	}
+	if reloadedUsers.inits != 0 || added.inits != 1 {
+		t.Errorf("Reload want only new consumers initialized, got: %d, %d", reloadedUsers.inits, added.inits)
+	}
+	if len(w.Consumers) != 2 || w.Consumers[0] != users || w.Consumers[1] != added {
+		t.Errorf("Reload want consumers: %v, got: %v", []Consumer{users, added}, w.Consumers)
+	}
+	if len(w.GenericDiff) != 1 || w.GenericDiff[0] != dir {
+		t.Errorf("Reload want options applied, got: %v", w.GenericDiff)
+	}
+}
+
+func TestWatcherReloadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	group := filepath.Join(dir, "group")
+	if err := ioutil.WriteFile(group, []byte("wheel:x:10:alice\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	db, closeDB := openTestDB(t)
+	defer closeDB()
+	groups := func(logger zerolog.Logger, privileged ...string) *BaseConsumer {
+		return &BaseConsumer{AgentDB: db, ParserLoader: &GroupsState{GroupsListener: NewGroupsListener(func(l *GroupsListener) {
+			l.Fs, l.Group, l.Privileged, l.Logger = afero.NewOsFs(), group, privileged, logger
+		})}}
+	}
+	running := groups(zerolog.Nop(), "wheel")
+	w := NewWatcher(func(w *Watcher) {
+		w.EventSource = testSource{fileMapping: newFileMapping(), events: make(chan Event), lost: make(chan uint64)}
+		w.Consumers = []Consumer{running}
+	})
+	w.add(group, running)
+	go func() { _ = w.Start() }()
+	defer func() { _ = w.Stop() }()
+
+	// a new logger is not a new configuration
+	w.Reload([]Consumer{groups(zerolog.New(ioutil.Discard), "wheel")})
+	if consumer, _ := w.consumers.get(group); consumer != running || len(w.Consumers) != 1 || w.Consumers[0] != running {
+		t.Errorf("Reload want the running consumer kept with the same options, got: %v", w.Consumers)
+	}
+
+	reconfigured := groups(zerolog.Nop(), "wheel", "docker")
+	w.Reload([]Consumer{reconfigured})
+	if consumer, _ := w.consumers.get(group); consumer != reconfigured || len(w.Consumers) != 1 || w.Consumers[0] != reconfigured {
+		t.Errorf("Reload want the consumer replaced with new options, got: %v", w.Consumers)
+	}
+
+	notifying := groups(zerolog.Nop(), "wheel", "docker")
+	notifying.NotifyOnEmptyDB = true
+	w.Reload([]Consumer{notifying})
+	if consumer, _ := w.consumers.get(group); consumer != notifying {
+		t.Error("Reload want the consumer replaced when NotifyOnEmptyDB changed")
+	}
+}
+
+type testResyncer struct {
+	files   []string
+	resyncs chan string
+}
+
+func (c *testResyncer) Consume(Event) error { return nil }
+func (c *testResyncer) Resync() error       { c.resyncs <- c.files[0]; return nil }
+func (c *testResyncer) Register() *sync.Map {
+	register := &sync.Map{}
+	for _, file := range c.files {
+		register.Store(file, c)
+	}
+	return register
+}
+
+// lines hands every line logged to the test
+type lines chan string
+
+func (l lines) Write(p []byte) (int, error) { l <- string(p); return len(p), nil }
+
+func TestWatcherLostResync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpfink_resync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwd, shadow := filepath.Join(dir, "passwd"), filepath.Join(dir, "shadow")
+	if err := ioutil.WriteFile(passwd, []byte("alice:x:1000:1000::"+dir+":/bin/sh\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(shadow, []byte("alice:$6$salt$first:18000:0:99999:7:::\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	db, closeDB := openTestDB(t)
+	defer closeDB()
+	output := make(lines, 1)
+	users := &BaseConsumer{AgentDB: db, ParserLoader: &UsersState{UsersListener: NewUsersListener(func(l *UsersListener) {
+		l.Fs, l.Passwd, l.Shadow, l.Logger = afero.NewOsFs(), passwd, shadow, zerolog.New(output).Level(zerolog.WarnLevel)
+	})}}
+	if err := users.Init(); err != nil {
+		t.Fatal(err)
+	}
+	resyncer := &testResyncer{files: []string{"/etc/hosts"}, resyncs: make(chan string, 2)}
+
+	m := InitMetrics()
+	defer m.EveryMinuteRegister.UnregisterAll()
+	source := testSource{fileMapping: newFileMapping(), events: make(chan Event), lost: make(chan uint64)}
+	w := NewWatcher(func(w *Watcher) {
+		w.EventSource, w.Consumers, w.Metrics = source, []Consumer{users, resyncer}, &m
+	})
+	go func() { _ = w.Start() }()
+	defer func() { _ = w.Stop() }()
+
+	// the change is made without any event reaching the watcher, as if it was lost
+	if err := ioutil.WriteFile(shadow, []byte("alice:$6$salt$second:18000:0:99999:7:::\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	source.lost <- 2
+	source.lost <- 3
+
+	timeout := time.After(5 * time.Second)
+	select {
+	case file := <-resyncer.resyncs:
+		if file != "/etc/hosts" {
+			t.Errorf("want the consumer of /etc/hosts resynced, got: %s", file)
+		}
+	case <-timeout:
+		t.Fatal("want the consumers resynced once events were lost")
+	}
+	select {
+	case line := <-output:
+		if !strings.Contains(line, `"resync":true`) || !strings.Contains(line, "Users Modified detected by resync") {
+			t.Errorf("want the change found by resync reported, got: %s", line)
+		}
+	case <-timeout:
+		t.Fatal("want the change missed by lost events found by resync")
+	}
+	select {
+	case <-resyncer.resyncs:
+		t.Error("want a burst of lost events to resync consumers once")
+	case <-time.After(resyncDelay):
+	}
+}
@@ -0,0 +1,353 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// SinkWebhook posts batches of alerts to an HTTP endpoint
+	SinkWebhook = "webhook"
+
+	defaultWebhookBatchSize     = 100
+	defaultWebhookFlushInterval = 5 * time.Second
+	defaultWebhookTimeout       = 10 * time.Second
+	defaultWebhookMinBackoff    = time.Second
+	defaultWebhookMaxBackoff    = 5 * time.Minute
+	defaultWebhookMaxQueue      = 100000
+)
+
+// WebhookSink struct posting alerts as JSON arrays, of ECS documents if the Encoder is one. Alerts are queued in the database first and
+// only dequeued once the endpoint acknowledged them with a 2xx status, so that they survive an
+// outage of the endpoint or a restart of the agent. Delivery is at least once.
+// Failed deliveries are retried until they succeed, only a batch the endpoint rejects for its payload, with a 400, 413 or
+// 422 status, is dropped so that it does not block the queue. MaxAttempts limits the retries when set.
+type WebhookSink struct {
+	zerolog.Logger
+	URL     string
+	Headers map[string]string
+	// CA, Cert and Key are PEM files, the CA verifies the endpoint, Cert and Key authenticate the agent
+	CA, Cert, Key string
+	// BatchSize alerts trigger a delivery, smaller batches are sent every FlushInterval
+	BatchSize     int
+	FlushInterval time.Duration
+	Timeout       time.Duration
+	// failed deliveries are retried after a backoff doubling from MinBackoff up to MaxBackoff, forever unless MaxAttempts is set
+	MinBackoff, MaxBackoff time.Duration
+	MaxAttempts            int
+	Database               *AgentDB
+	Metrics                *Metrics
+	Encoder                Encoder
+	// MaxQueue alerts at most are queued, alerts sent while it is full are dropped
+	MaxQueue int
+
+	client  *http.Client
+	mux     sync.Mutex
+	started bool
+	depth   int
+	pending chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewWebhookSink function to create a webhook sink, alerts left in its queue are delivered first once started
+func NewWebhookSink(options ...func(*WebhookSink)) (*WebhookSink, error) {
+	ws := &WebhookSink{
+		Logger:        zerolog.Nop(),
+		BatchSize:     defaultWebhookBatchSize,
+		FlushInterval: defaultWebhookFlushInterval,
+		Timeout:       defaultWebhookTimeout,
+		MinBackoff:    defaultWebhookMinBackoff,
+		MaxBackoff:    defaultWebhookMaxBackoff,
+		MaxQueue:      defaultWebhookMaxQueue,
+		pending:       make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	for _, option := range options {
+		option(ws)
+	}
+	if ws.URL == "" || ws.Database == nil {
+		return nil, fmt.Errorf("webhook sink needs an url and a database")
+	}
+	if _, ok := ws.Encoder.(CEFEncoder); ok {
+		return nil, fmt.Errorf("webhook sink posts JSON, %q format is not supported", FormatCEF)
+	}
+	tlsConfig, err := ws.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	ws.client = &http.Client{Timeout: ws.Timeout, Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}}
+	return ws, nil
+}
+
+// Start method starts the deliveries. A sink replacing another one on the same queue, i.e. on reload, is started
+// once the previous one is closed so that batches are not posted twice.
+func (ws *WebhookSink) Start() error {
+	ws.mux.Lock()
+	defer ws.mux.Unlock()
+	if ws.started {
+		return nil
+	}
+	depth, err := ws.Database.QueueDepth(ws.queue())
+	if err != nil {
+		return err
+	}
+	ws.depth, ws.started = depth, true
+	ws.recordDepth(depth)
+	go ws.run()
+	return nil
+}
+
+func (ws *WebhookSink) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	if ws.CA != "" {
+		pem, err := ioutil.ReadFile(ws.CA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", ws.CA)
+		}
+	}
+	if ws.Cert != "" || ws.Key != "" {
+		certificate, err := tls.LoadX509KeyPair(ws.Cert, ws.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// queue names the queue of the sink after its endpoint, so that a restart picks it up
+func (ws *WebhookSink) queue() string {
+	return SinkWebhook + " " + ws.URL
+}
+
+// name identifies the sink in metrics, its URL without credentials nor query which may hold tokens
+func (ws *WebhookSink) name() string {
+	endpoint, err := url.Parse(ws.URL)
+	if err != nil {
+		return Unknown
+	}
+	endpoint.User, endpoint.RawQuery, endpoint.Fragment = nil, "", ""
+	return endpoint.String()
+}
+
+func (ws *WebhookSink) recordDepth(depth int) {
+	if ws.Metrics != nil {
+		ws.Metrics.RecordWebhookQueueDepth(ws.name(), depth)
+	}
+}
+
+// Send method queues an alert, a full batch is delivered right away
+func (ws *WebhookSink) Send(alert Alert) error {
+	bytes, err := encode(ws.Encoder, alert)
+	if err != nil {
+		return err
+	}
+	// the depth is counted along with the queue, which Start reads
+	ws.mux.Lock()
+	if ws.depth >= ws.MaxQueue {
+		depth := ws.depth
+		ws.mux.Unlock()
+		ws.recordDropped(1)
+		return fmt.Errorf("webhook queue full, %d alerts waiting", depth)
+	}
+	if err := ws.Database.Enqueue(ws.queue(), bytes); err != nil {
+		ws.mux.Unlock()
+		return err
+	}
+	ws.depth++
+	depth := ws.depth
+	ws.mux.Unlock()
+	ws.recordDepth(depth)
+	if depth >= ws.BatchSize {
+		select {
+		case ws.pending <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// webhookStatusError is the status of a rejected delivery
+type webhookStatusError struct {
+	status string
+	code   int
+}
+
+func (e webhookStatusError) Error() string { return fmt.Sprintf("unexpected status %s", e.status) }
+
+// permanent reports whether the endpoint rejects the payload itself, sending the batch again would fail the same way
+func (e webhookStatusError) permanent() bool {
+	switch e.code {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return true
+	default:
+		return false
+	}
+}
+
+// refused reports whether the endpoint refuses the agent rather than the batch, i.e. an expired token or a wrong URL
+func (e webhookStatusError) refused() bool {
+	return e.code == http.StatusUnauthorized || e.code == http.StatusForbidden || e.code == http.StatusNotFound
+}
+
+// run delivers batches until the sink is closed, backing off while the endpoint fails
+func (ws *WebhookSink) run() {
+	defer close(ws.done)
+	ticker := time.NewTicker(ws.FlushInterval)
+	defer ticker.Stop()
+	backoff := time.Duration(0)
+	attempts := 0
+	for {
+		batch, err := ws.deliver()
+		if err == nil {
+			backoff, attempts = 0, 0
+			if batch == ws.BatchSize {
+				// more alerts are likely queued
+				continue
+			}
+			select {
+			case <-ws.stop:
+				return
+			case <-ticker.C:
+			case <-ws.pending:
+			}
+			continue
+		}
+
+		if ws.Metrics != nil {
+			ws.Metrics.RecordWebhookFailures(ws.name())
+		}
+		attempts++
+		status, ok := err.(webhookStatusError)
+		if batch != 0 && ((ok && status.permanent()) || (ws.MaxAttempts > 0 && attempts >= ws.MaxAttempts)) {
+			ws.Error().Err(err).Str("url", ws.name()).Int("attempts", attempts).Int("alerts", batch).Msg("dropping undeliverable alerts")
+			if err := ws.drop(batch); err != nil {
+				ws.Error().Err(err).Str("url", ws.name()).Msg("failed to drop alerts")
+			} else {
+				backoff, attempts = 0, 0
+				continue
+			}
+		}
+		if backoff *= 2; backoff < ws.MinBackoff {
+			backoff = ws.MinBackoff
+		} else if backoff > ws.MaxBackoff {
+			backoff = ws.MaxBackoff
+		}
+		if ok && status.refused() {
+			ws.Error().Err(err).Str("url", ws.name()).Dur("retry", backoff).Int("attempts", attempts).
+				Msg("webhook refused the agent, alerts are kept queued until its credentials or url are fixed")
+		} else {
+			ws.Error().Err(err).Str("url", ws.name()).Dur("retry", backoff).Msg("failed to deliver alerts")
+		}
+		select {
+		case <-ws.stop:
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// deliver posts the batch at the head of the queue, and dequeues it once acknowledged.
+// The size of the batch is returned, whether it was delivered or not.
+func (ws *WebhookSink) deliver() (int, error) {
+	keys, values, err := ws.Database.Peek(ws.queue(), ws.BatchSize)
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	body := bytes.NewBufferString("[")
+	for i, value := range values {
+		if i != 0 {
+			body.WriteByte(',')
+		}
+		body.Write(value)
+	}
+	body.WriteByte(']')
+
+	request, err := http.NewRequest(http.MethodPost, ws.URL, body)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range ws.Headers {
+		request.Header.Set(name, value)
+	}
+	response, err := ws.client.Do(request)
+	if err != nil {
+		return len(keys), err
+	}
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+	_ = response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return len(keys), webhookStatusError{status: response.Status, code: response.StatusCode}
+	}
+
+	if err := ws.Database.Dequeue(ws.queue(), keys); err != nil {
+		return len(keys), err
+	}
+	ws.mux.Lock()
+	ws.depth -= len(keys)
+	depth := ws.depth
+	ws.mux.Unlock()
+	ws.recordDepth(depth)
+	if ws.Metrics != nil {
+		ws.Metrics.RecordWebhookDelivered(ws.name(), len(keys))
+	}
+	ws.Debug().Str("url", ws.URL).Int("alerts", len(keys)).Msg("alerts delivered")
+	return len(keys), nil
+}
+
+// drop dequeues the batch at the head of the queue without delivering it
+func (ws *WebhookSink) drop(batch int) error {
+	keys, _, err := ws.Database.Peek(ws.queue(), batch)
+	if err != nil {
+		return err
+	}
+	if err := ws.Database.Dequeue(ws.queue(), keys); err != nil {
+		return err
+	}
+	ws.mux.Lock()
+	ws.depth -= len(keys)
+	depth := ws.depth
+	ws.mux.Unlock()
+	ws.recordDepth(depth)
+	ws.recordDropped(len(keys))
+	return nil
+}
+
+func (ws *WebhookSink) recordDropped(count int) {
+	if ws.Metrics != nil {
+		ws.Metrics.RecordWebhookDropped(ws.name(), count)
+	}
+}
+
+// Close method stops the deliveries, waiting for the one in flight, undelivered alerts stay queued for the next start
+func (ws *WebhookSink) Close() error {
+	ws.mux.Lock()
+	started := ws.started
+	ws.started = true // a closed sink is not started again
+	ws.mux.Unlock()
+	close(ws.stop)
+	if started {
+		<-ws.done
+	}
+	return nil
+}